@@ -4,13 +4,98 @@ Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
 package cmd
 
 import (
-	"os"
+	"context"
+	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/mdaashir/NSM/utils"
 	"github.com/spf13/cobra"
 )
 
+// parsePackageArgs normalizes and de-duplicates package arguments, returning
+// the valid package specs and the arguments that are not valid
+func parsePackageArgs(args []string) (requested []utils.PackageSpec, invalid []string) {
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		spec, err := utils.ParsePackageSpec(arg)
+		if err != nil {
+			invalid = append(invalid, arg)
+			continue
+		}
+		if !seen[spec.String()] {
+			seen[spec.String()] = true
+			requested = append(requested, spec)
+		}
+	}
+	return requested, invalid
+}
+
+// resolvePackageSpecs maps package specs to the attributes to add. Versioned
+// specs are recorded on the editor as constraints; those resolved from a
+// nixpkgs revision other than the project's are returned in sources.
+func resolvePackageSpecs(ctx context.Context, configType string, editor *utils.NixEditor, specs []utils.PackageSpec) (attrs []string, sources map[string]utils.NixpkgsSource, err error) {
+	sources = make(map[string]utils.NixpkgsSource)
+	for _, spec := range specs {
+		if spec.Version == "" {
+			attrs = append(attrs, spec.Attr)
+			continue
+		}
+
+		resolution, err := utils.ResolvePackageSpec(ctx, configType, spec)
+		if err != nil {
+			utils.Tip("Run 'nsm versions list %s' to see the known versions", spec.Attr)
+			utils.Tip("Use 'nsm versions index <revision>' to learn the versions of a nixpkgs commit")
+			return nil, nil, fmt.Errorf("could not resolve %s: %w", spec, err)
+		}
+
+		if resolution.Source != nil {
+			sources[resolution.Attr] = *resolution.Source
+			utils.Info("Resolved %s to %s %s from nixpkgs %s", spec, resolution.Attr, resolution.Version, shortRevision(resolution.Source.Revision))
+		} else {
+			utils.Info("Resolved %s to %s (%s)", spec, resolution.Attr, resolution.Version)
+		}
+		editor.Annotate(resolution.Attr, spec.String())
+		attrs = append(attrs, resolution.Attr)
+	}
+	return attrs, sources, nil
+}
+
+// verifyPackagesExist evaluates the project's nixpkgs and reports every
+// requested package it does not provide
+func verifyPackagesExist(ctx context.Context, configType string, pkgs []string) error {
+	utils.Debug("Checking %d package(s) against nixpkgs", len(pkgs))
+	missing, err := utils.FindMissingPackages(ctx, configType, pkgs)
+	if err != nil {
+		utils.Tip("Use --no-verify to add packages without checking them")
+		return fmt.Errorf("could not verify packages against nixpkgs: %w", err)
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	index, err := utils.LoadPackageIndex(ctx)
+	if err != nil {
+		utils.Debug("Package index unavailable, skipping suggestions: %v", err)
+	}
+	for _, pkg := range missing {
+		utils.Error("Package '%s' was not found in nixpkgs", pkg)
+		if index != nil {
+			tipSimilarPackages(pkg, index.Names)
+		}
+	}
+	utils.Tip("Run 'nsm search <name>' to look up package names")
+	return fmt.Errorf("%w: %d package(s) not found in nixpkgs", utils.ErrInvalidPackage, len(missing))
+}
+
+// tipSimilarPackages prints "did you mean" suggestions for an unknown package
+func tipSimilarPackages(pkg string, candidates []string) {
+	if suggestions := utils.SuggestPackages(pkg, candidates, 3); len(suggestions) > 0 {
+		utils.Tip("Did you mean %s?", strings.Join(suggestions, ", "))
+	}
+}
+
 var addCmd = &cobra.Command{
 	Use:   "add [packages...]",
 	Short: "Add one or more packages to the nix environment",
@@ -18,104 +103,159 @@ var addCmd = &cobra.Command{
 
 Usage:
   nsm add <package1> [package2...]  # Add one or more packages
+  nsm add <package>@<version>       # Add a specific version
 
 Examples:
   nsm add gcc                     # Add single package
   nsm add python3 nodejs git      # Add multiple packages
-  nsm add go rustc cargo         # Add development toolchains`,
+  nsm add go rustc cargo         # Add development toolchains
+  nsm add python3Packages.numpy  # Add a package from a nested package set
+  nsm add python3@3.11 nodejs@18 # Add specific versions
+  nsm add --shell ci jq           # Add to the devShells.ci shell of a flake
+
+A versioned package resolves to the attribute providing that version, such
+as python311 for python3@3.11. When the project's nixpkgs has no such
+attribute, the package is imported from a nixpkgs revision that provides
+the version (see 'nsm versions'). The requested version is kept as a
+"# nsm:" comment next to the package.
+
+Packages are checked against the project's nixpkgs before the file is
+changed. Use --no-verify to skip the check when working offline.`,
 	Args: cobra.MinimumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		// Check for Nix installation
 		if err := utils.CheckNixInstallation(); err != nil {
-			utils.Error("Nix is not installed. Please install Nix first!")
-			return
+			return err
 		}
 
 		configType := utils.GetProjectConfigType()
 		if configType == "" {
-			utils.Error("No shell.nix or flake.nix found")
 			utils.Tip("Run 'nsm init' to create a new environment")
-			return
+			return utils.ErrNoProjectConfig
 		}
 
 		utils.Debug("Found configuration file: %s", configType)
 
-		// Validate packages
-		var invalidPkgs []string
-		for _, pkg := range args {
-			if !utils.ValidatePackage(pkg) {
-				invalidPkgs = append(invalidPkgs, pkg)
-			}
-		}
-
+		// Validate packages as nixpkgs attribute paths with optional versions
+		specs, invalidPkgs := parsePackageArgs(args)
 		if len(invalidPkgs) > 0 {
-			utils.Error("Invalid package(s): %s", strings.Join(invalidPkgs, ", "))
+			utils.Tip("Package names are attribute paths such as 'gcc' or 'python3Packages.numpy'")
+			utils.Tip("Request a version with <package>@<version>, such as 'python3@3.11'")
 			utils.Tip("Check package names in https://search.nixos.org")
-			return
-		}
-
-		// Create backup before modifying
-		if err := utils.BackupFile(configType); err != nil {
-			utils.Error("Failed to create backup: %v", err)
-			return
+			return fmt.Errorf("%w: %s", utils.ErrInvalidPackage, strings.Join(invalidPkgs, ", "))
 		}
 
 		// Read an existing file
 		content, err := utils.ReadFile(configType)
 		if err != nil {
-			utils.Error("Error reading %s: %v", configType, err)
-			return
+			return fmt.Errorf("error reading %s: %w", configType, err)
 		}
 
-		// Find an insertion point based on a file type
-		var pos int
-		if configType == "shell.nix" {
-			pos = strings.Index(content, "];")
-		} else {
-			pos = strings.Index(content, "];") // For flake.nix, find the buildInputs closure
+		// Locate the mkShell package list through the syntax tree
+		editor, err := utils.NewNixEditor(content)
+		if err != nil {
+			return fmt.Errorf("%w: failed to parse %s: %w", utils.ErrValidationFailed, configType, err)
 		}
 
-		if pos == -1 {
-			utils.Error("Could not find package list in %s", configType)
+		if err := selectShell(cmd, configType, editor); err != nil {
+			return err
+		}
+
+		if _, err := editor.TargetList(); err != nil {
 			utils.Tip("Run 'nsm init' to create a properly formatted file")
-			return
+			return fmt.Errorf("%w: could not find package list in %s", utils.ErrValidationFailed, configType)
+		}
+
+		// Find the attributes providing the requested versions
+		requested, versioned, err := resolvePackageSpecs(cmd.Context(), configType, editor, specs)
+		if err != nil {
+			return err
+		}
+
+		// Check for duplicates against the packages already declared
+		existing := make(map[string]bool)
+		for _, pkg := range editor.Packages() {
+			existing[pkg] = true
 		}
 
-		// Check for duplicate packages
-		var duplicates []string
-		currentContent := content[:pos]
-		for _, pkg := range args {
-			if strings.Contains(currentContent, pkg) {
+		var duplicates, newPkgs []string
+		for _, pkg := range requested {
+			if existing[pkg] || slices.Contains(newPkgs, pkg) {
 				duplicates = append(duplicates, pkg)
+			} else {
+				newPkgs = append(newPkgs, pkg)
 			}
 		}
 
 		if len(duplicates) > 0 {
 			utils.Warn("Package(s) already installed: %s", strings.Join(duplicates, ", "))
-			return
+		}
+		if len(newPkgs) == 0 {
+			return nil
+		}
+
+		// Pinned packages and versions missing from the project's nixpkgs come
+		// from their own nixpkgs revision
+		sources := configPinSources()
+		if sources == nil {
+			sources = make(map[string]utils.NixpkgsSource)
+		}
+		for pkg, source := range versioned {
+			sources[pkg] = source
+		}
+		var pinnedPkgs, plainPkgs []string
+		for _, pkg := range newPkgs {
+			if sources[pkg].Revision != "" {
+				pinnedPkgs = append(pinnedPkgs, pkg)
+			} else {
+				plainPkgs = append(plainPkgs, pkg)
+			}
+		}
+
+		// Make sure every package exists before touching the file
+		if noVerify, _ := cmd.Flags().GetBool("no-verify"); !noVerify {
+			if err := verifyPackagesExist(cmd.Context(), configType, plainPkgs); err != nil {
+				return err
+			}
+		}
+
+		// Insert new packages, keeping the rest of the file untouched
+		if err := editor.AddPackages(plainPkgs); err != nil {
+			return fmt.Errorf("failed to add packages to %s: %w", configType, err)
+		}
+		for _, pkg := range pinnedPkgs {
+			if err := editor.PinPackage(pkg, sources[pkg]); err != nil {
+				return fmt.Errorf("failed to add pinned package %s to %s: %w", pkg, configType, err)
+			}
+			if _, ok := versioned[pkg]; !ok {
+				utils.Info("📌 %s is pinned to nixpkgs %s", pkg, shortRevision(sources[pkg].Revision))
+			}
 		}
 
-		// Build the new packages section
-		newPackages := ""
-		for _, pkg := range args {
-			newPackages += "    " + pkg + "\n"
+		newContent, err := editor.Result()
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %w", configType, err)
 		}
 
-		// Insert new packages
-		newContent := content[:pos] + newPackages + content[pos:]
+		// Create backup before modifying
+		if err := utils.BackupFile(configType); err != nil {
+			return fmt.Errorf("failed to create backup: %w", err)
+		}
 
 		// Write back with secure permissions
-		err = os.WriteFile(configType, []byte(newContent), 0600)
+		err = utils.WriteFileAtomic(configType, []byte(newContent), 0600)
 		if err != nil {
-			utils.Error("Error writing to %s: %v", configType, err)
-			return
+			return fmt.Errorf("error writing to %s: %w", configType, err)
 		}
 
-		utils.Success("Added package(s): %s", strings.Join(args, ", "))
-		utils.Tip("Run 'nsm run' to enter the shell with new packages")
+		utils.Success("Added package(s): %s", strings.Join(newPkgs, ", "))
+		utils.Tip("Run '%s' to enter the shell with new packages", runCommand(cmd))
+		return nil
 	},
 }
 
 func init() {
+	addCmd.Flags().Bool("no-verify", false, "Don't check that packages exist in nixpkgs")
+	addCmd.Flags().String("shell", "", "Named shell of the flake to add to (default is the default shell)")
 	rootCmd.AddCommand(addCmd)
 }
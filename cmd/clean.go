@@ -4,7 +4,7 @@ Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
 package cmd
 
 import (
-	"os/exec"
+	"fmt"
 
 	"github.com/mdaashir/NSM/utils"
 	"github.com/spf13/cobra"
@@ -26,29 +26,28 @@ Example:
 
 Note: This operation is safe but irreversible. Make sure
 you don't need old generations before cleaning.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
 		// Check for Nix installation
 		if err := utils.CheckNixInstallation(); err != nil {
-			utils.Error("Nix is not installed. Please install Nix first!")
-			return
+			return err
 		}
 
 		utils.Info("🧹 Running garbage collection...")
 
 		// Run nix-collect-garbage
-		c := exec.Command("nix-collect-garbage", "-d")
-		output, err := c.CombinedOutput()
+		output, err := utils.CollectGarbage(ctx)
 		if err != nil {
-			utils.Error("Failed to clean packages: %v", err)
 			utils.Tip("Try running 'nsm doctor' to check your installation")
-			return
+			return fmt.Errorf("failed to clean packages: %w", err)
 		}
 
 		utils.Success("Cleaned up Nix store successfully!")
 		if len(output) > 0 {
-			utils.Debug("Cleanup details:\n%s", string(output))
+			utils.Debug("Cleanup details:\n%s", output)
 		}
 		utils.Tip("Run 'nsm info' to check current system state")
+		return nil
 	},
 }
 
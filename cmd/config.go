@@ -5,7 +5,10 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/mdaashir/NSM/utils"
@@ -35,17 +38,50 @@ Examples:
   nsm config reset                          # Reset to defaults`,
 }
 
+// configSummary is the output of nsm config show
+type configSummary map[string]interface{}
+
+func (c configSummary) Headers() []string {
+	return []string{"Setting", "Value"}
+}
+
+func (c configSummary) Rows() [][]string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var rows [][]string
+	for _, key := range keys {
+		value := fmt.Sprint(c[key])
+		if list, ok := c[key].([]string); ok {
+			value = strings.Join(list, ",")
+		}
+		rows = append(rows, []string{key, value})
+	}
+	return rows
+}
+
 var configShowCmd = &cobra.Command{
 	Use:   "show",
 	Short: "Show current configuration",
-	Run: func(cmd *cobra.Command, args []string) {
-		summary := utils.GetConfigSummary()
+	Long: `Show the current configuration and whether it is valid.
+
+Examples:
+  nsm config show            # Show the configuration
+  nsm config show -o yaml    # Output in YAML format`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		summary := configSummary(utils.GetConfigSummary(ctx))
+		if printed, err := printResult(cmd, summary); printed || err != nil {
+			return err
+		}
 
 		// Convert to JSON for pretty printing
 		output, err := json.MarshalIndent(summary, "", "  ")
 		if err != nil {
-			utils.Error("Failed to format config: %v", err)
-			return
+			return fmt.Errorf("failed to format config: %w", err)
 		}
 
 		utils.Info("📝 Current Configuration:")
@@ -61,6 +97,7 @@ var configShowCmd = &cobra.Command{
 		} else {
 			utils.Success("✅ Configuration is valid")
 		}
+		return nil
 	},
 }
 
@@ -68,20 +105,19 @@ var configSetCmd = &cobra.Command{
 	Use:   "set [key] [value]",
 	Short: "Set a configuration value",
 	Args:  cobra.ExactArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		key := args[0]
 		value := args[1]
 
 		// Special handling for different types
 		switch key {
 		case "shell.format":
-			if value != "shell.nix" && value != "flake.nix" {
-				utils.Error("Invalid shell format. Must be 'shell.nix' or 'flake.nix'")
-				return
+			if !slices.Contains(utils.ConfigTypes, value) {
+				return fmt.Errorf("%w: invalid shell format %q, must be 'shell.nix', 'flake.nix' or 'default.nix'", utils.ErrValidationFailed, value)
 			}
 		case "default.packages":
-			utils.Error("Cannot set default.packages directly. Use 'nsm config add/remove default.packages' instead")
-			return
+			utils.Tip("Use 'nsm config add/remove default.packages' instead")
+			return errors.New("cannot set default.packages directly")
 		}
 
 		// Backup old value in case we need to restore
@@ -90,9 +126,8 @@ var configSetCmd = &cobra.Command{
 		viper.Set(key, value)
 
 		// Validate before saving
-		if errors := utils.ValidateConfig(); len(errors) > 0 {
-			utils.Error("New configuration is invalid")
-			for _, err := range errors {
+		if issues := utils.ValidateConfig(); len(issues) > 0 {
+			for _, err := range issues {
 				utils.Error("- %s", err.Error())
 			}
 
@@ -100,34 +135,34 @@ var configSetCmd = &cobra.Command{
 			if oldValue != nil {
 				viper.Set(key, oldValue)
 			}
-			return
+			return fmt.Errorf("%w: new configuration is invalid", utils.ErrValidationFailed)
 		}
 
 		if err := viper.WriteConfig(); err != nil {
-			utils.Error("Failed to save config: %v", err)
-			return
+			return fmt.Errorf("failed to save config: %w", err)
 		}
 
 		utils.Success("Set %s = %s", key, value)
+		return nil
 	},
 }
 
 var configValidateCmd = &cobra.Command{
 	Use:   "validate",
 	Short: "Validate current configuration",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		utils.Info("🔍 Validating configuration...")
 
-		errors := utils.ValidateConfig()
-		if len(errors) == 0 {
+		issues := utils.ValidateConfig()
+		if len(issues) == 0 {
 			utils.Success("Configuration is valid!")
-			return
+			return nil
 		}
 
-		utils.Error("\nFound %d validation issue(s):", len(errors))
-		for _, err := range errors {
+		for _, err := range issues {
 			utils.Error("- %s", err.Error())
 		}
+		return fmt.Errorf("%w: found %d configuration issue(s)", utils.ErrValidationFailed, len(issues))
 	},
 }
 
@@ -135,21 +170,19 @@ var configAddCmd = &cobra.Command{
 	Use:   "add [key] [value]",
 	Short: "Add a value to a list setting",
 	Args:  cobra.ExactArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		key := args[0]
 		value := args[1]
 
 		// Only support adding to lists
 		if !strings.HasPrefix(key, "default.packages") {
-			utils.Error("Can only add to list settings (e.g., default.packages)")
-			return
+			return errors.New("can only add to list settings (e.g., default.packages)")
 		}
 
 		// Validate value if it's a package
 		if key == "default.packages" && !utils.ValidatePackage(value) {
-			utils.Error("Invalid package name: %s", value)
 			utils.Tip("Check package names in https://search.nixos.org")
-			return
+			return fmt.Errorf("%w: %s", utils.ErrInvalidPackage, value)
 		}
 
 		// Get the current list
@@ -162,7 +195,7 @@ var configAddCmd = &cobra.Command{
 		for _, v := range current {
 			if v == value {
 				utils.Warn("Value %s already exists in %s", value, key)
-				return
+				return nil
 			}
 		}
 
@@ -171,11 +204,11 @@ var configAddCmd = &cobra.Command{
 		viper.Set(key, current)
 
 		if err := viper.WriteConfig(); err != nil {
-			utils.Error("Failed to save config: %v", err)
-			return
+			return fmt.Errorf("failed to save config: %w", err)
 		}
 
 		utils.Success("Added %s to %s", value, key)
+		return nil
 	},
 }
 
@@ -183,21 +216,20 @@ var configRemoveCmd = &cobra.Command{
 	Use:   "remove [key] [value]",
 	Short: "Remove a value from a list setting",
 	Args:  cobra.ExactArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		key := args[0]
 		value := args[1]
 
 		// Only support removing from lists
 		if !strings.HasPrefix(key, "default.packages") {
-			utils.Error("Can only remove from list settings (e.g., default.packages)")
-			return
+			return errors.New("can only remove from list settings (e.g., default.packages)")
 		}
 
 		// Get the current list
 		current := viper.GetStringSlice(key)
 		if len(current) == 0 {
 			utils.Warn("No values to remove from %s", key)
-			return
+			return nil
 		}
 
 		// Remove value
@@ -213,24 +245,24 @@ var configRemoveCmd = &cobra.Command{
 
 		if !found {
 			utils.Warn("Value %s not found in %s", value, key)
-			return
+			return nil
 		}
 
 		viper.Set(key, newList)
 
 		if err := viper.WriteConfig(); err != nil {
-			utils.Error("Failed to save config: %v", err)
-			return
+			return fmt.Errorf("failed to save config: %w", err)
 		}
 
 		utils.Success("Removed %s from %s", value, key)
+		return nil
 	},
 }
 
 var configResetCmd = &cobra.Command{
 	Use:   "reset",
 	Short: "Reset configuration to defaults",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		// Create backup of current config
 		configFile := viper.ConfigFileUsed()
 		if configFile != "" && utils.FileExists(configFile) {
@@ -249,12 +281,12 @@ var configResetCmd = &cobra.Command{
 		viper.Set("config_version", "1.0.0")
 
 		if err := viper.WriteConfig(); err != nil {
-			utils.Error("Failed to save config: %v", err)
-			return
+			return fmt.Errorf("failed to save config: %w", err)
 		}
 
 		utils.Success("Reset configuration to defaults")
 		utils.Tip("Run 'nsm config show' to see the new configuration")
+		return nil
 	},
 }
 
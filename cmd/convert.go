@@ -4,10 +4,12 @@ Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 
+	"github.com/mdaashir/NSM/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -22,31 +24,7 @@ func backupFile(filename string) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filename+".backup", content, 0600)
-}
-
-// parseShellNixPackages parses packages from shell.nix with regex
-func parseShellNixPackages(content string) []string {
-	var packages []string
-	lines := strings.Split(content, "\n")
-	inPackages := false
-
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if strings.Contains(trimmed, "packages = with pkgs; [") {
-			inPackages = true
-			continue
-		}
-		if inPackages {
-			if strings.Contains(trimmed, "];") {
-				break
-			}
-			if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
-				packages = append(packages, trimmed)
-			}
-		}
-	}
-	return packages
+	return utils.WriteFileAtomic(filename+".backup", content, 0600)
 }
 
 var convertCmd = &cobra.Command{
@@ -64,43 +42,49 @@ This command will:
 Examples:
   nsm convert              # Convert shell.nix to flake.nix
   nsm convert --no-backup  # Convert without creating backup`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		// Check if shell.nix exists
 		if _, err := os.Stat("shell.nix"); os.IsNotExist(err) {
-			fmt.Println("❌ No shell.nix found in the current directory")
-			return
+			return fmt.Errorf("%w: no shell.nix found in the current directory", utils.ErrNoProjectConfig)
 		}
 
 		// Check if flake.nix already exists
 		if _, err := os.Stat("flake.nix"); err == nil {
-			fmt.Println("❌ flake.nix already exists")
 			fmt.Println("💡 Remove or rename existing flake.nix first")
-			return
+			return errors.New("flake.nix already exists")
 		}
 
 		// Read shell.nix
 		content, err := os.ReadFile("shell.nix")
 		if err != nil {
-			fmt.Println("❌ Error reading shell.nix:", err)
-			return
+			return fmt.Errorf("error reading shell.nix: %w", err)
 		}
 
 		// Create a backup if requested
 		noBackup, _ := cmd.Flags().GetBool("no-backup")
 		if !noBackup {
 			if err := backupFile("shell.nix"); err != nil {
-				fmt.Println("❌ Error creating backup:", err)
-				return
+				return fmt.Errorf("error creating backup: %w", err)
 			}
 			fmt.Println("✅ Created backup: shell.nix.backup")
 		}
 
-		// Parse packages
-		packages := parseShellNixPackages(string(content))
+		// Parse packages, keeping the pins of shell.nix over configured ones
+		packages := utils.ExtractShellNixPackages(string(content))
 		if len(packages) == 0 {
 			fmt.Println("⚠️  No packages found in shell.nix")
 		}
 
+		sources := make(map[string]utils.NixpkgsSource)
+		for pkg, source := range configPinSources() {
+			sources[pkg] = source
+		}
+		if editor, err := utils.NewNixEditor(string(content)); err == nil {
+			for pkg, source := range editor.PinnedPackages() {
+				sources[pkg] = source
+			}
+		}
+
 		// Generate flake.nix content
 		channel := viper.GetString(ChannelURLKey)
 		if channel == "" {
@@ -113,7 +97,7 @@ Examples:
   inputs.nixpkgs.url = "github:nixos/nixpkgs/%s";
 
   outputs = { self, nixpkgs }: {
-    devShell.x86_64-linux = nixpkgs.legacyPackages.x86_64-linux.mkShell {
+    devShells.x86_64-linux.default = nixpkgs.legacyPackages.x86_64-linux.mkShell {
       buildInputs = with nixpkgs.legacyPackages.x86_64-linux; [
         %s
       ];
@@ -121,15 +105,20 @@ Examples:
   };
 }`, channel, strings.Join(packages, "\n        "))
 
+		flakeContent, err = applyPins(flakeContent, sources)
+		if err != nil {
+			return fmt.Errorf("error applying package pins: %w", err)
+		}
+
 		// Write flake.nix
-		if err := os.WriteFile("flake.nix", []byte(flakeContent), 0600); err != nil {
-			fmt.Println("❌ Error writing flake.nix:", err)
-			return
+		if err := utils.WriteFileAtomic("flake.nix", []byte(flakeContent), 0600); err != nil {
+			return fmt.Errorf("error writing flake.nix: %w", err)
 		}
 
 		fmt.Println("✅ Successfully converted to flake.nix")
 		fmt.Printf("📦 Migrated %d packages\n", len(packages))
 		fmt.Println("💡 Run 'nsm run' to enter the new flake-based shell")
+		return nil
 	},
 }
 
@@ -0,0 +1,233 @@
+/*
+Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mdaashir/NSM/lockfile"
+	"github.com/mdaashir/NSM/utils"
+	"github.com/spf13/cobra"
+)
+
+// workingTree is the label of the environment in the project directory
+const workingTree = "working tree"
+
+// diffChange is a package change in the output of nsm diff
+type diffChange struct {
+	lockfile.Change
+	Major bool `json:"major"`
+}
+
+// diffReport is the JSON output of nsm diff
+type diffReport struct {
+	Old     string                  `json:"old"`
+	New     string                  `json:"new"`
+	Nixpkgs *lockfile.NixpkgsChange `json:"nixpkgs,omitempty"`
+	Changes []diffChange            `json:"changes"`
+}
+
+// snapshotLock evaluates the named shell of a shell definition into a lock
+func snapshotLock(ctx context.Context, configFile, shell string) (*lockfile.Lock, error) {
+	snapshot, err := utils.SnapshotShell(ctx, configFile, shell)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate %s: %w", filepath.Base(configFile), err)
+	}
+	for _, pkg := range snapshot.Unresolved {
+		utils.Warn("Could not evaluate %s, it is left out of the comparison", pkg)
+	}
+	lock := lockfile.FromSnapshot(snapshot, "")
+	lock.ConfigType = filepath.Base(configFile)
+	return lock, nil
+}
+
+// loadEnvironment returns the lock of one side of nsm diff: a lock file, a
+// git revision or the working tree. Without a lock file, the shell
+// definition is evaluated instead, for the named shell.
+func loadEnvironment(ctx context.Context, source, shell string) (*lockfile.Lock, error) {
+	if source == workingTree {
+		if _, err := os.Stat(lockfile.FileName); err == nil {
+			return lockfile.Read(lockfile.FileName)
+		}
+		configType := utils.GetProjectConfigType()
+		if configType == "" {
+			return nil, fmt.Errorf("no %s, shell.nix or flake.nix found", lockfile.FileName)
+		}
+		return snapshotLock(ctx, configType, shell)
+	}
+
+	if info, err := os.Stat(fromStartDir(source)); err == nil && !info.IsDir() {
+		return lockfile.Read(fromStartDir(source))
+	}
+	if !utils.IsGitRevision(source) {
+		return nil, fmt.Errorf("%s is neither a lock file nor a git revision", source)
+	}
+
+	content, err := utils.GitShowFile(source, lockfile.FileName)
+	if err == nil {
+		lock, err := lockfile.Decode(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s at %s: %w", lockfile.FileName, source, err)
+		}
+		return lock, nil
+	}
+	if !errors.Is(err, utils.ErrNotInGit) {
+		return nil, err
+	}
+
+	// Without a lock at that revision, evaluate its shell definition
+	dir, err := os.MkdirTemp("", "nsm-diff-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	configFile, err := utils.CheckoutShell(source, dir)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotLock(ctx, configFile, shell)
+}
+
+func (r diffReport) Headers() []string {
+	return []string{"Package", "Old", "New", "Change"}
+}
+
+func (r diffReport) Rows() [][]string {
+	var rows [][]string
+	for _, change := range r.Changes {
+		rows = append(rows, changeRow(change.Change, false))
+	}
+	return rows
+}
+
+// changeRow renders a package change as a table row
+func changeRow(change lockfile.Change, markdown bool) []string {
+	old, current := orNone(change.OldVersion), orNone(change.NewVersion)
+	if change.Kind == lockfile.RevisionChanged {
+		old, current = shortRevision(change.OldRevision), shortRevision(change.NewRevision)
+	}
+	kind := string(change.Kind)
+	if change.Major() {
+		if markdown {
+			kind += " **(major)**"
+		} else {
+			kind += " (major)"
+		}
+	}
+	return []string{change.Attr, old, current, kind}
+}
+
+// diffRows renders the package changes of a drift as table rows
+func diffRows(drift *lockfile.Drift, markdown bool) [][]string {
+	var rows [][]string
+	for _, change := range drift.Changes {
+		rows = append(rows, changeRow(change, markdown))
+	}
+	return rows
+}
+
+// markdownDiff renders a drift for pasting into pull request comments
+func markdownDiff(oldLabel, newLabel string, drift *lockfile.Drift) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "### Environment changes: `%s` → `%s`\n\n", oldLabel, newLabel)
+	if drift.Empty() {
+		b.WriteString("No changes.\n")
+		return b.String()
+	}
+	if drift.Nixpkgs != nil {
+		fmt.Fprintf(&b, "nixpkgs: `%s` → `%s`\n\n",
+			orNone(shortRevision(drift.Nixpkgs.Old.Revision)), orNone(shortRevision(drift.Nixpkgs.New.Revision)))
+	}
+	if len(drift.Changes) > 0 {
+		b.WriteString(utils.MarkdownTable([]string{"Package", "Old", "New", "Change"}, diffRows(drift, true)))
+	}
+	return b.String()
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [old] [new]",
+	Short: "Compare two lock files or git revisions of the environment",
+	Long: `Show how the packages of the environment changed between two lock files
+or two git revisions.
+
+Each side is a path to a lock file or a git revision. At a git revision,
+nsm.lock.json is read when it was committed; otherwise the shell.nix or
+flake.nix of that revision is evaluated. Without [new], the working tree is
+used, which is nsm.lock.json or the evaluated shell of the project. Without
+arguments, HEAD is compared with the working tree. Shell definitions are
+evaluated for the default shell, or the named shell of a flake with --shell.
+
+Version changes that cross a major version are flagged.
+
+Examples:
+  nsm diff                            # Compare HEAD with the working tree
+  nsm diff main                       # Compare main with the working tree
+  nsm diff v1.0 v2.0                  # Compare two git revisions
+  nsm diff old.lock.json nsm.lock.json
+  nsm diff main --markdown            # Output a table for a PR comment
+  nsm diff main --json                # Output the changes in JSON format`,
+	Args: cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		oldSource, newSource := "HEAD", workingTree
+		if len(args) > 0 {
+			oldSource = args[0]
+		}
+		if len(args) > 1 {
+			newSource = args[1]
+		}
+
+		markdownOutput, _ := cmd.Flags().GetBool("markdown")
+		shellName, _ := cmd.Flags().GetString("shell")
+
+		oldLock, err := loadEnvironment(cmd.Context(), oldSource, shellName)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", oldSource, err)
+		}
+		newLock, err := loadEnvironment(cmd.Context(), newSource, shellName)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", newSource, err)
+		}
+		drift := lockfile.Diff(oldLock, newLock)
+
+		if markdownOutput {
+			fmt.Print(markdownDiff(oldSource, newSource, drift))
+			return nil
+		}
+
+		report := diffReport{Old: oldSource, New: newSource, Nixpkgs: drift.Nixpkgs, Changes: []diffChange{}}
+		for _, change := range drift.Changes {
+			report.Changes = append(report.Changes, diffChange{Change: change, Major: change.Major()})
+		}
+		if printed, err := printResult(cmd, report); printed || err != nil {
+			return err
+		}
+
+		switch {
+		case drift.Empty():
+			utils.Success("No changes between %s and %s", oldSource, newSource)
+
+		default:
+			utils.Info("📦 Changes from %s to %s:", oldSource, newSource)
+			if drift.Nixpkgs != nil {
+				utils.Info("nixpkgs: %s → %s",
+					orNone(shortRevision(drift.Nixpkgs.Old.Revision)), orNone(shortRevision(drift.Nixpkgs.New.Revision)))
+			}
+			utils.Table(report.Headers(), report.Rows())
+		}
+		return nil
+	},
+}
+
+func init() {
+	diffCmd.Flags().Bool("json", false, "Output the changes in JSON format")
+	diffCmd.Flags().Bool("markdown", false, "Output the changes as a Markdown table")
+	diffCmd.MarkFlagsMutuallyExclusive("json", "markdown")
+	diffCmd.Flags().String("shell", "", "Named shell of the flake to evaluate (default is the default shell)")
+	rootCmd.AddCommand(diffCmd)
+}
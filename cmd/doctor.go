@@ -4,7 +4,8 @@ Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
 package cmd
 
 import (
-	"os/exec"
+	"context"
+	"fmt"
 	"path/filepath"
 
 	"github.com/mdaashir/NSM/utils"
@@ -21,7 +22,7 @@ type Check struct {
 }
 
 // runDiagnostics runs all diagnostic checks
-func runDiagnostics() []Check {
+func runDiagnostics(ctx context.Context) []Check {
 	return []Check{
 		{
 			Name:        "Nix Installation",
@@ -36,7 +37,7 @@ func runDiagnostics() []Check {
 			Name:        "Nix Version",
 			Description: "Check Nix version",
 			Run: func() (bool, string) {
-				version, err := utils.GetNixVersion()
+				version, err := utils.GetNixVersion(ctx)
 				if err != nil {
 					return false, ""
 				}
@@ -48,7 +49,7 @@ func runDiagnostics() []Check {
 			Name:        "Nixpkgs Channel",
 			Description: "Check if nixpkgs channel is configured",
 			Run: func() (bool, string) {
-				channel, err := utils.GetChannelInfo()
+				channel, err := utils.GetChannelInfo(ctx)
 				if err != nil || channel == "" {
 					return false, ""
 				}
@@ -60,9 +61,7 @@ func runDiagnostics() []Check {
 			Name:        "Nix Store",
 			Description: "Verify Nix store",
 			Run: func() (bool, string) {
-				cmd := exec.Command("nix-store", "--verify")
-				err := cmd.Run()
-				return err == nil, ""
+				return utils.VerifyStore(ctx) == nil, ""
 			},
 			Fix: "Run 'nix-store --verify --repair'",
 		},
@@ -70,13 +69,13 @@ func runDiagnostics() []Check {
 			Name:        "Flakes Support",
 			Description: "Check if flakes are enabled",
 			Run: func() (bool, string) {
-				return utils.CheckFlakeSupport(), ""
+				return utils.CheckFlakeSupport(ctx), ""
 			},
 			Fix: "Add 'experimental-features = nix-command flakes' to your Nix config",
 		},
 		{
 			Name:        "Project Configuration",
-			Description: "Check for shell.nix or flake.nix",
+			Description: "Check for shell.nix, flake.nix or default.nix",
 			Run: func() (bool, string) {
 				configType := utils.GetProjectConfigType()
 				return configType != "", configType
@@ -95,6 +94,67 @@ func runDiagnostics() []Check {
 	}
 }
 
+// checkResult is the outcome of a diagnostic check
+type checkResult struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Passed      bool   `json:"passed"`
+	Details     string `json:"details,omitempty"`
+	Fix         string `json:"fix,omitempty"`
+}
+
+// doctorResult is the output of nsm doctor
+type doctorResult struct {
+	Checks []checkResult `json:"checks"`
+	Issues int           `json:"issues"`
+}
+
+func (r doctorResult) Headers() []string {
+	return []string{"Check", "Status", "Details"}
+}
+
+func (r doctorResult) Rows() [][]string {
+	var rows [][]string
+	for _, check := range r.Checks {
+		status, details := "ok", check.Details
+		if !check.Passed {
+			status, details = "failed", check.Fix
+		}
+		rows = append(rows, []string{check.Name, status, details})
+	}
+	return rows
+}
+
+// printDiagnostics prints the checks of nsm doctor for people
+func printDiagnostics(result doctorResult) {
+	utils.Info("🔍 Running diagnostics...")
+	utils.Info("=====================")
+
+	for _, check := range result.Checks {
+		utils.Info("\n🔍 %s:", check.Name)
+		utils.Debug("  Description: %s", check.Description)
+
+		if check.Passed {
+			if check.Details != "" {
+				utils.Success("  ✓ %s: %s", check.Description, check.Details)
+			} else {
+				utils.Success("  ✓ %s", check.Description)
+			}
+		} else {
+			utils.Error("  ✗ %s failed", check.Description)
+			utils.Tip("  Fix: %s", check.Fix)
+		}
+	}
+
+	utils.Info("\n📊 Diagnostic Summary:")
+	utils.Info("=====================")
+	if result.Issues == 0 {
+		utils.Success("All checks passed! Your Nix installation is healthy.")
+	} else {
+		utils.Tip("Fix the issues above to ensure proper operation.")
+	}
+}
+
 var doctorCmd = &cobra.Command{
 	Use:   "doctor",
 	Short: "Diagnose the nix environment installation",
@@ -111,40 +171,31 @@ This command checks:
 - NSM configuration
 
 Examples:
-  nsm doctor    # Run all diagnostics`,
-	Run: func(cmd *cobra.Command, args []string) {
-		utils.Info("🔍 Running diagnostics...")
-		utils.Info("=====================")
-
-		checks := runDiagnostics()
-		issues := 0
-
-		for _, check := range checks {
-			utils.Info("\n🔍 %s:", check.Name)
-			utils.Debug("  Description: %s", check.Description)
-
+  nsm doctor            # Run all diagnostics
+  nsm doctor -o json    # Output the results in JSON format`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var result doctorResult
+		for _, check := range runDiagnostics(cmd.Context()) {
 			ok, details := check.Run()
-			if ok {
-				if details != "" {
-					utils.Success("  ✓ %s: %s", check.Description, details)
-				} else {
-					utils.Success("  ✓ %s", check.Description)
-				}
-			} else {
-				issues++
-				utils.Error("  ✗ %s failed", check.Description)
-				utils.Tip("  Fix: %s", check.Fix)
+			checked := checkResult{Name: check.Name, Description: check.Description, Passed: ok, Details: details}
+			if !ok {
+				checked.Fix = check.Fix
+				result.Issues++
 			}
+			result.Checks = append(result.Checks, checked)
 		}
 
-		utils.Info("\n📊 Diagnostic Summary:")
-		utils.Info("=====================")
-		if issues == 0 {
-			utils.Success("All checks passed! Your Nix installation is healthy.")
-		} else {
-			utils.Warn("Found %d issue(s) that need attention.", issues)
-			utils.Tip("Fix the issues above to ensure proper operation.")
+		printed, err := printResult(cmd, result)
+		if err != nil {
+			return err
+		}
+		if !printed {
+			printDiagnostics(result)
+		}
+		if result.Issues > 0 {
+			return fmt.Errorf("%w: found %d issue(s) that need attention", utils.ErrValidationFailed, result.Issues)
 		}
+		return nil
 	},
 }
 
@@ -4,117 +4,124 @@ Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
 
+	"github.com/mdaashir/NSM/lockfile"
 	"github.com/mdaashir/NSM/utils"
 	"github.com/spf13/cobra"
 )
 
+// freezeResult is the output of nsm freeze: the lock it wrote
+type freezeResult struct {
+	*lockfile.Lock
+}
+
+func (r freezeResult) Headers() []string {
+	return []string{"Package", "Version", "Nixpkgs revision"}
+}
+
+func (r freezeResult) Rows() [][]string {
+	var rows [][]string
+	for _, pkg := range r.PackageList() {
+		rows = append(rows, []string{pkg.Attr, orNone(pkg.Version), shortRevision(pkg.Nixpkgs.Revision)})
+	}
+	return rows
+}
+
 var freezeCmd = &cobra.Command{
 	Use:   "freeze",
-	Short: "Freeze current package versions",
-	Long: `Freeze the current versions of all installed packages.
-This creates a lock file that can be used to reproduce the exact
-same environment later.
+	Short: "Lock the packages of the project shell",
+	Long: `Freeze the packages of the project's shell.nix or flake.nix.
+This evaluates the shell definition and creates a lock file that
+describes the exact same environment.
+
+The lock file contains, for each package the shell declares:
+- Attribute path and version
+- Store path and the NAR hash of each output
+- The nixpkgs revision and narHash it comes from
 
-The lock file contains:
-- Package versions
-- Channel information
-- Nixpkgs revision
-- Shell configuration type
+It also records the nixpkgs the shell builds against (the nixpkgs input of
+flake.lock for flakes), the channel and the shell configuration type. With
+--shell, a named shell of the flake is locked instead of the default shell;
+verify, restore and run --frozen then use that shell.
 
 Examples:
   nsm freeze              # Create/update lock file
-  nsm freeze --json      # Output in JSON format`,
-	Run: func(cmd *cobra.Command, args []string) {
+  nsm freeze --shell ci   # Lock the ci shell of the flake
+  nsm freeze --json      # Output in JSON format
+  nsm freeze -o yaml     # Output in YAML format`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
 		// Check for Nix installation
 		if err := utils.CheckNixInstallation(); err != nil {
-			utils.Error("Nix is not installed. Please install Nix first!")
-			return
+			return err
 		}
 
 		// Get a configuration type
 		configType := utils.GetProjectConfigType()
 		if configType == "" {
-			utils.Error("No shell.nix or flake.nix found in current directory")
 			utils.Tip("Run 'nsm init' to create a new environment")
-			return
+			return utils.ErrNoProjectConfig
 		}
 
-		// Get installed packages and their versions
-		packages, err := utils.GetInstalledPackages()
+		// Evaluate the packages the shell declares
+		shellName, _ := cmd.Flags().GetString("shell")
+		utils.Info("🔍 Evaluating %s...", configType)
+		snapshot, err := utils.SnapshotShell(ctx, configType, shellName)
 		if err != nil {
-			utils.Error("Failed to get installed packages: %v", err)
-			return
+			return fmt.Errorf("failed to evaluate %s: %w", configType, err)
 		}
-
-		lockData := make(map[string]interface{})
-		packageVersions := make(map[string]string)
-
-		for _, pkg := range packages {
-			version, err := utils.GetPackageVersion(pkg)
-			if err != nil {
-				utils.Warn("Could not get version for %s: %v", pkg, err)
-				continue
-			}
-			packageVersions[pkg] = version
+		for _, pkg := range snapshot.Unresolved {
+			utils.Warn("Skipping %s: not a package of its nixpkgs", pkg)
 		}
-
-		// Get channel and revision info
-		channel, err := utils.GetChannelInfo()
-		if err != nil {
-			utils.Warn("Could not get channel info: %v", err)
+		if !utils.IsNixpkgsCommit(snapshot.Nixpkgs.Revision) {
+			utils.Warn("Could not determine the nixpkgs commit of %s", configType)
 		}
 
-		revision, err := utils.GetNixpkgsRevision()
+		// Get channel info
+		channel, err := utils.GetChannelInfo(ctx)
 		if err != nil {
-			utils.Warn("Could not get nixpkgs revision: %v", err)
+			utils.Warn("Could not get channel info: %v", err)
 		}
 
-		// Build lock data
-		lockData["packages"] = packageVersions
-		lockData["channel"] = channel
-		lockData["nixpkgs_revision"] = revision
-		lockData["config_type"] = configType
-		lockData["version"] = "1.0.0"
-
-		// Convert to JSON
-		lockContent, err := json.MarshalIndent(lockData, "", "  ")
+		// Build and write the lock file
+		lock := lockfile.FromSnapshot(snapshot, channel)
+		lockContent, err := lock.Encode()
 		if err != nil {
-			utils.Error("Failed to create lock file content: %v", err)
-			return
+			return fmt.Errorf("failed to create lock file content: %w", err)
 		}
 
-		// Write a lock file
-		lockFile := "nsm.lock.json"
-		if err := os.WriteFile(lockFile, lockContent, 0600); err != nil {
-			utils.Error("Failed to write lock file: %v", err)
-			return
+		lockFile := lockfile.FileName
+		if err := utils.WriteFileAtomic(lockFile, lockContent, 0600); err != nil {
+			return fmt.Errorf("failed to write lock file: %w", err)
 		}
 
 		utils.Success("Created lock file: %s", lockFile)
-		utils.Info("Found %d packages", len(packageVersions))
+		utils.Info("Found %d packages", len(snapshot.Packages))
 
-		if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
-			fmt.Println(string(lockContent))
-			return
+		result := freezeResult{lock}
+		if printed, err := printResult(cmd, result); printed || err != nil {
+			return err
 		}
 
 		// Show summary
 		utils.Info("\n📦 Package versions:")
-		for pkg, version := range packageVersions {
-			utils.Info("  %s: %s", pkg, version)
-		}
+		utils.Table(result.Headers(), result.Rows())
 
-		utils.Info("\nChannel: %s", channel)
-		utils.Info("Nixpkgs revision: %s", revision)
-		utils.Tip("Use 'nsm pin' to restore these exact versions later")
+		if snapshot.Shell != "" {
+			utils.Info("\nShell: %s", snapshot.Shell)
+		}
+		utils.Info("\nNixpkgs revision: %s", orNone(snapshot.Nixpkgs.Revision))
+		if snapshot.Nixpkgs.NarHash != "" {
+			utils.Info("Nixpkgs hash: %s", snapshot.Nixpkgs.NarHash)
+		}
+		utils.Tip("Use 'nsm restore' to rebuild this exact environment later")
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(freezeCmd)
 	freezeCmd.Flags().Bool("json", false, "Output in JSON format")
+	freezeCmd.Flags().String("shell", "", "Named shell of the flake to lock (default is the default shell)")
 }
@@ -6,6 +6,7 @@ package cmd
 import (
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/mdaashir/NSM/utils"
@@ -13,6 +14,36 @@ import (
 	"github.com/spf13/viper"
 )
 
+// infoResult is the output of nsm info
+type infoResult struct {
+	NixVersion string `json:"nixVersion,omitempty"`
+	Channel    string `json:"channel,omitempty"`
+	Flakes     bool   `json:"flakes"`
+	OS         string `json:"os,omitempty"`
+	// Config is the shell definition of the current directory, if any
+	Config     string `json:"config,omitempty"`
+	Packages   int    `json:"packages"`
+	Direnv     bool   `json:"direnv"`
+	ConfigFile string `json:"configFile,omitempty"`
+}
+
+func (r infoResult) Headers() []string {
+	return []string{"Property", "Value"}
+}
+
+func (r infoResult) Rows() [][]string {
+	return [][]string{
+		{"nixVersion", orNone(r.NixVersion)},
+		{"channel", orNone(r.Channel)},
+		{"flakes", strconv.FormatBool(r.Flakes)},
+		{"os", orNone(r.OS)},
+		{"config", orNone(r.Config)},
+		{"packages", strconv.Itoa(r.Packages)},
+		{"direnv", strconv.FormatBool(r.Direnv)},
+		{"configFile", orNone(r.ConfigFile)},
+	}
+}
+
 var infoCmd = &cobra.Command{
 	Use:   "info",
 	Short: "Show system and nix information",
@@ -26,34 +57,60 @@ Information shown:
 - Flakes support status
 - Current project configuration
 
-Example:
-  nsm info    # Show detailed system information`,
-	Run: func(cmd *cobra.Command, args []string) {
-		utils.Info("📊 System Information:")
-		utils.Info("==================")
+Examples:
+  nsm info            # Show detailed system information
+  nsm info -o json    # Output in JSON format`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
 
 		// Check Nix installation
 		if err := utils.CheckNixInstallation(); err != nil {
-			utils.Error("Nix is not installed. Please install Nix first!")
-			return
+			return err
+		}
+
+		result := infoResult{
+			Flakes:     utils.CheckFlakeSupport(ctx),
+			Config:     utils.GetProjectConfigType(),
+			Direnv:     utils.FileExists(".envrc"),
+			ConfigFile: viper.ConfigFileUsed(),
+		}
+		var versionErr, channelErr error
+		result.NixVersion, versionErr = utils.GetNixVersion(ctx)
+		result.Channel, channelErr = utils.GetChannelInfo(ctx)
+		if output, err := exec.Command("uname", "-a").Output(); err == nil {
+			result.OS = strings.TrimSpace(string(output))
+		}
+		if content, err := os.ReadFile(result.Config); err == nil {
+			if result.Config == "shell.nix" {
+				result.Packages = len(utils.ExtractShellNixPackages(string(content)))
+			} else {
+				result.Packages = len(utils.ExtractFlakePackages(string(content)))
+			}
+		}
+
+		if printed, err := printResult(cmd, result); printed || err != nil {
+			return err
 		}
 
+		utils.Info("📊 System Information:")
+		utils.Info("==================")
+
 		// Show a Nix version
-		if version, err := utils.GetNixVersion(); err == nil {
-			utils.Success("Nix Version: %s", version)
+		if versionErr == nil {
+			utils.Success("Nix Version: %s", result.NixVersion)
 		} else {
-			utils.Error("Could not determine Nix version: %v", err)
+			utils.Error("Could not determine Nix version: %v", versionErr)
 		}
 
 		// Show channel information
-		if channel, err := utils.GetChannelInfo(); err == nil {
-			utils.Success("Channel Info: %s", channel)
+		if channelErr == nil {
+			utils.Success("Channel Info: %s", result.Channel)
 		} else {
-			utils.Error("Could not get channel info: %v", err)
+			utils.Error("Could not get channel info: %v", channelErr)
 		}
 
 		// Check flakes support
-		if utils.CheckFlakeSupport() {
+		if result.Flakes {
 			utils.Success("Flakes: Supported")
 		} else {
 			utils.Warn("Flakes: Not enabled")
@@ -61,39 +118,38 @@ Example:
 		}
 
 		// Show OS information
-		if c := exec.Command("uname", "-a"); c != nil {
-			if output, err := c.Output(); err == nil {
-				utils.Success("OS Info: %s", output)
-			}
+		if result.OS != "" {
+			utils.Success("OS Info: %s", result.OS)
 		}
 
 		// Show the current directory configuration
 		utils.Info("\n📁 Project Configuration:")
 		utils.Info("=====================")
 
-		configType := utils.GetProjectConfigType()
-		switch configType {
+		switch result.Config {
 		case "shell.nix":
 			utils.Success("Configuration: Traditional Nix shell (shell.nix)")
-			if content, err := os.ReadFile("shell.nix"); err == nil {
-				pkgCount := strings.Count(string(content), "\n    ")
-				utils.Info("📦 Packages configured: %d", pkgCount)
-			}
+			utils.Info("📦 Packages configured: %d", result.Packages)
 		case "flake.nix":
 			utils.Success("Configuration: Nix Flake (flake.nix)")
+			utils.Info("📦 Packages configured: %d", result.Packages)
+		case "default.nix":
+			utils.Success("Configuration: Nix expression (default.nix)")
+			utils.Info("📦 Packages configured: %d", result.Packages)
 		case "":
 			utils.Warn("No Nix configuration found")
 			utils.Tip("Run 'nsm init' to create a new environment")
 		}
 
-		if utils.FileExists(".envrc") {
+		if result.Direnv {
 			utils.Success("direnv: Configured")
 		}
 
 		// Show config file location
-		if cfgFile := viper.ConfigFileUsed(); cfgFile != "" {
-			utils.Debug("Config file: %s", cfgFile)
+		if result.ConfigFile != "" {
+			utils.Debug("Config file: %s", result.ConfigFile)
 		}
+		return nil
 	},
 }
 
@@ -4,8 +4,8 @@ Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/mdaashir/NSM/utils"
@@ -31,33 +31,38 @@ Examples:
   nsm init            # Create new shell.nix
   nsm init --flake   # Create new flake.nix
   nsm init --force   # Overwrite existing files`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
 		// Check for Nix installation
 		if err := utils.CheckNixInstallation(); err != nil {
-			utils.Error("Nix is not installed. Please install Nix first!")
-			return
+			return err
 		}
 
 		useFlake, err := cmd.Flags().GetBool("flake")
 		if err != nil {
-			utils.Error("Failed to get flake flag: %v", err)
-			return
+			return fmt.Errorf("failed to get flake flag: %w", err)
 		}
 
 		force, err := cmd.Flags().GetBool("force")
 		if err != nil {
-			utils.Error("Failed to get force flag: %v", err)
-			return
+			return fmt.Errorf("failed to get force flag: %w", err)
+		}
+
+		// A file chosen with --file decides the format
+		if file := utils.ProjectFile(); file != "" {
+			if cmd.Flags().Changed("flake") && useFlake != (file == "flake.nix") {
+				return fmt.Errorf("--flake conflicts with --file %s", projectFileFlag)
+			}
+			useFlake = file == "flake.nix"
 		}
 
 		// Determine a file to create
 		var filename string
 		if useFlake {
 			filename = "flake.nix"
-			if !utils.CheckFlakeSupport() {
-				utils.Error("Flakes are not enabled in your Nix configuration")
+			if !utils.CheckFlakeSupport(ctx) {
 				utils.Tip("Add 'experimental-features = nix-command flakes' to your Nix config")
-				return
+				return errors.New("flakes are not enabled in your Nix configuration")
 			}
 		} else {
 			filename = "shell.nix"
@@ -66,8 +71,8 @@ Examples:
 		// Check if files already exist
 		if !force {
 			if utils.FileExists(filename) {
-				utils.Error("%s already exists. Use --force to overwrite", filename)
-				return
+				utils.Tip("Use --force to overwrite it")
+				return fmt.Errorf("%s already exists", filename)
 			}
 		} else {
 			utils.Debug("Force flag enabled, will overwrite existing files")
@@ -76,8 +81,7 @@ Examples:
 		// Create a backup if a file exists and force is enabled
 		if force && utils.FileExists(filename) {
 			if err := utils.BackupFile(filename); err != nil {
-				utils.Error("Failed to create backup: %v", err)
-				return
+				return fmt.Errorf("failed to create backup: %w", err)
 			}
 			utils.Success("Created backup: %s.backup", filename)
 		}
@@ -90,11 +94,16 @@ Examples:
 			content = getDefaultShellContent()
 		}
 
+		// Resolve pinned default packages from their nixpkgs revision
+		content, err = applyPins(content, configPinSources())
+		if err != nil {
+			return fmt.Errorf("failed to apply package pins: %w", err)
+		}
+
 		// Write the file
-		err = os.WriteFile(filename, []byte(content), 0600)
+		err = utils.WriteFileAtomic(filename, []byte(content), 0600)
 		if err != nil {
-			utils.Error("Failed to create %s: %v", filename, err)
-			return
+			return fmt.Errorf("failed to create %s: %w", filename, err)
 		}
 
 		utils.Success("Created %s with default configuration", filename)
@@ -103,6 +112,7 @@ Examples:
 		} else {
 			utils.Tip("Run 'nsm run' to enter the shell")
 		}
+		return nil
 	},
 }
 
@@ -166,17 +176,17 @@ func getDefaultFlakeContent() string {
 
   outputs = { self, nixpkgs, flake-utils }:
     flake-utils.lib.eachDefaultSystem (system: {
-      devShell = nixpkgs.legacyPackages.${system}.mkShell {
+      devShells.default = nixpkgs.legacyPackages.${system}.mkShell {
         name = "dev-shell";
 
         buildInputs = with nixpkgs.legacyPackages.${system}; [
       %s
         ];
 
-        shellHook = '''
+        shellHook = ''
           echo "🚀 Welcome to your Nix development environment!"
           echo "📦 Use 'nsm add <package>' to add more packages"
-        ''';
+        '';
       };
     });
 }`, channel, pkgList)
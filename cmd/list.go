@@ -4,14 +4,55 @@ Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
 package cmd
 
 import (
-	"os/exec"
+	"fmt"
 	"sort"
-	"strings"
 
 	"github.com/mdaashir/NSM/utils"
 	"github.com/spf13/cobra"
 )
 
+// listEntry is a package declared by the project shell
+type listEntry struct {
+	Package    string `json:"package"`
+	Constraint string `json:"constraint,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Installed  bool   `json:"installed"`
+}
+
+// listResult is the output of nsm list
+type listResult struct {
+	Config   string      `json:"config"`
+	Shell    string      `json:"shell,omitempty"`
+	Packages []listEntry `json:"packages"`
+}
+
+func (r listResult) Headers() []string {
+	return []string{"Package", "Constraint", "Version", "Status", "Source"}
+}
+
+func (r listResult) Rows() [][]string {
+	var rows [][]string
+	for _, pkg := range r.Packages {
+		status := "pending"
+		if pkg.Installed {
+			status = "installed"
+		}
+		rows = append(rows, []string{pkg.Package, orNone(pkg.Constraint), orNone(pkg.Version), status, r.Config})
+	}
+	return rows
+}
+
+// Pending counts the packages not installed in the user profile
+func (r listResult) Pending() int {
+	pending := 0
+	for _, pkg := range r.Packages {
+		if !pkg.Installed {
+			pending++
+		}
+	}
+	return pending
+}
+
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List packages in the current environment",
@@ -19,6 +60,7 @@ var listCmd = &cobra.Command{
 
 This command will show:
 - Package name and version
+- Requested version constraint (from 'nsm add <package>@<version>')
 - Package status (installed/pending)
 - Package description
 - Installation source (shell.nix/flake.nix)
@@ -26,96 +68,102 @@ This command will show:
 Examples:
   nsm list              # List all packages
   nsm list --json      # Output in JSON format
-  nsm list --installed # Show only installed packages`,
-	Run: func(cmd *cobra.Command, args []string) {
+  nsm list -o yaml     # Output in YAML format
+  nsm list --installed # Show only installed packages
+  nsm list --shell ci  # List the devShells.ci shell of a flake`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
 		// Check for Nix installation
 		if err := utils.CheckNixInstallation(); err != nil {
-			utils.Error("Nix is not installed. Please install Nix first!")
-			return
+			return err
 		}
 
 		// Get a configuration type
 		configType := utils.GetProjectConfigType()
 		if configType == "" {
-			utils.Error("No shell.nix or flake.nix found in current directory")
 			utils.Tip("Run 'nsm init' to create a new environment")
-			return
+			return utils.ErrNoProjectConfig
 		}
 
 		// Get installed packages
-		installedPkgs := make(map[string]bool)
-		nixEnvCmd := exec.Command("nix-env", "--query", "--installed")
-		output, err := nixEnvCmd.Output()
+		installedPkgs, err := utils.QueryProfilePackages(ctx)
 		if err != nil {
 			utils.Debug("Could not query installed packages: %v", err)
-		} else {
-			for _, line := range strings.Split(string(output), "\n") {
-				if pkg := strings.TrimSpace(line); pkg != "" {
-					installedPkgs[pkg] = true
-				}
-			}
 		}
 
 		// Read a configuration file
 		content, err := utils.ReadFile(configType)
 		if err != nil {
-			utils.Error("Failed to read %s: %v", configType, err)
-			return
+			return fmt.Errorf("failed to read %s: %w", configType, err)
 		}
 
-		var packages []string
-		if configType == "shell.nix" {
-			packages = utils.ExtractShellNixPackages(content)
-		} else {
-			packages = utils.ExtractFlakePackages(content)
+		editor, err := utils.NewNixEditor(content)
+		if err != nil {
+			return fmt.Errorf("%w: failed to parse %s: %w", utils.ErrValidationFailed, configType, err)
+		}
+		if err := selectShell(cmd, configType, editor); err != nil {
+			return err
 		}
+		packages := editor.Packages()
 
-		if len(packages) == 0 {
-			utils.Info("No packages found in %s", configType)
-			return
+		// Requested version constraints and pinned sources are part of the file
+		constraints := editor.Constraints()
+		pins := editor.PinnedPackages()
+
+		versions, err := utils.PackageVersions(ctx, configType, packages, pins)
+		if err != nil {
+			utils.Debug("Could not evaluate package versions: %v", err)
 		}
 
 		// Sort packages alphabetically
 		sort.Strings(packages)
 
-		// Prepare table data
-		headers := []string{"Package", "Status", "Source"}
-		var rows [][]string
-
+		onlyInstalled, _ := cmd.Flags().GetBool("installed")
+		shellName, _ := cmd.Flags().GetString("shell")
+		result := listResult{Config: configType, Shell: shellName, Packages: []listEntry{}}
 		for _, pkg := range packages {
-			status := "pending"
-			if installedPkgs[pkg] {
-				status = "installed"
+			_, installed := installedPkgs.Lookup(pkg)
+			if onlyInstalled && !installed {
+				continue
 			}
-
-			rows = append(rows, []string{
-				pkg,
-				status,
-				configType,
+			result.Packages = append(result.Packages, listEntry{
+				Package:    pkg,
+				Constraint: constraints[pkg],
+				Version:    versions[pkg],
+				Installed:  installed,
 			})
 		}
 
+		if printed, err := printResult(cmd, result); printed || err != nil {
+			return err
+		}
+
+		if len(result.Packages) == 0 {
+			utils.Info("No packages found in %s", configType)
+			return nil
+		}
+
 		// Output as a table
 		utils.Info("\n📦 Packages in your Nix environment:")
-		utils.Table(headers, rows)
+		utils.Table(result.Headers(), result.Rows())
 
-		utils.Info("\nTotal packages: %d", len(packages))
+		utils.Info("\nTotal packages: %d", len(result.Packages))
 		utils.Info("Configuration: %s", configType)
-
-		// Show tips based on package status
-		pendingCount := 0
-		for _, row := range rows {
-			if row[1] == "pending" {
-				pendingCount++
-			}
+		if shellName != "" {
+			utils.Info("Shell: %s", shellName)
 		}
 
-		if pendingCount > 0 {
+		// Show tips based on package status
+		if result.Pending() > 0 {
 			utils.Tip("Run 'nsm run' to enter shell with all packages")
 		}
+		return nil
 	},
 }
 
 func init() {
+	listCmd.Flags().Bool("json", false, "Output in JSON format")
+	listCmd.Flags().Bool("installed", false, "Only show packages installed in your profile")
+	listCmd.Flags().String("shell", "", "Named shell of the flake to list (default is the default shell)")
 	rootCmd.AddCommand(listCmd)
 }
@@ -0,0 +1,36 @@
+/*
+Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/mdaashir/NSM/utils"
+	"github.com/spf13/cobra"
+)
+
+// outputFlag is the value of the global --output flag
+var outputFlag string
+
+// outputFormat returns the format for the result of cmd. A --json flag of
+// the command selects JSON, as it did before --output existed.
+func outputFormat(cmd *cobra.Command) utils.OutputFormat {
+	if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+		return utils.OutputJSON
+	}
+	// The value has been validated before the command ran
+	format, _ := utils.ParseOutputFormat(outputFlag)
+	return format
+}
+
+// printResult prints the result of cmd in the requested format and reports
+// true, unless the human-readable table output was requested, which the
+// command prints itself
+func printResult(cmd *cobra.Command, result any) (bool, error) {
+	format := outputFormat(cmd)
+	if format == utils.OutputTable {
+		return false, nil
+	}
+	return true, utils.RenderResult(os.Stdout, format, result)
+}
@@ -4,7 +4,10 @@ Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/mdaashir/NSM/utils"
@@ -12,35 +15,279 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// configPinSources returns the nixpkgs snapshot of every configured pin
+func configPinSources() map[string]utils.NixpkgsSource {
+	config, err := utils.LoadConfig()
+	if err != nil {
+		utils.Debug("Could not load pins: %v", err)
+		return nil
+	}
+	return config.PinSources
+}
+
+// applyPins resolves every package of a Nix source that has a pin from its
+// pinned nixpkgs snapshot
+func applyPins(src string, sources map[string]utils.NixpkgsSource) (string, error) {
+	if len(sources) == 0 {
+		return src, nil
+	}
+
+	editor, err := utils.NewNixEditor(src)
+	if err != nil {
+		return "", err
+	}
+
+	packages := editor.Packages()
+	pinned := editor.PinnedPackages()
+	var names []string
+	for pkg := range sources {
+		names = append(names, pkg)
+	}
+	sort.Strings(names)
+
+	for _, pkg := range names {
+		if !slices.Contains(packages, pkg) || pinned[pkg].Revision == sources[pkg].Revision {
+			continue
+		}
+		if err := editor.PinPackage(pkg, sources[pkg]); err != nil {
+			return "", err
+		}
+	}
+	return editor.Result()
+}
+
+// editProjectFile applies edit to the project configuration file and writes
+// it back with a backup when anything changed
+func editProjectFile(configType string, edit func(editor *utils.NixEditor) error) error {
+	content, err := utils.ReadFile(configType)
+	if err != nil {
+		return err
+	}
+
+	editor, err := utils.NewNixEditor(content)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", configType, err)
+	}
+	if err := edit(editor); err != nil {
+		return err
+	}
+	if !editor.Changed() {
+		return nil
+	}
+
+	newContent, err := editor.Result()
+	if err != nil {
+		return err
+	}
+	if err := utils.BackupFile(configType); err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+	return utils.WriteFileAtomic(configType, []byte(newContent), 0600)
+}
+
+// shortRevision abbreviates a nixpkgs commit for display
+func shortRevision(rev string) string {
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
+
+// listPins prints the configured pins
+func listPins() error {
+	config, err := utils.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	pins := config.PinList()
+	if len(pins) == 0 {
+		utils.Info("No packages are pinned")
+		utils.Tip("Run 'nsm pin <package> <version>' to pin a package")
+		return nil
+	}
+
+	var rows [][]string
+	for _, pin := range pins {
+		rows = append(rows, []string{pin.Package, pin.Version, shortRevision(pin.Revision)})
+	}
+	utils.Info("\n📌 Pinned packages:")
+	utils.Table([]string{"Package", "Version", "Nixpkgs revision"}, rows)
+	return nil
+}
+
 var pinCmd = &cobra.Command{
 	Use:   "pin [package] [version]",
 	Short: "Pin a package to a specific version",
 	Long: `Pin a package to a specific version. This will update your NSM configuration
-to ensure the specified package version is used in future installations.`,
-	Args: cobra.ExactArgs(2),
+to ensure the specified package version is used in future installations.
+
+The package is resolved from a nixpkgs revision that provides the version.
+Without --rev, the revision is looked up in the local version history (see
+'nsm versions'), falling back to the configured channel when it has that version. In
+the current project, the package is switched to the pinned nixpkgs right away,
+and init, add and convert apply the pin to new configurations.
+With --rev, the commit is checked to provide the version first.
+
+In a flake, the shell must take its packages from nixpkgs.legacyPackages,
+directly or through a let binding such as
+pkgs = nixpkgs.legacyPackages.${system}. Package sets created with
+import nixpkgs { ... } cannot be pinned.
+
+Examples:
+  nsm pin nodejs 20.11.1                        # Pin to the channel's version
+  nsm pin nodejs 18.17.1 --rev 9957cd48326f...  # Pin to a nixpkgs commit
+  nsm pin --list                                # Show pinned packages`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if list, _ := cmd.Flags().GetBool("list"); list {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(2)(cmd, args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		pkg := strings.TrimSpace(args[0])
+		ctx := cmd.Context()
+		if list, _ := cmd.Flags().GetBool("list"); list {
+			return listPins()
+		}
+
+		pkg := utils.NormalizePackageName(strings.TrimSpace(args[0]))
 		version := strings.TrimSpace(args[1])
 
 		if pkg == "" || version == "" {
 			return fmt.Errorf("package name and version cannot be empty")
 		}
 
+		if !utils.ValidatePackage(pkg) {
+			return fmt.Errorf("%w: %s", utils.ErrInvalidPackage, pkg)
+		}
+
+		// Catch typos before recording a pin for a package that doesn't exist.
+		// Nested package sets are not part of the index.
+		if strings.Contains(pkg, ".") {
+			utils.Debug("Skipping index check for nested attribute %s", pkg)
+		} else if index, err := utils.LoadPackageIndex(ctx); err != nil {
+			utils.Debug("Package index unavailable, skipping package check: %v", err)
+		} else if !index.Contains(pkg) {
+			tipSimilarPackages(pkg, index.Names)
+			return fmt.Errorf("%w: %s was not found in nixpkgs", utils.ErrInvalidPackage, pkg)
+		}
+
 		// Validate version format
 		if !strings.HasPrefix(version, "v") && !strings.Contains(version, ".") {
 			utils.Warn("Version format might be invalid. Consider using semantic versioning (e.g., v1.0.0 or 1.0.0)")
 		}
 
-		err := utils.PinPackage()
-		if err != nil {
-			return fmt.Errorf("failed to pin package: %v", err)
+		// Find the nixpkgs snapshot that provides the version
+		var source utils.NixpkgsSource
+		if rev, _ := cmd.Flags().GetString("rev"); rev != "" {
+			if !utils.IsNixpkgsCommit(rev) {
+				return fmt.Errorf("invalid nixpkgs revision %q: expected a full commit hash", rev)
+			}
+			provided, err := utils.CheckRevisionVersion(ctx, pkg, version, rev)
+			switch {
+			case errors.Is(err, utils.ErrInvalidPackage), errors.Is(err, utils.ErrValidationFailed):
+				utils.Tip("Run 'nsm versions list %s' to see which nixpkgs revision provides each version", pkg)
+				return err
+			case err != nil:
+				utils.Warn("Could not check that nixpkgs %s provides %s %s: %v", shortRevision(rev), pkg, version, err)
+			case provided != version:
+				utils.Info("nixpkgs %s provides %s %s", shortRevision(rev), pkg, provided)
+			}
+			source.Revision = rev
+			if narHash, _, err := utils.PrefetchNixpkgs(ctx, rev); err != nil {
+				utils.Debug("Could not determine the hash of nixpkgs %s: %v", rev, err)
+			} else {
+				source.NarHash = narHash
+			}
+		} else {
+			record, err := utils.ResolveVersion(ctx, pkg, version)
+			if err != nil {
+				utils.Tip("Use 'nsm versions index <revision>' to learn the versions of a nixpkgs commit")
+				utils.Tip("Use --rev to pin to a nixpkgs commit that provides %s %s", pkg, version)
+				return fmt.Errorf("could not find a nixpkgs revision for %s %s: %w", pkg, version, err)
+			}
+			if record.Version != version {
+				utils.Info("Resolved %s %s to version %s", pkg, version, record.Version)
+			}
+			source = record.Source()
+		}
+		rev := source.Revision
+
+		// Switch the current project over to the pinned nixpkgs first, so a
+		// project that cannot be edited leaves the configuration untouched
+		if configType := utils.GetProjectConfigType(); configType != "" {
+			err := editProjectFile(configType, func(editor *utils.NixEditor) error {
+				if !slices.Contains(editor.Packages(), pkg) {
+					utils.Tip("Run 'nsm add %s' to add the pinned package to %s", pkg, configType)
+					return nil
+				}
+				return editor.PinPackage(pkg, source)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to pin %s in %s: %w", pkg, configType, err)
+			}
+			utils.Debug("Updated %s to use nixpkgs %s for %s", configType, shortRevision(rev), pkg)
+		}
+
+		if err := utils.PinPackage(pkg, version, source); err != nil {
+			return fmt.Errorf("failed to pin package: %w", err)
+		}
+
+		utils.Success("Successfully pinned %s to version %s (nixpkgs %s)", pkg, version, shortRevision(rev))
+		return nil
+	},
+}
+
+var unpinCmd = &cobra.Command{
+	Use:   "unpin [packages...]",
+	Short: "Remove package pins",
+	Long: `Remove the pins of one or more packages.
+
+The packages are resolved from the default nixpkgs again, both in your NSM
+configuration and in the current project.
+
+Examples:
+  nsm unpin nodejs          # Unpin a single package
+  nsm unpin nodejs python3  # Unpin several packages`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var unpinned []string
+		for _, arg := range args {
+			pkg := utils.NormalizePackageName(arg)
+			ok, err := utils.UnpinPackage(pkg)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				utils.Warn("Package '%s' is not pinned", pkg)
+				continue
+			}
+			unpinned = append(unpinned, pkg)
+		}
+		if len(unpinned) == 0 {
+			return nil
+		}
+
+		if configType := utils.GetProjectConfigType(); configType != "" {
+			err := editProjectFile(configType, func(editor *utils.NixEditor) error {
+				for _, pkg := range unpinned {
+					editor.UnpinPackage(pkg)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to unpin packages in %s: %w", configType, err)
+			}
 		}
 
-		utils.Success("Successfully pinned %s to version %s", pkg, version)
+		utils.Success("Unpinned package(s): %s", strings.Join(unpinned, ", "))
 		return nil
 	},
 }
 
 func init() {
+	pinCmd.Flags().String("rev", "", "nixpkgs commit that provides the version")
+	pinCmd.Flags().Bool("list", false, "List pinned packages")
 	rootCmd.AddCommand(pinCmd)
+	rootCmd.AddCommand(unpinCmd)
 }
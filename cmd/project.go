@@ -0,0 +1,112 @@
+/*
+Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mdaashir/NSM/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// projectFileFlag and projectDirFlag are the values of the global
+	// --file and --project-dir flags
+	projectFileFlag string
+	projectDirFlag  string
+
+	// startDir is the directory nsm was started in, before it changed to
+	// the project directory
+	startDir string
+)
+
+// enterProject changes to the directory of the project cmd works on: the
+// directory of --file, --project-dir, or the nearest parent directory with
+// a shell.nix, flake.nix or default.nix. nsm init creates a project in the
+// current directory instead of looking for one.
+func enterProject(cmd *cobra.Command) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+	startDir = dir
+
+	switch {
+	case projectFileFlag != "":
+		if err := utils.SetProjectFile(filepath.Base(projectFileFlag)); err != nil {
+			return err
+		}
+		dir = filepath.Dir(projectFileFlag)
+	case projectDirFlag != "":
+		dir = projectDirFlag
+	case cmd == initCmd:
+		return nil
+	default:
+		found, err := utils.FindProjectDir(dir)
+		if errors.Is(err, utils.ErrNoProjectConfig) {
+			// Commands needing a project report it themselves
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look for the project: %w", err)
+		}
+		dir = found
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		return fmt.Errorf("cannot use project directory: %w", err)
+	}
+	if dir != startDir {
+		utils.Debug("Using project in %s", dir)
+	}
+
+	if files := utils.ProjectConfigFiles("."); utils.ProjectFile() == "" && len(files) > 1 {
+		utils.Warn("Found %s, using %s", strings.Join(files, " and "), utils.GetProjectConfigType())
+		utils.Tip("Choose one with 'nsm config set shell.format <file>' or --file")
+	}
+	return nil
+}
+
+// fromStartDir resolves a path given on the command line against the
+// directory nsm was started in, which may differ from the project directory
+func fromStartDir(path string) string {
+	if path == "" || filepath.IsAbs(path) || startDir == "" {
+		return path
+	}
+	return filepath.Join(startDir, path)
+}
+
+// selectShell restricts editor to the shell named with the --shell flag of
+// cmd, or to the default shell
+func selectShell(cmd *cobra.Command, configType string, editor *utils.NixEditor) error {
+	name, _ := cmd.Flags().GetString("shell")
+	if err := editor.SelectShell(name); err != nil {
+		if configType == "flake.nix" {
+			utils.Tip("Run 'nsm shell list' to see the shells, or 'nsm shell new %s' to create it", name)
+		}
+		return fmt.Errorf("%s: %w", configType, err)
+	}
+	return nil
+}
+
+// shellLabel names a shell of the project in messages
+func shellLabel(name string) string {
+	if name == "" {
+		return "the default shell"
+	}
+	return fmt.Sprintf("shell %q", name)
+}
+
+// runCommand returns the nsm run command entering the shell selected with
+// the --shell flag of cmd, for tips
+func runCommand(cmd *cobra.Command) string {
+	if name, _ := cmd.Flags().GetString("shell"); name != "" {
+		return "nsm run --shell " + name
+	}
+	return "nsm run"
+}
@@ -4,81 +4,13 @@ Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
 package cmd
 
 import (
-	"os"
-	"strings"
+	"fmt"
+	"slices"
 
 	"github.com/mdaashir/NSM/utils"
 	"github.com/spf13/cobra"
 )
 
-// removePackagesFromShellNix removes packages from shell.nix
-func removePackagesFromShellNix(content string, toRemove map[string]bool) (string, int) {
-	lines := strings.Split(content, "\n")
-	var result []string
-	inPackages := false
-	removed := 0
-
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if strings.Contains(trimmed, "packages = with pkgs; [") {
-			inPackages = true
-			result = append(result, line)
-			continue
-		}
-		if inPackages {
-			if strings.Contains(trimmed, "];") {
-				inPackages = false
-				result = append(result, line)
-				continue
-			}
-			pkgName := strings.Fields(trimmed)
-			if len(pkgName) > 0 {
-				name := pkgName[0]
-				if toRemove[name] {
-					removed++
-					continue
-				}
-			}
-		}
-		result = append(result, line)
-	}
-	return strings.Join(result, "\n"), removed
-}
-
-// removePackagesFromFlake removes packages from flake.nix
-func removePackagesFromFlake(content string, toRemove map[string]bool) (string, int) {
-	lines := strings.Split(content, "\n")
-	var result []string
-	inBuildInputs := false
-	removed := 0
-
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if strings.Contains(trimmed, "buildInputs") {
-			inBuildInputs = true
-			result = append(result, line)
-			continue
-		}
-		if inBuildInputs && strings.Contains(trimmed, "];") {
-			inBuildInputs = false
-			result = append(result, line)
-			continue
-		}
-		if inBuildInputs {
-			pkgName := strings.Fields(trimmed)
-			if len(pkgName) > 0 {
-				name := pkgName[0]
-				if toRemove[name] {
-					removed++
-					continue
-				}
-			}
-		}
-		result = append(result, line)
-	}
-	return strings.Join(result, "\n"), removed
-}
-
 var removeCmd = &cobra.Command{
 	Use:   "remove [packages...]",
 	Short: "Remove one or more packages from the nix environment",
@@ -95,69 +27,83 @@ Usage:
 
 Examples:
   nsm remove gcc              # Remove single package
-  nsm remove python3 nodejs   # Remove multiple packages`,
+  nsm remove python3 nodejs   # Remove multiple packages
+  nsm remove --shell docs jq  # Remove from the devShells.docs shell of a flake`,
 	Args: cobra.MinimumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		// Check for Nix installation
 		if err := utils.CheckNixInstallation(); err != nil {
-			utils.Error("Nix is not installed. Please install Nix first!")
-			return
+			return err
 		}
 
 		// Create a map of packages to remove
 		toRemove := make(map[string]bool)
 		for _, pkg := range args {
-			toRemove[pkg] = true
+			toRemove[utils.NormalizePackageName(pkg)] = true
 		}
 
 		configType := utils.GetProjectConfigType()
 		if configType == "" {
-			utils.Error("No shell.nix or flake.nix found")
 			utils.Tip("Run 'nsm init' to create a new environment")
-			return
+			return utils.ErrNoProjectConfig
 		}
 
 		utils.Debug("Found configuration file: %s", configType)
 
 		// Create backup before modifying
 		if err := utils.BackupFile(configType); err != nil {
-			utils.Error("Failed to create backup: %v", err)
-			return
+			return fmt.Errorf("failed to create backup: %w", err)
 		}
 
 		// Read a configuration file
 		content, err := utils.ReadFile(configType)
 		if err != nil {
-			utils.Error("Error reading %s: %v", configType, err)
-			return
+			return fmt.Errorf("error reading %s: %w", configType, err)
 		}
 
-		var newContent string
-		var removed int
+		editor, err := utils.NewNixEditor(content)
+		if err != nil {
+			return fmt.Errorf("%w: failed to parse %s: %w", utils.ErrValidationFailed, configType, err)
+		}
 
-		if configType == "shell.nix" {
-			newContent, removed = removePackagesFromShellNix(content, toRemove)
-		} else {
-			newContent, removed = removePackagesFromFlake(content, toRemove)
+		if err := selectShell(cmd, configType, editor); err != nil {
+			return err
 		}
 
+		// Point out likely typos among the packages that are not declared
+		declared := editor.Packages()
+		for _, pkg := range args {
+			if !slices.Contains(declared, utils.NormalizePackageName(pkg)) {
+				utils.Warn("Package '%s' is not in %s", pkg, configType)
+				tipSimilarPackages(pkg, declared)
+			}
+		}
+
+		removed := editor.RemovePackages(toRemove)
+
 		if removed == 0 {
 			utils.Warn("No packages were found to remove")
-			return
+			return nil
+		}
+
+		newContent, err := editor.Result()
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %w", configType, err)
 		}
 
 		// Write changes
-		if err := os.WriteFile(configType, []byte(newContent), 0600); err != nil {
-			utils.Error("Error writing %s: %v", configType, err)
-			return
+		if err := utils.WriteFileAtomic(configType, []byte(newContent), 0600); err != nil {
+			return fmt.Errorf("error writing %s: %w", configType, err)
 		}
 
 		utils.Success("Removed %d package(s) from %s", removed, configType)
 		utils.Success("Backup created: %s.backup", configType)
-		utils.Tip("Run 'nsm run' to enter the updated shell")
+		utils.Tip("Run '%s' to enter the updated shell", runCommand(cmd))
+		return nil
 	},
 }
 
 func init() {
+	removeCmd.Flags().String("shell", "", "Named shell of the flake to remove from (default is the default shell)")
 	rootCmd.AddCommand(removeCmd)
 }
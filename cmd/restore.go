@@ -0,0 +1,215 @@
+/*
+Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/mdaashir/NSM/lockfile"
+	"github.com/mdaashir/NSM/utils"
+	"github.com/spf13/cobra"
+)
+
+// readLock loads a lock file, explaining a missing or invalid file
+func readLock(path string) (*lockfile.Lock, error) {
+	lock, err := lockfile.Read(path)
+	if os.IsNotExist(err) {
+		utils.Tip("Run 'nsm freeze' to create one")
+		return nil, fmt.Errorf("no lock file found at %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", utils.ErrValidationFailed, err)
+	}
+	return lock, nil
+}
+
+// lockDrift evaluates the locked shell of the project and compares it with
+// the lock
+func lockDrift(ctx context.Context, configType string, lock *lockfile.Lock) (*lockfile.Drift, error) {
+	snapshot, err := utils.SnapshotShell(ctx, configType, lock.Shell)
+	if err != nil {
+		return nil, err
+	}
+	return lockfile.Diff(lock, lockfile.FromSnapshot(snapshot, lock.Channel)), nil
+}
+
+// printDrift shows how the project shell differs from its lock
+func printDrift(drift *lockfile.Drift) {
+	if drift.Nixpkgs != nil {
+		utils.Warn("nixpkgs: locked %s, shell uses %s",
+			orNone(shortRevision(drift.Nixpkgs.Old.Revision)), orNone(shortRevision(drift.Nixpkgs.New.Revision)))
+	}
+	if len(drift.Changes) == 0 {
+		return
+	}
+	utils.Table(driftHeaders, driftRows(drift))
+}
+
+// driftHeaders are the columns of driftRows
+var driftHeaders = []string{"Package", "Change", "Locked", "Shell"}
+
+// driftRows renders the package changes between a lock and the shell
+func driftRows(drift *lockfile.Drift) [][]string {
+	var rows [][]string
+	for _, change := range drift.Changes {
+		locked, current := orNone(change.OldVersion), orNone(change.NewVersion)
+		if change.Kind == lockfile.RevisionChanged {
+			locked, current = shortRevision(change.OldRevision), shortRevision(change.NewRevision)
+		}
+		rows = append(rows, []string{change.Attr, string(change.Kind), locked, current})
+	}
+	return rows
+}
+
+// restoreFile puts back the previous content of a file, removing it when it
+// did not exist
+func restoreFile(path string, content []byte, existed bool) {
+	var err error
+	if existed {
+		err = utils.WriteFileAtomic(path, content, 0600)
+	} else {
+		err = os.Remove(path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		utils.Error("Failed to roll back %s: %v", path, err)
+	}
+}
+
+var restoreCmd = &cobra.Command{
+	Use:     "restore [lockfile]",
+	Aliases: []string{"install"},
+	Short:   "Rebuild the project shell from nsm.lock.json",
+	Long: `Rebuild the project shell from a lock file created by 'nsm freeze'.
+
+The shell.nix or flake.nix of the project is patched so its nixpkgs is
+pinned to the locked revision (a fetchTarball import for shell.nix, the
+nixpkgs input for flakes) and it declares exactly the locked packages.
+Packages locked from another nixpkgs revision are pinned to it. A lock of
+a named shell of a flake restores that shell. Without a shell definition, a
+new one of the locked type is created.
+
+The result is evaluated and compared with the lock. When the shell would
+differ from the lock, all changes are rolled back and the command fails.
+
+Examples:
+  nsm restore                    # Restore from nsm.lock.json
+  nsm restore backup.lock.json   # Restore from another lock file
+  nsm install                    # Same as 'nsm restore'`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		// Check for Nix installation
+		if err := utils.CheckNixInstallation(); err != nil {
+			return err
+		}
+
+		lockPath, lockFile := lockfile.FileName, lockfile.FileName
+		if len(args) > 0 {
+			lockPath, lockFile = args[0], fromStartDir(args[0])
+		}
+		lock, err := readLock(lockFile)
+		if err != nil {
+			return err
+		}
+		if !utils.IsNixpkgsCommit(lock.Nixpkgs.Revision) {
+			utils.Tip("Run 'nsm freeze' with a nixpkgs that reports its revision")
+			return fmt.Errorf("%s does not record a nixpkgs commit", lockPath)
+		}
+
+		// Patch the existing shell definition, or start from the template
+		configType := utils.GetProjectConfigType()
+		existed := configType != ""
+		var original string
+		if existed {
+			content, err := utils.ReadFile(configType)
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", configType, err)
+			}
+			original = content
+			if configType != lock.ConfigType {
+				utils.Warn("The lock was created from %s, restoring it into %s", lock.ConfigType, configType)
+			}
+		} else {
+			configType = lock.ConfigType
+			switch configType {
+			case "flake.nix":
+				original = getDefaultFlakeContent()
+			case "shell.nix", "default.nix":
+				// nix-shell reads default.nix like a shell.nix
+				original = getDefaultShellContent()
+			default:
+				return fmt.Errorf("%w: cannot create %s from %s", utils.ErrValidationFailed, configType, lockPath)
+			}
+			utils.Info("Creating %s from the lock", configType)
+		}
+		isFlake := configType == "flake.nix"
+
+		editor, err := utils.NewNixEditor(original)
+		if err != nil {
+			return fmt.Errorf("%w: failed to parse %s: %w", utils.ErrValidationFailed, configType, err)
+		}
+		if err := editor.SelectShell(lock.Shell); err != nil {
+			utils.Tip("Run 'nsm shell new %s' to create the locked shell", lock.Shell)
+			return fmt.Errorf("%s: %w", configType, err)
+		}
+		if err := editor.PinNixpkgs(utils.NixpkgsSource(lock.Nixpkgs)); err != nil {
+			return fmt.Errorf("failed to pin nixpkgs in %s: %w", configType, err)
+		}
+		if err := lock.SyncPackages(editor); err != nil {
+			return fmt.Errorf("failed to update the packages of %s: %w", configType, err)
+		}
+		content, err := editor.Result()
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %w", configType, err)
+		}
+
+		// Keep what is needed to roll back
+		flakeLock, flakeLockErr := os.ReadFile("flake.lock")
+		if existed {
+			if err := utils.BackupFile(configType); err != nil {
+				return fmt.Errorf("failed to create backup: %w", err)
+			}
+		}
+		rollback := func() {
+			restoreFile(configType, []byte(original), existed)
+			if isFlake {
+				restoreFile("flake.lock", flakeLock, flakeLockErr == nil)
+			}
+		}
+
+		if err := utils.WriteFileAtomic(configType, []byte(content), 0600); err != nil {
+			return fmt.Errorf("error writing to %s: %w", configType, err)
+		}
+		if isFlake {
+			if err := utils.UpdateFlakeLock(ctx, configType); err != nil {
+				rollback()
+				return err
+			}
+		}
+
+		// The restored shell has to match the lock exactly
+		utils.Info("🔍 Evaluating %s...", configType)
+		drift, err := lockDrift(ctx, configType, lock)
+		if err != nil {
+			rollback()
+			return fmt.Errorf("failed to evaluate %s: %w", configType, err)
+		}
+		if !drift.Empty() {
+			printDrift(drift)
+			rollback()
+			return fmt.Errorf("%w: the restored %s would differ from %s; no changes were made", utils.ErrValidationFailed, configType, lockPath)
+		}
+
+		utils.Success("Restored %s from %s (nixpkgs %s, %d packages)",
+			configType, lockPath, shortRevision(lock.Nixpkgs.Revision), len(lock.Packages))
+		utils.Tip("Run 'nsm run' to enter the restored shell")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+}
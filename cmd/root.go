@@ -4,8 +4,14 @@ Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
 package cmd
 
 import (
+	"context"
+	"errors"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mdaashir/NSM/utils"
 	"github.com/spf13/cobra"
@@ -31,40 +37,196 @@ Example Usage:
   nsm add gcc python3   # Add packages
   nsm list              # List installed packages
   nsm run              # Enter the Nix shell
-  nsm clean            # Clean up unused packages`,
+  nsm clean            # Clean up unused packages
+
+Exit codes:
+  0    Success
+  1    Any other error
+  3    The shell drifted from its lock (verify, run --frozen)
+  4    Nix is not installed
+  5    No shell.nix, flake.nix or default.nix in the project
+  6    Invalid or unknown package
+  7    Validation failed (shell syntax, lock file, nsm doctor checks)
+  8    An external command such as nix-shell failed or timed out
+  130  Interrupted`,
+	// Execute reports errors, and usage is only shown for invalid arguments
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Cobra checks flag groups after this hook, which needs them valid
+		if err := cmd.ValidateFlagGroups(); err != nil {
+			return err
+		}
+		if _, err := utils.ParseOutputFormat(outputFlag); err != nil {
+			return err
+		}
+		if _, err := utils.ParseLogFormat(logFormat); err != nil {
+			return err
+		}
+		if _, err := utils.ParseColorMode(colorFlag); err != nil {
+			return err
+		}
+		if _, err := utils.ParseTableStyle(tableStyleFlag); err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+		utils.With("command", cmd.CommandPath(), "args", strings.Join(args, " ")).Debug("Running command")
+
+		if err := enterProject(cmd); err != nil {
+			return err
+		}
+
+		// Messages go to stderr when stdout carries a result for programs
+		utils.LogToStderr(outputFormat(cmd) != utils.OutputTable)
+
+		// Bound every Nix command run below this command
+		if timeout > 0 {
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			cobra.OnFinalize(cancel)
+			cmd.SetContext(ctx)
+		}
+		return nil
+	},
 }
 
 var (
 	cfgFile   string
 	debugMode bool
 	quietMode bool
+	logFormat string
+	timeout   time.Duration
+
+	colorFlag      string
+	tableStyleFlag string
+
+	// logFile keeps a record of every run for diagnosing it afterwards
+	logFile *utils.RotatingFile
 )
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
-	// Initialize logger before executing
-	utils.ConfigureLogger(debugMode, quietMode)
+	// Ctrl-C and SIGTERM cancel the running Nix commands, which receive the
+	// interrupt and get a moment to clean up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err := rootCmd.Execute(); err != nil {
-		utils.Error("Error executing command: %v", err)
-		os.Exit(1)
+	err := rootCmd.ExecuteContext(ctx)
+	if err != nil {
+		// Errors with their own exit code have been reported by the command
+		var coder exitCoder
+		if !errors.As(err, &coder) {
+			utils.Error("%v", err)
+		}
+		utils.With("exit_code", exitCode(err)).Debug("Command failed: %v", err)
+	}
+	closeLogFile()
+	if err != nil {
+		stop()
+		os.Exit(exitCode(err))
 	}
 }
 
+// Exit codes for the classes of errors, documented in the help of nsm
+const (
+	exitCodeError           = 1
+	exitCodeNixNotInstalled = 4
+	exitCodeNoProjectConfig = 5
+	exitCodeInvalidPackage  = 6
+	exitCodeValidation      = 7
+	exitCodeExternalCommand = 8
+	exitCodeInterrupted     = 130
+)
+
+// exitCoder is implemented by command errors that map to a specific exit code
+type exitCoder interface {
+	ExitCode() int
+}
+
+// exitCode maps an error returned by a command to the exit code of nsm
+func exitCode(err error) int {
+	var coder exitCoder
+	switch {
+	case errors.As(err, &coder):
+		return coder.ExitCode()
+	case errors.Is(err, context.Canceled):
+		return exitCodeInterrupted
+	case errors.Is(err, utils.ErrNixNotInstalled):
+		return exitCodeNixNotInstalled
+	case errors.Is(err, utils.ErrNoProjectConfig):
+		return exitCodeNoProjectConfig
+	case errors.Is(err, utils.ErrInvalidPackage):
+		return exitCodeInvalidPackage
+	case errors.Is(err, utils.ErrValidationFailed):
+		return exitCodeValidation
+	case errors.Is(err, utils.ErrExternalCommandFailed), errors.Is(err, context.DeadlineExceeded):
+		return exitCodeExternalCommand
+	}
+	return exitCodeError
+}
+
 func init() {
-	cobra.OnInitialize(setupConfig)
+	cobra.OnInitialize(setupTerminal, setupLogging, setupConfig)
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.config/NSM/config.yaml)")
 	rootCmd.PersistentFlags().BoolVar(&debugMode, "debug", false, "enable debug output")
 	rootCmd.PersistentFlags().BoolVar(&quietMode, "quiet", false, "suppress non-error output")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", string(utils.LogFormatText), "format of log messages: text or json")
+	rootCmd.PersistentFlags().StringVarP(&outputFlag, "output", "o", string(utils.OutputTable), "output format: table, plain, json or yaml")
+	rootCmd.PersistentFlags().StringVar(&projectFileFlag, "file", "", "project file to use, a shell.nix, flake.nix or default.nix")
+	rootCmd.PersistentFlags().StringVarP(&projectDirFlag, "project-dir", "C", "", "project directory (default is the nearest parent with a shell.nix, flake.nix or default.nix)")
+	rootCmd.MarkFlagsMutuallyExclusive("file", "project-dir")
+	rootCmd.PersistentFlags().StringVar(&colorFlag, "color", string(utils.ColorAuto), "color output: auto, always or never")
+	rootCmd.PersistentFlags().StringVar(&tableStyleFlag, "table-style", string(utils.TablePlain), "table style: plain, markdown, csv or box")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0, "cancel Nix operations after this long, e.g. 10m (default no limit)")
 
 	// Remove default completion command
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 }
 
+// setupTerminal applies the color and table style flags. Invalid values are
+// reported by PersistentPreRunE.
+func setupTerminal() {
+	if mode, err := utils.ParseColorMode(colorFlag); err == nil {
+		utils.SetColorMode(mode)
+	}
+	if style, err := utils.ParseTableStyle(tableStyleFlag); err == nil {
+		utils.SetTableStyle(style)
+	}
+}
+
+// setupLogging applies the logging flags once cobra has parsed them and
+// opens the log file in the config directory
+func setupLogging() {
+	utils.ConfigureLogger(debugMode, quietMode)
+	// An invalid format is reported by PersistentPreRunE
+	if format, err := utils.ParseLogFormat(logFormat); err == nil {
+		utils.SetLogFormat(format)
+	}
+
+	file, err := utils.OpenLogFile()
+	if err != nil {
+		utils.Debug("Not writing a log file: %v", err)
+		return
+	}
+	logFile = file
+	utils.SetLogFile(logFile)
+}
+
+// closeLogFile stops writing to the log file
+func closeLogFile() {
+	if logFile == nil {
+		return
+	}
+	utils.SetLogFile(nil)
+	if err := logFile.Close(); err != nil {
+		utils.Debug("Could not close log file: %v", err)
+	}
+	logFile = nil
+}
+
 // setupConfig reads in config file and ENV variables if set
 func setupConfig() {
+	defaultConfigFile := cfgFile
 	if cfgFile != "" {
 		// Use config file from the flag
 		viper.SetConfigFile(cfgFile)
@@ -76,6 +238,7 @@ func setupConfig() {
 			os.Exit(1)
 		}
 
+		defaultConfigFile = filepath.Join(configDir, "config.yaml")
 		viper.AddConfigPath(configDir)
 		viper.SetConfigType("yaml")
 		viper.SetConfigName("config")
@@ -99,7 +262,6 @@ func setupConfig() {
 			utils.Debug("No config file found, using defaults")
 
 			// Create default config file with safe permissions
-			defaultConfigFile := filepath.Join(viper.GetString("config_path"), "config.yaml")
 			err := viper.WriteConfigAs(defaultConfigFile)
 			if err != nil {
 				utils.Debug("Could not create default config file: %v", err)
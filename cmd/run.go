@@ -4,9 +4,13 @@ Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
 
+	"github.com/mdaashir/NSM/lockfile"
 	"github.com/mdaashir/NSM/utils"
 	"github.com/spf13/cobra"
 )
@@ -25,6 +29,20 @@ func isValidShellArgs(args []string) bool {
 	return true
 }
 
+// checkShellExists reports an error when the shell named with --shell is not
+// defined by the project file
+func checkShellExists(cmd *cobra.Command, configType string) error {
+	content, err := utils.ReadFile(configType)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", configType, err)
+	}
+	editor, err := utils.NewNixEditor(content)
+	if err != nil {
+		return fmt.Errorf("%w: failed to parse %s: %w", utils.ErrValidationFailed, configType, err)
+	}
+	return selectShell(cmd, configType, editor)
+}
+
 // runCmd represents the run command
 var runCmd = &cobra.Command{
 	Use:   "run",
@@ -32,43 +50,88 @@ var runCmd = &cobra.Command{
 	Long: `Enter a Nix development environment based on your configuration.
 
 The command automatically detects and uses the appropriate method:
-- For shell.nix: Uses nix-shell
+- For shell.nix and default.nix: Uses nix-shell
 - For flake.nix: Uses nix develop
 
 Options:
   --pure    Run in pure mode (no inherited environment)
+  --frozen  Refuse to start when nsm.lock.json is out of date
 
 Examples:
   nsm run            # Enter the development environment
-  nsm run --pure    # Enter a pure shell`,
-	Run: func(cmd *cobra.Command, args []string) {
+  nsm run --pure    # Enter a pure shell
+  nsm run --frozen  # Enter the shell only if it matches the lock
+  nsm run --shell ci  # Enter the devShells.ci shell of a flake (nix develop .#ci)`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
 		// Check for Nix installation
 		if err := utils.CheckNixInstallation(); err != nil {
-			utils.Error("Nix is not installed. Please install Nix first!")
-			return
+			return err
 		}
 
 		configType := utils.GetProjectConfigType()
 		if configType == "" {
-			utils.Error("No shell.nix or flake.nix found")
 			utils.Tip("Run 'nsm init' to create a new environment")
-			return
+			return utils.ErrNoProjectConfig
 		}
 
 		utils.Debug("Using configuration file: %s", configType)
 
+		// Refuse to start a shell that no longer matches its lock
+		if frozen, _ := cmd.Flags().GetBool("frozen"); frozen {
+			lock, err := readLock(lockfile.FileName)
+			if err != nil {
+				return err
+			}
+			if name, _ := cmd.Flags().GetString("shell"); name != lock.Shell {
+				utils.Tip("Run 'nsm freeze' with the same --shell to lock the shell you enter")
+				return fmt.Errorf("%w: %s locks %s, not %s", utils.ErrValidationFailed,
+					lockfile.FileName, shellLabel(lock.Shell), shellLabel(name))
+			}
+			drift, err := lockDrift(ctx, configType, lock)
+			if err != nil {
+				return fmt.Errorf("failed to evaluate %s: %w", configType, err)
+			}
+			if !drift.Empty() {
+				utils.Error("%s is out of date with %s", lockfile.FileName, configType)
+				printDrift(drift)
+				utils.Tip("Run 'nsm freeze' to update the lock, or 'nsm restore' to return to it")
+				return &driftError{changes: len(drift.Changes), nixpkgs: drift.Nixpkgs != nil}
+			}
+			utils.Debug("%s matches %s", configType, lockfile.FileName)
+		}
+
 		isPure, err := cmd.Flags().GetBool("pure")
 		if err != nil {
-			utils.Error("Failed to get pure flag: %v", err)
-			return
+			return fmt.Errorf("failed to get pure flag: %w", err)
 		}
 
 		if isPure {
 			utils.Debug("Running in pure mode")
 		}
 
-		var c *exec.Cmd
-		if configType == "shell.nix" {
+		// A named shell must exist in the flake
+		shellName, _ := cmd.Flags().GetString("shell")
+		if shellName != "" {
+			if err := checkShellExists(cmd, configType); err != nil {
+				return err
+			}
+		}
+
+		currentDir, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current directory: %w", err)
+		}
+
+		// Started below the project directory, the shell opens where nsm
+		// was started and is given the project to use
+		shellDir, project := currentDir, ""
+		if startDir != "" && startDir != currentDir {
+			shellDir, project = startDir, currentDir
+		}
+
+		var shell utils.NixCommand
+		if configType != "flake.nix" {
 			utils.Info("🚀 Launching nix-shell...")
 			var cmdArgs []string
 			if isPure {
@@ -77,55 +140,69 @@ Examples:
 
 			// Validate command arguments
 			if !isValidShellArgs(cmdArgs) {
-				utils.Error("Invalid shell arguments")
-				return
+				return errors.New("invalid shell arguments")
 			}
 
-			c = exec.Command("nix-shell", cmdArgs...)
+			// nix-shell finds shell.nix in its directory by itself
+			if project != "" {
+				cmdArgs = append(cmdArgs, filepath.Join(project, configType))
+			} else if configType != "shell.nix" {
+				cmdArgs = append(cmdArgs, configType)
+			}
+
+			shell = utils.NixCommand{Name: "nix-shell", Args: cmdArgs}
 		} else {
 			utils.Info("🚀 Launching nix develop...")
-			if !utils.CheckFlakeSupport() {
-				utils.Error("Flakes are not enabled in your Nix configuration")
+			if !utils.CheckFlakeSupport(ctx) {
 				utils.Tip("Add 'experimental-features = nix-command flakes' to your Nix config")
-				return
+				return errors.New("flakes are not enabled in your Nix configuration")
 			}
-			cmdArgs := []string{"develop"}
+			var flags []string
 			if isPure {
-				cmdArgs = append(cmdArgs, "--pure")
+				flags = append(flags, "--pure")
 			}
 
 			// Validate command arguments
-			if !isValidShellArgs(cmdArgs[1:]) {
-				utils.Error("Invalid shell arguments")
-				return
+			if !isValidShellArgs(flags) {
+				return errors.New("invalid shell arguments")
 			}
 
-			c = exec.Command("nix", cmdArgs...)
-		}
+			// The flake and shell to enter, such as .#ci
+			cmdArgs := []string{"develop"}
+			if project != "" || shellName != "" {
+				ref := project
+				if ref == "" {
+					ref = "."
+				}
+				if shellName != "" {
+					ref += "#" + shellName
+				}
+				cmdArgs = append(cmdArgs, ref)
+			}
 
-		// Setup command environment
-		c.Env = os.Environ()
-		currentDir, err := os.Getwd()
-		if err != nil {
-			utils.Error("Failed to get current directory: %v", err)
-			return
+			shell = utils.NixCommand{Name: "nix", Args: append(cmdArgs, flags...)}
 		}
-		c.Dir = currentDir
-		c.Stdout = os.Stdout
-		c.Stderr = os.Stderr
-		c.Stdin = os.Stdin
 
-		// Run the command
-		err = c.Run()
+		// Setup command environment
+		shell.Dir = shellDir
+		shell.Stdout = os.Stdout
+		shell.Stderr = os.Stderr
+		shell.Stdin = os.Stdin
+
+		// The interactive shell receives Ctrl-C itself and is not bound by
+		// --timeout, so it must not be cancelled with the command
+		_, err = utils.RunNix(context.WithoutCancel(ctx), shell)
 		if err != nil {
-			utils.Error("Error running %s: %v", configType, err)
 			utils.Tip("Try running 'nsm doctor' to diagnose issues")
-			return
+			return fmt.Errorf("error running %s: %w", configType, err)
 		}
+		return nil
 	},
 }
 
 func init() {
 	runCmd.Flags().Bool("pure", false, "Run in pure mode (no inherited environment)")
+	runCmd.Flags().Bool("frozen", false, "Refuse to start when nsm.lock.json is out of date")
+	runCmd.Flags().String("shell", "", "Named shell of the flake to enter (default is the default shell)")
 	rootCmd.AddCommand(runCmd)
 }
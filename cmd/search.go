@@ -0,0 +1,112 @@
+/*
+Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mdaashir/NSM/utils"
+	"github.com/spf13/cobra"
+)
+
+// truncate shortens text to at most width characters
+func truncate(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// searchResults is the output of nsm search
+type searchResults []utils.SearchResult
+
+func (r searchResults) Headers() []string {
+	return []string{"Package", "Version", "Description"}
+}
+
+func (r searchResults) Rows() [][]string {
+	var rows [][]string
+	for _, result := range r {
+		rows = append(rows, []string{result.Attr, result.Version, truncate(result.Description, 60)})
+	}
+	return rows
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search [query...]",
+	Short: "Search nixpkgs for packages",
+	Long: `Search the packages of the configured nixpkgs channel.
+
+The query is matched against attribute names, package names, versions,
+descriptions and licenses. Packages matching every word of the query are
+listed with the best matches first.
+
+The package catalog is built once per nixpkgs revision and cached in the
+NSM config directory, so searches work offline and return instantly.
+
+Examples:
+  nsm search python                    # Find Python packages
+  nsm search json parser               # Match several words
+  nsm search --license mit http        # Only MIT licensed packages
+  nsm search --platform aarch64-darwin ripgrep
+  nsm search --json nodejs             # Output in JSON format
+  nsm search -o plain nodejs           # Output tab-separated rows`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		// Check for Nix installation
+		if err := utils.CheckNixInstallation(); err != nil {
+			return err
+		}
+
+		license, _ := cmd.Flags().GetString("license")
+		platform, _ := cmd.Flags().GetString("platform")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		index, err := utils.LoadPackageIndex(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load package index: %w", err)
+		}
+
+		query := strings.Join(args, " ")
+		results := index.Search(query, utils.SearchOptions{
+			License:  license,
+			Platform: platform,
+			Limit:    limit,
+		})
+
+		found := searchResults(results)
+		if found == nil {
+			found = searchResults{}
+		}
+		if printed, err := printResult(cmd, found); printed || err != nil {
+			return err
+		}
+
+		if len(results) == 0 {
+			utils.Info("No packages found matching '%s'", query)
+			if suggestions := index.Suggest(query, 3); len(suggestions) > 0 {
+				utils.Tip("Did you mean %s?", strings.Join(suggestions, ", "))
+			}
+			return nil
+		}
+
+		utils.Info("\n🔍 Packages matching '%s':", query)
+		utils.Table(found.Headers(), found.Rows())
+
+		utils.Info("\nShowing %d result(s) from nixpkgs %s", len(results), index.Revision)
+		utils.Tip("Run 'nsm add <package>' to add a package to your environment")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+	searchCmd.Flags().Bool("json", false, "Output in JSON format")
+	searchCmd.Flags().String("license", "", "Only show packages with a matching license, such as mit")
+	searchCmd.Flags().String("platform", "", "Only show packages available on a platform, such as x86_64-linux")
+	searchCmd.Flags().Int("limit", 20, "Maximum number of results (0 for no limit)")
+}
@@ -0,0 +1,182 @@
+/*
+Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/mdaashir/NSM/utils"
+	"github.com/spf13/cobra"
+)
+
+// flakeConfig returns the project file, which must be a flake for named shells
+func flakeConfig() (string, error) {
+	configType := utils.GetProjectConfigType()
+	switch configType {
+	case "":
+		utils.Tip("Run 'nsm init --flake' to create a new environment")
+		return "", utils.ErrNoProjectConfig
+	case "flake.nix":
+		return configType, nil
+	}
+	utils.Tip("Run 'nsm convert' to turn shell.nix into a flake")
+	return "", fmt.Errorf("named shells need a flake.nix, the project uses %s", configType)
+}
+
+// shellEntry is a shell of nsm shell list
+type shellEntry struct {
+	Name      string `json:"name"`
+	Attribute string `json:"attribute"`
+	Packages  int    `json:"packages"`
+}
+
+// shellsResult is the output of nsm shell list
+type shellsResult struct {
+	Config string       `json:"config"`
+	Shells []shellEntry `json:"shells"`
+}
+
+func (r shellsResult) Headers() []string {
+	return []string{"Shell", "Attribute", "Packages"}
+}
+
+func (r shellsResult) Rows() [][]string {
+	var rows [][]string
+	for _, shell := range r.Shells {
+		rows = append(rows, []string{orNone(shell.Name), orNone(shell.Attribute), strconv.Itoa(shell.Packages)})
+	}
+	return rows
+}
+
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Manage the named shells of a flake",
+	Long: `Manage the development shells a flake exposes under devShells.
+
+Every shell is entered with 'nix develop .#<name>', and the default shell
+with 'nix develop'. Commands that change or use packages take --shell to
+work on a shell other than the default one.
+
+Examples:
+  nsm shell list              # List the shells of flake.nix
+  nsm shell new ci            # Add devShells.ci next to the default shell
+  nsm add --shell ci jq       # Add a package to the ci shell
+  nsm run --shell ci          # Enter the ci shell`,
+}
+
+var shellListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the shells of the project",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configType := utils.GetProjectConfigType()
+		if configType == "" {
+			utils.Tip("Run 'nsm init' to create a new environment")
+			return utils.ErrNoProjectConfig
+		}
+
+		content, err := utils.ReadFile(configType)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", configType, err)
+		}
+		editor, err := utils.NewNixEditor(content)
+		if err != nil {
+			return fmt.Errorf("%w: failed to parse %s: %w", utils.ErrValidationFailed, configType, err)
+		}
+
+		result := shellsResult{Config: configType, Shells: []shellEntry{}}
+		for _, shell := range editor.Shells() {
+			entry := shellEntry{Name: shell.Name, Attribute: shell.Attribute()}
+			for _, list := range editor.PackageLists() {
+				if list.Shell == shell.Args {
+					entry.Packages += len(list.List.Children)
+				}
+			}
+			result.Shells = append(result.Shells, entry)
+		}
+		// The shell of shell.nix and default.nix is their default shell
+		if len(result.Shells) == 1 && result.Shells[0].Name == "" {
+			result.Shells[0].Name = utils.DefaultShellName
+		}
+
+		if printed, err := printResult(cmd, result); printed || err != nil {
+			return err
+		}
+
+		if len(result.Shells) == 0 {
+			utils.Info("No mkShell found in %s", configType)
+			return nil
+		}
+		utils.Info("🐚 Shells in %s:", configType)
+		utils.Table(result.Headers(), result.Rows())
+		return nil
+	},
+}
+
+var shellNewCmd = &cobra.Command{
+	Use:   "new <name>",
+	Short: "Add a named shell to the flake",
+	Long: `Add a shell to flake.nix under devShells, next to the default shell.
+
+The new shell uses the same mkShell and package set as the default shell
+and starts without packages. A flake with the legacy devShell attribute
+gets the new shell as devShells.<name>.
+
+Examples:
+  nsm shell new ci      # Add devShells.ci
+  nsm shell new docs    # Add devShells.docs`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if err := utils.ValidateShellName(name); err != nil {
+			utils.Tip("Shell names start with a letter and contain letters, digits, '-' and '_'")
+			return err
+		}
+
+		configType, err := flakeConfig()
+		if err != nil {
+			return err
+		}
+
+		content, err := utils.ReadFile(configType)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", configType, err)
+		}
+		editor, err := utils.NewNixEditor(content)
+		if err != nil {
+			return fmt.Errorf("%w: failed to parse %s: %w", utils.ErrValidationFailed, configType, err)
+		}
+
+		if err := editor.AddShell(name); err != nil {
+			return fmt.Errorf("cannot add shell %s to %s: %w", name, configType, err)
+		}
+		newContent, err := editor.Result()
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %w", configType, err)
+		}
+		if _, err := utils.ParseNix(newContent); err != nil {
+			return errors.New("adding the shell would break " + configType + ", no changes were made")
+		}
+
+		if err := utils.BackupFile(configType); err != nil {
+			return fmt.Errorf("failed to create backup: %w", err)
+		}
+		if err := utils.WriteFileAtomic(configType, []byte(newContent), 0600); err != nil {
+			return fmt.Errorf("error writing %s: %w", configType, err)
+		}
+
+		utils.Success("Added shell %s to %s", name, configType)
+		utils.Tip("Run 'nsm add --shell %s <package>' to add packages", name)
+		utils.Tip("Run 'nsm run --shell %s' to enter it", name)
+		return nil
+	},
+}
+
+func init() {
+	shellCmd.AddCommand(shellListCmd)
+	shellCmd.AddCommand(shellNewCmd)
+	rootCmd.AddCommand(shellCmd)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mdaashir/NSM/utils"
+	"github.com/spf13/cobra"
+)
+
+// showOutput is the output of nsm show
+type showOutput struct {
+	*utils.PackageDetails
+	InProject bool   `json:"inProject"`
+	Config    string `json:"config,omitempty"`
+}
+
+func (s showOutput) Headers() []string {
+	return []string{"Field", "Value"}
+}
+
+func (s showOutput) Rows() [][]string {
+	closure := "unknown"
+	if s.ClosureSize > 0 {
+		closure = utils.FormatSize(s.ClosureSize)
+	}
+	status := "not in project"
+	if s.InProject {
+		status = "in " + s.Config
+	} else if s.Config == "" {
+		status = "no project"
+	}
+
+	return [][]string{
+		{"Attribute", s.Attr},
+		{"Name", orNone(s.Pname)},
+		{"Version", orNone(s.Version)},
+		{"Description", orNone(s.Description)},
+		{"Homepage", orNone(s.Homepage)},
+		{"License", orNone(strings.Join(s.Licenses, ", "))},
+		{"Maintainers", orNone(summarizeList(s.Maintainers, 5))},
+		{"Platforms", orNone(summarizeList(s.Platforms, 5))},
+		{"Outputs", orNone(strings.Join(s.Outputs, ", "))},
+		{"Store path", orNone(s.StorePath)},
+		{"Closure size", closure},
+		{"Status", status},
+	}
+}
+
+// projectDeclares reports whether the project configuration lists pkg
+func projectDeclares(configType, pkg string) bool {
+	content, err := utils.ReadFile(configType)
+	if err != nil {
+		utils.Debug("Could not read %s: %v", configType, err)
+		return false
+	}
+	packages, err := utils.ExtractNixPackages(content)
+	if err != nil {
+		utils.Debug("Could not parse %s: %v", configType, err)
+		return false
+	}
+	return slices.Contains(packages, pkg)
+}
+
+// summarizeList joins values, eliding all but the first limit of them
+func summarizeList(values []string, limit int) string {
+	if len(values) <= limit {
+		return strings.Join(values, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(values[:limit], ", "), len(values)-limit)
+}
+
+// orNone renders empty values as a dash
+func orNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+var showCmd = &cobra.Command{
+	Use:   "show <package>",
+	Short: "Show details about a package",
+	Long: `Show everything known about a nixpkgs package before adding it.
+
+The package is evaluated in the nixpkgs of the current project, or in
+<nixpkgs> outside of a project. This command will show:
+- Description, homepage and license
+- Version, maintainers and supported platforms
+- Outputs, store path and closure size
+- Whether the package is already in shell.nix or flake.nix
+
+Examples:
+  nsm show ripgrep                      # Show package details
+  nsm show python3Packages.requests     # Attribute paths work too
+  nsm show --json jq                    # Output in JSON format`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		// Check for Nix installation
+		if err := utils.CheckNixInstallation(); err != nil {
+			return err
+		}
+
+		pkg := utils.NormalizePackageName(args[0])
+		if !utils.ValidatePackage(pkg) {
+			utils.Tip("Package names are attribute paths such as 'gcc' or 'python3Packages.numpy'")
+			return fmt.Errorf("%w: %s", utils.ErrInvalidPackage, args[0])
+		}
+
+		configType := utils.GetProjectConfigType()
+		evalConfig := configType
+		if evalConfig == "" {
+			evalConfig = "shell.nix"
+		}
+
+		details, err := utils.GetPackageDetails(ctx, evalConfig, pkg)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate %s: %w", pkg, err)
+		}
+		if details == nil {
+			if index, err := utils.LoadPackageIndex(ctx); err == nil {
+				tipSimilarPackages(pkg, index.Names)
+			}
+			utils.Tip("Run 'nsm search <name>' to look up package names")
+			return fmt.Errorf("%w: %s was not found in nixpkgs", utils.ErrInvalidPackage, pkg)
+		}
+
+		if details.StorePath != "" {
+			if size, err := utils.GetClosureSize(ctx, details.StorePath); err != nil {
+				utils.Debug("Closure size unavailable: %v", err)
+			} else {
+				details.ClosureSize = size
+			}
+		}
+
+		inProject := configType != "" && projectDeclares(configType, pkg)
+
+		result := showOutput{details, inProject, configType}
+		if printed, err := printResult(cmd, result); printed || err != nil {
+			return err
+		}
+
+		utils.Info("\n📦 %s", details.Attr)
+		utils.Table(result.Headers(), result.Rows())
+
+		if !inProject && configType != "" {
+			utils.Tip("Run 'nsm add %s' to add it to your environment", details.Attr)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(showCmd)
+	showCmd.Flags().Bool("json", false, "Output in JSON format")
+}
@@ -4,7 +4,7 @@ Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
 package cmd
 
 import (
-	"os/exec"
+	"fmt"
 
 	"github.com/mdaashir/NSM/utils"
 	"github.com/spf13/cobra"
@@ -26,41 +26,37 @@ Example:
 
 Note: After upgrading, you may need to rebuild your
 environment by running 'nsm run' again.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
 		// Check for Nix installation
 		if err := utils.CheckNixInstallation(); err != nil {
-			utils.Error("Nix is not installed. Please install Nix first!")
-			return
+			return err
 		}
 
 		// Get current channel info for comparison
-		oldChannel, err := utils.GetChannelInfo()
+		oldChannel, err := utils.GetChannelInfo(ctx)
 		if err != nil {
-			utils.Error("Could not get current channel info: %v", err)
-			return
+			return fmt.Errorf("could not get current channel info: %w", err)
 		}
 
 		utils.Info("🔄 Updating nixpkgs channel...")
 
 		// Run nix-channel --update
-		c := exec.Command("nix-channel", "--update")
-		output, err := c.CombinedOutput()
+		output, err := utils.UpdateChannels(ctx)
 		if err != nil {
-			utils.Error("Failed to update nixpkgs: %v", err)
 			utils.Tip("Try running 'nsm doctor' to check your installation")
-			return
+			return fmt.Errorf("failed to update nixpkgs: %w", err)
 		}
 
 		// Get new channel info
-		newChannel, err := utils.GetChannelInfo()
+		newChannel, err := utils.GetChannelInfo(ctx)
 		if err != nil {
-			utils.Error("Could not get updated channel info: %v", err)
-			return
+			return fmt.Errorf("could not get updated channel info: %w", err)
 		}
 
 		utils.Success("Updated nixpkgs channel!")
 		if len(output) > 0 {
-			utils.Debug("Update details:\n%s", string(output))
+			utils.Debug("Update details:\n%s", output)
 		}
 
 		if oldChannel != newChannel {
@@ -68,6 +64,7 @@ environment by running 'nsm run' again.`,
 		}
 
 		utils.Tip("Run 'nsm run' to enter shell with updated packages")
+		return nil
 	},
 }
 
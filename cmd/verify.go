@@ -0,0 +1,127 @@
+/*
+Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mdaashir/NSM/lockfile"
+	"github.com/mdaashir/NSM/utils"
+	"github.com/spf13/cobra"
+)
+
+// exitCodeDrift is the exit code of nsm verify when the shell drifted from its lock
+const exitCodeDrift = 3
+
+// driftError reports that the project shell no longer matches its lock
+type driftError struct {
+	changes int
+	nixpkgs bool
+}
+
+func (e *driftError) Error() string {
+	if e.nixpkgs {
+		return fmt.Sprintf("shell drifted from the lock: nixpkgs revision and %d package change(s)", e.changes)
+	}
+	return fmt.Sprintf("shell drifted from the lock: %d package change(s)", e.changes)
+}
+
+// ExitCode maps drift to its own exit code so CI can tell it from failures
+func (e *driftError) ExitCode() int {
+	return exitCodeDrift
+}
+
+// verifyReport is the JSON output of nsm verify
+type verifyReport struct {
+	Lock   string `json:"lock"`
+	Config string `json:"config"`
+	InSync bool   `json:"inSync"`
+	*lockfile.Drift
+}
+
+func (r verifyReport) Headers() []string {
+	return driftHeaders
+}
+
+func (r verifyReport) Rows() [][]string {
+	return driftRows(r.Drift)
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that the project shell matches nsm.lock.json",
+	Long: `Re-evaluate the project's shell.nix or flake.nix and compare it with
+nsm.lock.json.
+
+Drift is reported per package as added, removed, version-changed or
+revision-changed, together with a change of the nixpkgs revision the shell
+builds against.
+
+Exit codes:
+  0  The shell matches the lock
+  3  The shell drifted from the lock
+Failures to read or evaluate the lock or the shell exit with the codes
+listed in 'nsm --help'.
+
+Examples:
+  nsm verify                      # Check against nsm.lock.json
+  nsm verify --json               # Output the drift report as JSON
+  nsm verify --lock ci.lock.json  # Check against another lock file`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Check for Nix installation
+		if err := utils.CheckNixInstallation(); err != nil {
+			return err
+		}
+
+		configType := utils.GetProjectConfigType()
+		if configType == "" {
+			utils.Tip("Run 'nsm init' to create a new environment")
+			return utils.ErrNoProjectConfig
+		}
+
+		lockPath, _ := cmd.Flags().GetString("lock")
+		lockFile := lockPath
+		if cmd.Flags().Changed("lock") {
+			lockFile = fromStartDir(lockPath)
+		}
+		lock, err := readLock(lockFile)
+		if err != nil {
+			return err
+		}
+
+		utils.Info("🔍 Evaluating %s...", configType)
+		drift, err := lockDrift(cmd.Context(), configType, lock)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate %s: %w", configType, err)
+		}
+
+		report := verifyReport{Lock: lockPath, Config: configType, InSync: drift.Empty(), Drift: drift}
+		printed, err := printResult(cmd, report)
+		switch {
+		case err != nil:
+			return err
+		case printed:
+		case drift.Empty():
+			utils.Success("%s matches %s (%d packages)", configType, lockPath, len(lock.Packages))
+		default:
+			utils.Error("%s has drifted from %s", configType, lockPath)
+			printDrift(drift)
+			utils.Tip("Run 'nsm freeze' to update the lock, or 'nsm restore' to return to it")
+		}
+
+		if !drift.Empty() {
+			return &driftError{changes: len(drift.Changes), nixpkgs: drift.Nixpkgs != nil}
+		}
+		return nil
+	},
+}
+
+func init() {
+	verifyCmd.Flags().Bool("json", false, "Output the drift report in JSON format")
+	verifyCmd.Flags().String("lock", lockfile.FileName, "Lock file to verify against")
+	rootCmd.AddCommand(verifyCmd)
+}
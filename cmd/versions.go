@@ -0,0 +1,144 @@
+/*
+Copyright © 2025 Mohamed Aashir S <s.mohamedaashir@gmail.com>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mdaashir/NSM/utils"
+	"github.com/spf13/cobra"
+)
+
+var versionsCmd = &cobra.Command{
+	Use:   "versions",
+	Short: "Manage the package version history",
+	Long: `Manage the local history of which nixpkgs revision provides each
+package version. nsm pin uses it to find the nixpkgs commit for a version.
+
+The history grows automatically with every channel snapshot NSM indexes,
+and can be extended by indexing specific nixpkgs commits or by importing
+a JSON dump of records:
+
+  [{"attr": "nodejs", "version": "18.17.1", "revision": "<commit>", "narHash": "sha256-..."}]
+
+Examples:
+  nsm versions list nodejs                # Show known versions of nodejs
+  nsm versions index                      # Index the configured channel
+  nsm versions index 9957cd48326f...      # Index a nixpkgs commit
+  nsm versions import versions.json       # Import a JSON dump`,
+}
+
+var versionsListCmd = &cobra.Command{
+	Use:   "list [package]",
+	Short: "List the known versions of a package",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := utils.LoadVersionDB()
+		if err != nil {
+			return fmt.Errorf("failed to load version history: %w", err)
+		}
+
+		pkg := utils.NormalizePackageName(args[0])
+		records := db.Versions(pkg)
+		if len(records) == 0 {
+			utils.Info("No versions of %s are known", pkg)
+			utils.Tip("Run 'nsm versions index' to index the configured channel")
+			return nil
+		}
+
+		var rows [][]string
+		for _, record := range records {
+			rows = append(rows, []string{record.Version, shortRevision(record.Revision), orNone(record.NarHash)})
+		}
+		utils.Info("\n🕘 Known versions of %s:", pkg)
+		utils.Table([]string{"Version", "Nixpkgs revision", "NAR hash"}, rows)
+		utils.Tip("Run 'nsm pin %s <version>' to pin one of them", pkg)
+		return nil
+	},
+}
+
+var versionsIndexCmd = &cobra.Command{
+	Use:   "index [revisions...]",
+	Short: "Record the package versions of nixpkgs revisions",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		// Check for Nix installation
+		if err := utils.CheckNixInstallation(); err != nil {
+			return err
+		}
+
+		db, err := utils.LoadVersionDB()
+		if err != nil {
+			return fmt.Errorf("failed to load version history: %w", err)
+		}
+
+		if len(args) == 0 {
+			// The configured channel: its package index feeds the history
+			index, err := utils.LoadPackageIndex(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to index the configured channel: %w", err)
+			}
+			if !utils.IsNixpkgsCommit(index.Revision) {
+				return errors.New("the configured channel does not report its nixpkgs commit")
+			}
+			args = []string{index.Revision}
+		}
+
+		for _, revision := range args {
+			added, err := utils.IndexNixpkgsRevision(ctx, db, revision)
+			if err != nil {
+				return fmt.Errorf("failed to index %s: %w", revision, err)
+			}
+			utils.Success("Indexed nixpkgs %s: %d new version(s)", shortRevision(revision), added)
+		}
+
+		if err := db.Save(); err != nil {
+			return fmt.Errorf("failed to save version history: %w", err)
+		}
+		return nil
+	},
+}
+
+var versionsImportCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Import version records from a JSON dump",
+	Long:  `Import version records from a JSON dump. Use - to read from standard input.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var input io.Reader = os.Stdin
+		if args[0] != "-" {
+			file, err := os.Open(fromStartDir(args[0]))
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", args[0], err)
+			}
+			defer file.Close()
+			input = file
+		}
+
+		db, err := utils.LoadVersionDB()
+		if err != nil {
+			return fmt.Errorf("failed to load version history: %w", err)
+		}
+
+		added, err := db.Import(input)
+		if err != nil {
+			return fmt.Errorf("failed to import versions: %w", err)
+		}
+		if err := db.Save(); err != nil {
+			return fmt.Errorf("failed to save version history: %w", err)
+		}
+		utils.Success("Imported %d new version(s)", added)
+		return nil
+	},
+}
+
+func init() {
+	versionsCmd.AddCommand(versionsListCmd)
+	versionsCmd.AddCommand(versionsIndexCmd)
+	versionsCmd.AddCommand(versionsImportCmd)
+	rootCmd.AddCommand(versionsCmd)
+}
@@ -0,0 +1,125 @@
+package lockfile
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ChangeKind classifies how a locked package differs between two locks
+type ChangeKind string
+
+const (
+	// Added packages are only in the new lock
+	Added ChangeKind = "added"
+	// Removed packages are only in the old lock
+	Removed ChangeKind = "removed"
+	// VersionChanged packages have a different version
+	VersionChanged ChangeKind = "version-changed"
+	// RevisionChanged packages keep their version but come from another
+	// nixpkgs revision
+	RevisionChanged ChangeKind = "revision-changed"
+)
+
+// Change is a difference in one package between two locks
+type Change struct {
+	Attr        string     `json:"attr"`
+	Kind        ChangeKind `json:"kind"`
+	OldVersion  string     `json:"oldVersion,omitempty"`
+	NewVersion  string     `json:"newVersion,omitempty"`
+	OldRevision string     `json:"oldRevision,omitempty"`
+	NewRevision string     `json:"newRevision,omitempty"`
+}
+
+// Major reports whether the change moves a package to another major version
+func (c Change) Major() bool {
+	if c.Kind != VersionChanged {
+		return false
+	}
+	oldMajor, okOld := majorVersion(c.OldVersion)
+	newMajor, okNew := majorVersion(c.NewVersion)
+	return okOld && okNew && oldMajor != newMajor
+}
+
+// majorVersion returns the leading numeric component of a version, such as
+// 18 for 18.17.1
+func majorVersion(version string) (int, bool) {
+	version = strings.TrimPrefix(version, "v")
+	end := strings.IndexFunc(version, func(r rune) bool { return !unicode.IsDigit(r) })
+	if end == -1 {
+		end = len(version)
+	}
+	major, err := strconv.Atoi(version[:end])
+	return major, err == nil
+}
+
+// NixpkgsChange is a change of the nixpkgs snapshot a shell builds against
+type NixpkgsChange struct {
+	Old Nixpkgs `json:"old"`
+	New Nixpkgs `json:"new"`
+}
+
+// Drift lists the differences between two locks
+type Drift struct {
+	Nixpkgs *NixpkgsChange `json:"nixpkgs,omitempty"`
+	Changes []Change       `json:"changes"`
+}
+
+// Empty reports whether the two locks describe the same environment
+func (d *Drift) Empty() bool {
+	return d.Nixpkgs == nil && len(d.Changes) == 0
+}
+
+// Diff compares the package sets, versions and nixpkgs revisions of two
+// locks, from the old lock to the new one. Changes are sorted by attribute path.
+func Diff(from, to *Lock) *Drift {
+	drift := &Drift{Changes: []Change{}}
+	if from.Nixpkgs.Revision != to.Nixpkgs.Revision {
+		drift.Nixpkgs = &NixpkgsChange{Old: from.Nixpkgs, New: to.Nixpkgs}
+	}
+
+	for attr, before := range from.Packages {
+		after, ok := to.Packages[attr]
+		switch {
+		case !ok:
+			drift.Changes = append(drift.Changes, Change{
+				Attr:        attr,
+				Kind:        Removed,
+				OldVersion:  before.Version,
+				OldRevision: before.Nixpkgs.Revision,
+			})
+		case before.Version != after.Version:
+			drift.Changes = append(drift.Changes, Change{
+				Attr:        attr,
+				Kind:        VersionChanged,
+				OldVersion:  before.Version,
+				NewVersion:  after.Version,
+				OldRevision: before.Nixpkgs.Revision,
+				NewRevision: after.Nixpkgs.Revision,
+			})
+		case before.Nixpkgs.Revision != after.Nixpkgs.Revision:
+			drift.Changes = append(drift.Changes, Change{
+				Attr:        attr,
+				Kind:        RevisionChanged,
+				OldVersion:  before.Version,
+				NewVersion:  after.Version,
+				OldRevision: before.Nixpkgs.Revision,
+				NewRevision: after.Nixpkgs.Revision,
+			})
+		}
+	}
+	for attr, after := range to.Packages {
+		if _, ok := from.Packages[attr]; !ok {
+			drift.Changes = append(drift.Changes, Change{
+				Attr:        attr,
+				Kind:        Added,
+				NewVersion:  after.Version,
+				NewRevision: after.Nixpkgs.Revision,
+			})
+		}
+	}
+
+	sort.Slice(drift.Changes, func(i, j int) bool { return drift.Changes[i].Attr < drift.Changes[j].Attr })
+	return drift
+}
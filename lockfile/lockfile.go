@@ -0,0 +1,194 @@
+// Package lockfile reads and writes nsm.lock.json, the snapshot of a project
+// shell created by nsm freeze.
+//
+// The format is described by the JSON schema in schema.json. Lock files are
+// decoded strictly: unknown fields are reported instead of being dropped, and
+// files written with an older schema version are migrated to CurrentVersion.
+package lockfile
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/mdaashir/NSM/utils"
+)
+
+const (
+	// FileName is the name of the lock file in a project directory
+	FileName = "nsm.lock.json"
+	// CurrentVersion is the schema version written by this release
+	CurrentVersion = "2.0.0"
+)
+
+// Schema is the JSON schema of the current lock file format
+//
+//go:embed schema.json
+var Schema []byte
+
+// Lock is the content of a lock file
+type Lock struct {
+	Version    string `json:"version"`
+	ConfigType string `json:"config_type"`
+	// Shell is the named shell of a flake that is locked, empty for the
+	// default shell
+	Shell   string `json:"shell,omitempty"`
+	Channel string `json:"channel"`
+	// Nixpkgs is the snapshot the shell builds against
+	Nixpkgs Nixpkgs `json:"nixpkgs"`
+	// Packages maps attribute paths to the locked packages
+	Packages map[string]Package `json:"packages"`
+}
+
+// Nixpkgs identifies a nixpkgs snapshot
+type Nixpkgs struct {
+	Revision string `json:"revision"`
+	NarHash  string `json:"narHash,omitempty"`
+}
+
+// Package is a locked package of the shell
+type Package struct {
+	Attr      string `json:"attr"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	StorePath string `json:"storePath"`
+	// Outputs maps each output of the package to its store path
+	Outputs map[string]string `json:"outputs"`
+	// OutputHashes maps outputs to the NAR hash of their store path
+	OutputHashes map[string]string `json:"outputHashes,omitempty"`
+	// Nixpkgs is the snapshot the package is evaluated from
+	Nixpkgs Nixpkgs `json:"nixpkgs"`
+}
+
+// New returns an empty lock for a shell configuration file
+func New(configType string) *Lock {
+	return &Lock{
+		Version:    CurrentVersion,
+		ConfigType: configType,
+		Packages:   make(map[string]Package),
+	}
+}
+
+// FromSnapshot builds the lock of an evaluated project shell
+func FromSnapshot(snapshot *utils.ShellSnapshot, channel string) *Lock {
+	lock := New(snapshot.ConfigType)
+	lock.Shell = snapshot.Shell
+	lock.Channel = channel
+	lock.Nixpkgs = Nixpkgs(snapshot.Nixpkgs)
+	for _, pkg := range snapshot.Packages {
+		if pkg.Outputs == nil {
+			pkg.Outputs = make(map[string]string)
+		}
+		lock.Packages[pkg.Attr] = Package{
+			Attr:         pkg.Attr,
+			Name:         pkg.Name,
+			Version:      pkg.Version,
+			StorePath:    pkg.StorePath,
+			Outputs:      pkg.Outputs,
+			OutputHashes: pkg.OutputHashes,
+			Nixpkgs:      Nixpkgs(pkg.Nixpkgs),
+		}
+	}
+	return lock
+}
+
+// PackageList returns the locked packages sorted by attribute path
+func (l *Lock) PackageList() []Package {
+	packages := make([]Package, 0, len(l.Packages))
+	for _, pkg := range l.Packages {
+		packages = append(packages, pkg)
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Attr < packages[j].Attr })
+	return packages
+}
+
+// Validate checks the lock for consistency
+func (l *Lock) Validate() error {
+	if l.Version != CurrentVersion {
+		return fmt.Errorf("unsupported lock file version %q", l.Version)
+	}
+	if l.ConfigType == "" {
+		return fmt.Errorf("lock file has no config_type")
+	}
+	if !slices.Contains(utils.ConfigTypes, l.ConfigType) {
+		return fmt.Errorf("unsupported config_type %q, must be shell.nix, flake.nix or default.nix", l.ConfigType)
+	}
+	for attr, pkg := range l.Packages {
+		if pkg.Attr != attr {
+			return fmt.Errorf("package %q is locked under %q", pkg.Attr, attr)
+		}
+	}
+	return nil
+}
+
+// Encode renders the lock as indented JSON
+func (l *Lock) Encode() ([]byte, error) {
+	if err := l.Validate(); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Write saves the lock to path
+func (l *Lock) Write(path string) error {
+	data, err := l.Encode()
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data, 0600)
+}
+
+// Decode reads a lock file, migrating older schema versions. Unknown fields
+// are reported as errors.
+func Decode(r io.Reader) (*Lock, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = migrate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	lock := &Lock{}
+	if err := decoder.Decode(lock); err != nil {
+		return nil, fmt.Errorf("invalid lock file: %w", err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("invalid lock file: unexpected data after the lock")
+	}
+	if lock.Packages == nil {
+		lock.Packages = make(map[string]Package)
+	}
+	if err := lock.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid lock file: %w", err)
+	}
+	return lock, nil
+}
+
+// Read loads the lock file at path
+func Read(path string) (*Lock, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lock, err := Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return lock, nil
+}
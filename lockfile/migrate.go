@@ -0,0 +1,91 @@
+package lockfile
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// migration upgrades the raw JSON of a lock file by one schema version and
+// returns the version it produced
+type migration func(raw map[string]json.RawMessage) (string, error)
+
+// migrations maps each old schema version to its upgrade step
+var migrations = map[string]migration{
+	"1.0.0": migrateV1,
+}
+
+// migrate upgrades the JSON of a lock file to CurrentVersion
+func migrate(data []byte) ([]byte, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid lock file: %w", err)
+	}
+
+	var version string
+	if err := json.Unmarshal(raw["version"], &version); err != nil || version == "" {
+		return nil, fmt.Errorf("invalid lock file: missing schema version")
+	}
+	if version == CurrentVersion {
+		return data, nil
+	}
+
+	for version != CurrentVersion {
+		step, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("unsupported lock file version %q (this release reads up to %s)", version, CurrentVersion)
+		}
+		next, err := step(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate lock file from version %s: %w", version, err)
+		}
+		version = next
+		raw["version"], _ = json.Marshal(version)
+	}
+	return json.Marshal(raw)
+}
+
+// migrateV1 upgrades 1.0.0 lock files, which recorded the versions of the
+// user profile with a single nixpkgs revision and optional per-package
+// sources
+func migrateV1(raw map[string]json.RawMessage) (string, error) {
+	var v1 struct {
+		Packages map[string]string  `json:"packages"`
+		Sources  map[string]Nixpkgs `json:"sources"`
+		Revision string             `json:"nixpkgs_revision"`
+	}
+	for field, target := range map[string]interface{}{
+		"packages":         &v1.Packages,
+		"sources":          &v1.Sources,
+		"nixpkgs_revision": &v1.Revision,
+	} {
+		if value, ok := raw[field]; ok {
+			if err := json.Unmarshal(value, target); err != nil {
+				return "", fmt.Errorf("%s: %w", field, err)
+			}
+		}
+	}
+
+	nixpkgs := Nixpkgs{Revision: v1.Revision}
+	packages := make(map[string]Package)
+	for attr, version := range v1.Packages {
+		source, ok := v1.Sources[attr]
+		if !ok {
+			source = nixpkgs
+		}
+		packages[attr] = Package{Attr: attr, Version: version, Outputs: map[string]string{}, Nixpkgs: source}
+	}
+
+	var err error
+	if raw["packages"], err = json.Marshal(packages); err != nil {
+		return "", err
+	}
+	if raw["nixpkgs"], err = json.Marshal(nixpkgs); err != nil {
+		return "", err
+	}
+	if _, ok := raw["channel"]; !ok {
+		raw["channel"] = json.RawMessage(`""`)
+	}
+	delete(raw, "sources")
+	delete(raw, "nixpkgs_revision")
+	return "2.0.0", nil
+}
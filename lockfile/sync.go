@@ -0,0 +1,41 @@
+package lockfile
+
+import (
+	"slices"
+
+	"github.com/mdaashir/NSM/utils"
+)
+
+// SyncPackages makes the package lists of a shell declare exactly the locked
+// packages, each from the nixpkgs snapshot it was locked with. Packages that
+// are pinned to another snapshot than the locked one are pinned again.
+func (l *Lock) SyncPackages(editor *utils.NixEditor) error {
+	declared := editor.Packages()
+	pinned := editor.PinnedPackages()
+
+	// Elements that are not plain attribute paths were never locked
+	remove := make(map[string]bool)
+	for _, pkg := range declared {
+		if _, ok := l.Packages[pkg]; !ok && utils.ValidatePackage(pkg) {
+			remove[pkg] = true
+		}
+	}
+	editor.RemovePackages(remove)
+
+	var missing []string
+	for _, pkg := range l.PackageList() {
+		source := utils.NixpkgsSource(pkg.Nixpkgs)
+		ownSnapshot := pkg.Nixpkgs.Revision != l.Nixpkgs.Revision
+		switch {
+		case ownSnapshot && pinned[pkg.Attr].Revision != source.Revision:
+			if err := editor.PinPackage(pkg.Attr, source); err != nil {
+				return err
+			}
+		case !ownSnapshot && pinned[pkg.Attr].Revision != "":
+			editor.UnpinPackage(pkg.Attr)
+		case !ownSnapshot && !slices.Contains(declared, pkg.Attr):
+			missing = append(missing, pkg.Attr)
+		}
+	}
+	return editor.AddPackages(missing)
+}
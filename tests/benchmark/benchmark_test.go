@@ -2,9 +2,13 @@
 package benchmark
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mdaashir/NSM/tests/testutils"
 	"github.com/mdaashir/NSM/utils"
@@ -191,3 +195,85 @@ func BenchmarkLogging(b *testing.B) {
 		})
 	}
 }
+
+// nixLatency stands in for the time a Nix command takes
+const nixLatency = time.Millisecond
+
+// BenchmarkPackageVersionLookups compares one nix-env query per package with
+// a single query reused for every lookup
+func BenchmarkPackageVersionLookups(b *testing.B) {
+	var packages []string
+	var entries []string
+	for i := 0; i < 20; i++ {
+		pkg := fmt.Sprintf("package%02d", i)
+		packages = append(packages, pkg)
+		entries = append(entries, fmt.Sprintf(`"nixpkgs.%s": {"name": "%s-1.0", "version": "1.0"}`, pkg, pkg))
+	}
+	fake := &testutils.FakeRunner{
+		Outputs: map[string]string{"nix-env": "{" + strings.Join(entries, ",") + "}"},
+		Delay:   nixLatency,
+	}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	b.Run("PerPackage", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, pkg := range packages {
+				if _, err := utils.GetPackageVersion(context.Background(), pkg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("Batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			installed, err := utils.QueryProfilePackages(context.Background())
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, pkg := range packages {
+				if _, ok := installed.Version(pkg); !ok {
+					b.Fatalf("%s not found", pkg)
+				}
+			}
+		}
+	})
+}
+
+// BenchmarkNixpkgsHashes compares fetching the hashes of several nixpkgs
+// revisions one after another with fetching them on the worker pool
+func BenchmarkNixpkgsHashes(b *testing.B) {
+	dir := testutils.CreateBenchTempDir(b)
+	defer os.RemoveAll(dir)
+	origXdgConfig := os.Getenv("XDG_CONFIG_HOME")
+	if err := os.Setenv("XDG_CONFIG_HOME", dir); err != nil {
+		b.Fatal(err)
+	}
+	defer os.Setenv("XDG_CONFIG_HOME", origXdgConfig)
+
+	var revisions []string
+	for _, c := range "abcdef01" {
+		revisions = append(revisions, strings.Repeat(string(c), 40))
+	}
+	fake := &testutils.FakeRunner{
+		Outputs: map[string]string{"nix": `{"hash": "sha256-AAAA", "storePath": "/nix/store/aaa-source"}`},
+		Delay:   nixLatency,
+	}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	jobs := utils.NixJobs
+	defer func() { utils.NixJobs = jobs }()
+
+	for _, workers := range []int{1, jobs} {
+		b.Run(fmt.Sprintf("Workers%d", workers), func(b *testing.B) {
+			utils.NixJobs = workers
+			for i := 0; i < b.N; i++ {
+				if hashes := utils.NixpkgsNarHashes(context.Background(), revisions); len(hashes) != len(revisions) {
+					b.Fatalf("got %d hashes, want %d", len(hashes), len(revisions))
+				}
+			}
+		})
+	}
+}
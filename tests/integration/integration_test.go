@@ -102,31 +102,10 @@ func TestPackageManagement(t *testing.T) {
 			t.Errorf("Expected 2 initial packages, got %d", len(initialPkgs))
 		}
 
-		// Mock the nix-env command to return package info
-		mockPath := testutils.CreateMockCmd(t, "nix-env", `{
-			"nixpkgs.gcc": {
-				"name": "gcc-12.3.0",
-				"version": "12.3.0",
-				"system": "x86_64-linux",
-				"outPath": "/nix/store/...-gcc-12.3.0"
-			}
-		}`, 0)
-		defer os.Remove(mockPath)
-
-		// Update PATH to include mock binary
-		oldPath := os.Getenv("PATH")
-		mockDir := filepath.Dir(mockPath)
-		if err := os.Chmod(mockPath, 0755); err != nil {
-			t.Fatal(err)
-		}
-		newPath := mockDir + string(os.PathListSeparator) + oldPath
-		if err := os.Setenv("PATH", newPath); err != nil {
-			t.Fatal(err)
-		}
-		defer os.Setenv("PATH", oldPath)
-
 		// Pin a package version
-		if err := utils.PinPackage(); err != nil {
+		rev := "0123456789abcdef0123456789abcdef01234567"
+		source := utils.NixpkgsSource{Revision: rev, NarHash: "sha256-AAAA"}
+		if err := utils.PinPackage("gcc", "12.3.0", source); err != nil {
 			t.Errorf("Failed to pin package: %v", err)
 		}
 
@@ -139,5 +118,20 @@ func TestPackageManagement(t *testing.T) {
 		if version := cfg.Pins["gcc"]; version != "12.3.0" {
 			t.Errorf("Pin version = %q, want 12.3.0", version)
 		}
+		if got := cfg.PinSources["gcc"]; got != source {
+			t.Errorf("Pin source = %+v, want %+v", got, source)
+		}
+
+		// Unpinning removes the pin again
+		if ok, err := utils.UnpinPackage("gcc"); err != nil || !ok {
+			t.Errorf("UnpinPackage() = %v, %v", ok, err)
+		}
+		cfg, err = utils.LoadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := cfg.Pins["gcc"]; ok {
+			t.Error("gcc is still pinned after UnpinPackage")
+		}
 	})
 }
@@ -2,10 +2,18 @@
 package testutils
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/mdaashir/NSM/utils"
 )
 
 // TestConfig holds configuration for tests
@@ -160,3 +168,74 @@ pkgs.mkShell {
 
 	return config, cleanup
 }
+
+// FakeRunner is an in-memory utils.NixRunner that returns scripted output
+// instead of running Nix, recording every call it receives
+type FakeRunner struct {
+	// Outputs maps a command to the output it returns. A key is a command
+	// name or the start of a command line, such as "nix path-info"; the
+	// longest matching key wins.
+	Outputs map[string]string
+	// Errors maps a command to the error it returns, matched like Outputs
+	Errors map[string]error
+	// Missing lists the commands LookPath does not find
+	Missing map[string]bool
+	// Calls records each invocation as the command name followed by its arguments
+	Calls [][]string
+	// Commands records each invocation with its directory, environment and streams
+	Commands []utils.NixCommand
+	// Delay is added to every call to stand in for the time Nix takes
+	Delay time.Duration
+
+	mu sync.Mutex
+}
+
+// fakeMatch returns the value of the longest key of table that is the
+// command line or starts it
+func fakeMatch[V any](table map[string]V, line string) (V, bool) {
+	var value V
+	best := -1
+	for key, v := range table {
+		if (line == key || strings.HasPrefix(line, key+" ")) && len(key) > best {
+			value, best = v, len(key)
+		}
+	}
+	return value, best >= 0
+}
+
+// Run returns the scripted output for the command. Output is written to
+// cmd.Stdout when it is set.
+func (f *FakeRunner) Run(ctx context.Context, cmd utils.NixCommand) ([]byte, error) {
+	select {
+	case <-time.After(f.Delay):
+	case <-ctx.Done():
+		return nil, &utils.CommandError{Command: cmd.Name, ExitCode: -1, Err: ctx.Err()}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls = append(f.Calls, append([]string{cmd.Name}, cmd.Args...))
+	f.Commands = append(f.Commands, cmd)
+
+	line := cmd.String()
+	if err, ok := fakeMatch(f.Errors, line); ok && err != nil {
+		return nil, err
+	}
+	output, ok := fakeMatch(f.Outputs, line)
+	if !ok {
+		return nil, fmt.Errorf("unexpected command: %s", line)
+	}
+	if cmd.Stdout != nil {
+		_, err := io.WriteString(cmd.Stdout, output)
+		return nil, err
+	}
+	return []byte(output), nil
+}
+
+// LookPath finds every command that is not listed in Missing
+func (f *FakeRunner) LookPath(name string) (string, error) {
+	if f.Missing[name] {
+		return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+	}
+	return "/nix/var/nix/profiles/default/bin/" + name, nil
+}
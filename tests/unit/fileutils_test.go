@@ -112,6 +112,33 @@ func TestBackupFile(t *testing.T) {
 	})
 }
 
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shell.nix")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := utils.WriteFileAtomic(path, []byte("new"), 0600); err != nil {
+		t.Fatalf("WriteFileAtomic() error = %v", err)
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != "new" {
+		t.Errorf("content = %q, %v", content, err)
+	}
+
+	// No temporary file is left behind, even when the write fails
+	if err := utils.WriteFileAtomic(filepath.Join(dir, "missing", "shell.nix"), []byte("new"), 0600); err == nil {
+		t.Error("Expected error when the directory does not exist")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only shell.nix", len(entries))
+	}
+}
+
 func TestGetProjectConfigType(t *testing.T) {
 	dir := testutils.CreateTempDir(t)
 	defer func(path string) {
@@ -0,0 +1,87 @@
+package unit
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/mdaashir/NSM/tests/testutils"
+	"github.com/mdaashir/NSM/utils"
+)
+
+// gitRepo creates a repository with one commit of the given files and
+// changes into it
+func gitRepo(t *testing.T, files map[string]string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := testutils.CreateTempDir(t)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(origDir) })
+
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "init"},
+	} {
+		if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, output)
+		}
+	}
+}
+
+func TestGitShowFile(t *testing.T) {
+	gitRepo(t, map[string]string{"shell.nix": pinShellNix})
+
+	if !utils.IsGitRevision("HEAD") {
+		t.Fatal("HEAD is not a git revision")
+	}
+	for _, ref := range []string{"no-such-branch", "--all", ""} {
+		if utils.IsGitRevision(ref) {
+			t.Errorf("IsGitRevision(%q) = true", ref)
+		}
+	}
+
+	// Later changes in the working tree do not affect the revision
+	if err := os.WriteFile("shell.nix", []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	content, err := utils.GitShowFile("HEAD", "shell.nix")
+	if err != nil || string(content) != pinShellNix {
+		t.Errorf("GitShowFile() = %q, %v", content, err)
+	}
+	if _, err := utils.GitShowFile("HEAD", "nsm.lock.json"); !errors.Is(err, utils.ErrNotInGit) {
+		t.Errorf("GitShowFile() of a missing file error = %v, want ErrNotInGit", err)
+	}
+}
+
+func TestCheckoutShell(t *testing.T) {
+	gitRepo(t, map[string]string{"flake.nix": pinFlakeNix, "flake.lock": snapshotFlakeLock})
+
+	dir := t.TempDir()
+	configFile, err := utils.CheckoutShell("HEAD", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configFile != filepath.Join(dir, "flake.nix") {
+		t.Errorf("CheckoutShell() = %q", configFile)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "flake.lock")); err != nil || string(content) != snapshotFlakeLock {
+		t.Errorf("flake.lock = %q, %v", content, err)
+	}
+}
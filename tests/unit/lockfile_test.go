@@ -0,0 +1,301 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mdaashir/NSM/lockfile"
+	"github.com/mdaashir/NSM/tests/testutils"
+	"github.com/mdaashir/NSM/utils"
+)
+
+// testLock returns a lock with a single package
+func testLock() *lockfile.Lock {
+	lock := lockfile.New("shell.nix")
+	lock.Nixpkgs = lockfile.Nixpkgs{Revision: revA, NarHash: "sha256-AAAA"}
+	lock.Packages["gcc"] = lockfile.Package{
+		Attr:      "gcc",
+		Name:      "gcc-wrapper-13.2.0",
+		Version:   "13.2.0",
+		StorePath: "/nix/store/aaa-gcc-wrapper-13.2.0",
+		Outputs:   map[string]string{"out": "/nix/store/aaa-gcc-wrapper-13.2.0"},
+		Nixpkgs:   lock.Nixpkgs,
+	}
+	return lock
+}
+
+func TestLockRoundTrip(t *testing.T) {
+	dir := testutils.CreateTempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, lockfile.FileName)
+	lock := testLock()
+	if err := lock.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := lockfile.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, lock) {
+		t.Errorf("Read() = %+v, want %+v", read, lock)
+	}
+}
+
+func TestLockDecodeStrict(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "unknown top-level field",
+			input: `{"version": "2.0.0", "config_type": "shell.nix", "channel": "", "nixpkgs": {"revision": ""}, "packages": {}, "extra": 1}`,
+			err:   `unknown field "extra"`,
+		},
+		{
+			name:  "unknown package field",
+			input: `{"version": "2.0.0", "config_type": "shell.nix", "channel": "", "nixpkgs": {"revision": ""}, "packages": {"gcc": {"attr": "gcc", "hash": ""}}}`,
+			err:   `unknown field "hash"`,
+		},
+		{
+			name:  "mismatched attribute",
+			input: `{"version": "2.0.0", "config_type": "shell.nix", "channel": "", "nixpkgs": {"revision": ""}, "packages": {"gcc": {"attr": "go"}}}`,
+			err:   `locked under`,
+		},
+		{
+			name:  "unknown config type",
+			input: `{"version": "2.0.0", "config_type": "Makefile", "channel": "", "nixpkgs": {"revision": ""}, "packages": {}}`,
+			err:   `unsupported config_type "Makefile"`,
+		},
+		{
+			name:  "newer schema",
+			input: `{"version": "3.0.0"}`,
+			err:   `unsupported lock file version "3.0.0"`,
+		},
+		{
+			name:  "missing version",
+			input: `{"packages": {}}`,
+			err:   `missing schema version`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := lockfile.Decode(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Decode() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLockMigrateV1(t *testing.T) {
+	v1 := `{
+  "channel": "nixpkgs https://nixos.org/channels/nixpkgs-unstable",
+  "config_type": "shell.nix",
+  "nixpkgs_revision": "` + revA + `",
+  "packages": {"gcc": "13.2.0", "nodejs": "18.17.1"},
+  "sources": {"nodejs": {"revision": "` + revB + `", "narHash": "sha256-BBBB"}},
+  "version": "1.0.0"
+}`
+
+	lock, err := lockfile.Decode(strings.NewReader(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Version != lockfile.CurrentVersion || lock.Nixpkgs.Revision != revA {
+		t.Errorf("migrated lock = %+v", lock)
+	}
+	if got := lock.Packages["gcc"]; got.Version != "13.2.0" || got.Nixpkgs.Revision != revA {
+		t.Errorf("gcc = %+v", got)
+	}
+	if got := lock.Packages["nodejs"]; got.Nixpkgs != (lockfile.Nixpkgs{Revision: revB, NarHash: "sha256-BBBB"}) {
+		t.Errorf("nodejs = %+v", got)
+	}
+}
+
+func TestLockFromSnapshot(t *testing.T) {
+	snapshot := &utils.ShellSnapshot{
+		ConfigType: "flake.nix",
+		Nixpkgs:    utils.NixpkgsSource{Revision: revA},
+		Packages:   []utils.ShellPackage{{Attr: "jq", Version: "1.7.1", Nixpkgs: utils.NixpkgsSource{Revision: revA}}},
+	}
+	lock := lockfile.FromSnapshot(snapshot, "")
+	if _, err := lock.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	if got := lock.PackageList(); len(got) != 1 || got[0].Attr != "jq" || got[0].Outputs == nil {
+		t.Errorf("PackageList() = %+v", got)
+	}
+}
+
+func TestLockSyncPackages(t *testing.T) {
+	for name, src := range map[string]string{"shell.nix": pinShellNix, "flake.nix": pinFlakeNix} {
+		t.Run(name, func(t *testing.T) {
+			// The file pins nodejs to pinRev, the lock records revB
+			editor, _ := utils.NewNixEditor(src)
+			if err := editor.PinPackage("nodejs", utils.NixpkgsSource{Revision: pinRev}); err != nil {
+				t.Fatal(err)
+			}
+			pinned, err := editor.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lock := testLock()
+			lock.Packages["nodejs"] = lockfile.Package{Attr: "nodejs", Version: "20.11.1", Nixpkgs: lockfile.Nixpkgs{Revision: revB}}
+
+			editor, _ = utils.NewNixEditor(pinned)
+			if err := lock.SyncPackages(editor); err != nil {
+				t.Fatalf("SyncPackages() error = %v", err)
+			}
+			restored, err := editor.Result()
+			if err != nil {
+				t.Fatalf("Result() error = %v", err)
+			}
+			if strings.Contains(restored, utils.PinnedSetName(pinRev)) {
+				t.Errorf("the previous pinned nixpkgs was left behind:\n%s", restored)
+			}
+
+			editor, err = utils.NewNixEditor(restored)
+			if err != nil {
+				t.Fatalf("restored source does not parse: %v", err)
+			}
+			if got := editor.PinnedPackages(); !reflect.DeepEqual(got, map[string]utils.NixpkgsSource{"nodejs": {Revision: revB}}) {
+				t.Errorf("PinnedPackages() = %v, want nodejs pinned to the locked revision", got)
+			}
+			if got := editor.Packages(); !reflect.DeepEqual(got, []string{"gcc", "nodejs"}) {
+				t.Errorf("Packages() = %v", got)
+			}
+		})
+	}
+}
+
+// jsonFields returns the JSON names of the fields of a struct type
+func jsonFields(v interface{}) []string {
+	var fields []string
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestLockSchemaMatchesTypes(t *testing.T) {
+	type object struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	var schema struct {
+		object
+		Defs map[string]object `json:"$defs"`
+	}
+	if err := json.Unmarshal(lockfile.Schema, &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+
+	keys := func(o object) []string {
+		var names []string
+		for name := range o.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	for name, pair := range map[string]struct {
+		schema object
+		typ    interface{}
+	}{
+		"lock":    {schema.object, lockfile.Lock{}},
+		"nixpkgs": {schema.Defs["nixpkgs"], lockfile.Nixpkgs{}},
+		"package": {schema.Defs["package"], lockfile.Package{}},
+	} {
+		if got, want := keys(pair.schema), jsonFields(pair.typ); !reflect.DeepEqual(got, want) {
+			t.Errorf("schema properties of %s = %v, want %v", name, got, want)
+		}
+	}
+
+	var configType struct {
+		Enum []string `json:"enum"`
+	}
+	if err := json.Unmarshal(schema.Properties["config_type"], &configType); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(configType.Enum, utils.ConfigTypes) {
+		t.Errorf("schema config types = %v, want %v", configType.Enum, utils.ConfigTypes)
+	}
+}
+
+func TestLockDefaultNix(t *testing.T) {
+	lock := testLock()
+	lock.ConfigType = "default.nix"
+	content, err := lock.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockfile.Decode(bytes.NewReader(content)); err != nil {
+		t.Errorf("Decode() of a default.nix lock error = %v", err)
+	}
+}
+
+func TestLockDiff(t *testing.T) {
+	old := testLock()
+	old.Packages["nodejs"] = lockfile.Package{Attr: "nodejs", Version: "18.17.1", Nixpkgs: lockfile.Nixpkgs{Revision: revA}}
+	old.Packages["jq"] = lockfile.Package{Attr: "jq", Version: "1.7.1", Nixpkgs: lockfile.Nixpkgs{Revision: revA}}
+
+	current := testLock()
+	current.Nixpkgs = lockfile.Nixpkgs{Revision: revB}
+	current.Packages["gcc"] = lockfile.Package{Attr: "gcc", Version: "14.1.0", Nixpkgs: lockfile.Nixpkgs{Revision: revB}}
+	current.Packages["nodejs"] = lockfile.Package{Attr: "nodejs", Version: "18.17.1", Nixpkgs: lockfile.Nixpkgs{Revision: revB}}
+	current.Packages["go"] = lockfile.Package{Attr: "go", Version: "1.22.1", Nixpkgs: lockfile.Nixpkgs{Revision: revB}}
+
+	drift := lockfile.Diff(old, current)
+	if drift.Empty() {
+		t.Fatal("Diff() found no drift")
+	}
+	if drift.Nixpkgs == nil || drift.Nixpkgs.Old.Revision != revA || drift.Nixpkgs.New.Revision != revB {
+		t.Errorf("Nixpkgs change = %+v", drift.Nixpkgs)
+	}
+
+	expected := []lockfile.Change{
+		{Attr: "gcc", Kind: lockfile.VersionChanged, OldVersion: "13.2.0", NewVersion: "14.1.0", OldRevision: revA, NewRevision: revB},
+		{Attr: "go", Kind: lockfile.Added, NewVersion: "1.22.1", NewRevision: revB},
+		{Attr: "jq", Kind: lockfile.Removed, OldVersion: "1.7.1", OldRevision: revA},
+		{Attr: "nodejs", Kind: lockfile.RevisionChanged, OldVersion: "18.17.1", NewVersion: "18.17.1", OldRevision: revA, NewRevision: revB},
+	}
+	if !reflect.DeepEqual(drift.Changes, expected) {
+		t.Errorf("Changes = %+v, want %+v", drift.Changes, expected)
+	}
+
+	if !lockfile.Diff(old, old).Empty() {
+		t.Error("Diff() of a lock with itself is not empty")
+	}
+}
+
+func TestChangeMajor(t *testing.T) {
+	tests := []struct {
+		change   lockfile.Change
+		expected bool
+	}{
+		{lockfile.Change{Kind: lockfile.VersionChanged, OldVersion: "18.17.1", NewVersion: "20.11.0"}, true},
+		{lockfile.Change{Kind: lockfile.VersionChanged, OldVersion: "13.2.0", NewVersion: "13.3.0"}, false},
+		{lockfile.Change{Kind: lockfile.VersionChanged, OldVersion: "v1.2", NewVersion: "2.0"}, true},
+		{lockfile.Change{Kind: lockfile.VersionChanged, OldVersion: "unstable-2024-01-01", NewVersion: "unstable-2024-02-01"}, false},
+		{lockfile.Change{Kind: lockfile.Added, NewVersion: "1.22.1"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.change.Major(); got != tt.expected {
+			t.Errorf("%+v.Major() = %v, want %v", tt.change, got, tt.expected)
+		}
+	}
+}
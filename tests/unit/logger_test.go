@@ -2,6 +2,8 @@ package unit
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"io"
 	"os"
 	"strings"
@@ -223,25 +225,49 @@ func TestNoColorOutput(t *testing.T) {
 		}
 	}()
 
+	defer utils.SetColorMode(utils.ColorAuto)
+
 	tests := []struct {
-		name     string
-		setEnv   map[string]string
-		wantANSI bool
+		name      string
+		setEnv    map[string]string
+		colorMode utils.ColorMode
+		wantANSI  bool
 	}{
 		{
-			name:     "normal output",
-			setEnv:   map[string]string{"NO_COLOR": "", "TERM": "xterm"},
-			wantANSI: true,
+			name:      "color always",
+			setEnv:    map[string]string{"NO_COLOR": "", "TERM": "xterm"},
+			colorMode: utils.ColorAlways,
+			wantANSI:  true,
+		},
+		{
+			name:      "color always overrides NO_COLOR",
+			setEnv:    map[string]string{"NO_COLOR": "1", "TERM": "xterm"},
+			colorMode: utils.ColorAlways,
+			wantANSI:  true,
 		},
 		{
-			name:     "NO_COLOR set",
-			setEnv:   map[string]string{"NO_COLOR": "1", "TERM": "xterm"},
-			wantANSI: false,
+			name:      "output to a pipe",
+			setEnv:    map[string]string{"NO_COLOR": "", "TERM": "xterm"},
+			colorMode: utils.ColorAuto,
+			wantANSI:  false,
 		},
 		{
-			name:     "dumb terminal",
-			setEnv:   map[string]string{"NO_COLOR": "", "TERM": "dumb"},
-			wantANSI: false,
+			name:      "NO_COLOR set",
+			setEnv:    map[string]string{"NO_COLOR": "1", "TERM": "xterm"},
+			colorMode: utils.ColorAuto,
+			wantANSI:  false,
+		},
+		{
+			name:      "dumb terminal",
+			setEnv:    map[string]string{"NO_COLOR": "", "TERM": "dumb"},
+			colorMode: utils.ColorAuto,
+			wantANSI:  false,
+		},
+		{
+			name:      "color never",
+			setEnv:    map[string]string{"NO_COLOR": "", "TERM": "xterm"},
+			colorMode: utils.ColorNever,
+			wantANSI:  false,
 		},
 	}
 
@@ -254,6 +280,7 @@ func TestNoColorOutput(t *testing.T) {
 					return
 				}
 			}
+			utils.SetColorMode(tt.colorMode)
 
 			stdout, _ := captureOutput(func() {
 				utils.Success("test message")
@@ -266,3 +293,91 @@ func TestNoColorOutput(t *testing.T) {
 		})
 	}
 }
+
+func TestParseLogFormat(t *testing.T) {
+	for _, value := range []string{"text", "json", "JSON"} {
+		if _, err := utils.ParseLogFormat(value); err != nil {
+			t.Errorf("ParseLogFormat(%q) error = %v", value, err)
+		}
+	}
+	if _, err := utils.ParseLogFormat("xml"); err == nil {
+		t.Error("ParseLogFormat(\"xml\") succeeded, want error")
+	}
+}
+
+func TestLoggerFields(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	utils.ConfigureLogger(false, false)
+
+	stdout, _ := captureOutput(func() {
+		utils.With("package", "gcc", "reason", "not found").Warn("skipping package")
+	})
+
+	want := "⚠️ skipping package package=gcc reason=\"not found\"\n"
+	if stdout != want {
+		t.Errorf("output = %q, want %q", stdout, want)
+	}
+}
+
+func TestLoggerJSONFormat(t *testing.T) {
+	utils.ConfigureLogger(false, false)
+	utils.SetLogFormat(utils.LogFormatJSON)
+	defer utils.SetLogFormat(utils.LogFormatText)
+
+	logger := utils.With("command", "nix-env")
+	_, stderr := captureOutput(func() {
+		logger.With("exit_code", 1, "err", errors.New("boom")).Error("command %s", "failed")
+	})
+
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(stderr), &record); err != nil {
+		t.Fatalf("output %q is not a JSON record: %v", stderr, err)
+	}
+	want := map[string]interface{}{
+		"level":     "error",
+		"msg":       "command failed",
+		"command":   "nix-env",
+		"exit_code": float64(1),
+		"err":       "boom",
+	}
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("record[%q] = %v, want %v", key, record[key], value)
+		}
+	}
+	if _, ok := record["time"]; !ok {
+		t.Error("record has no time")
+	}
+}
+
+func TestLogFile(t *testing.T) {
+	utils.ConfigureLogger(false, true)
+	defer utils.ConfigureLogger(false, false)
+
+	var file bytes.Buffer
+	utils.SetLogFile(&file)
+	defer utils.SetLogFile(nil)
+
+	stdout, stderr := captureOutput(func() {
+		utils.Debug("debug message")
+		utils.With("package", "gcc").Info("info message")
+	})
+
+	if stdout != "" || stderr != "" {
+		t.Errorf("console output = %q, %q, want none in quiet mode", stdout, stderr)
+	}
+
+	lines := strings.Split(strings.TrimSpace(file.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("log file has %d lines, want 2: %q", len(lines), file.String())
+	}
+	if !strings.Contains(lines[0], "DEBUG") || !strings.Contains(lines[0], "debug message") {
+		t.Errorf("debug line = %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "INFO") || !strings.HasSuffix(lines[1], "info message package=gcc") {
+		t.Errorf("info line = %q", lines[1])
+	}
+	if strings.Contains(file.String(), "\033[") {
+		t.Error("log file contains ANSI color codes")
+	}
+}
@@ -0,0 +1,311 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mdaashir/NSM/utils"
+)
+
+func TestNixEditorAddPackages(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		add      []string
+		expected string
+	}{
+		{
+			name: "multi-line list keeps indentation and comments",
+			src: `{ pkgs ? import <nixpkgs> {} }:
+pkgs.mkShell {
+  packages = with pkgs; [
+      gcc # compiler
+    # python3
+  ];
+}`,
+			add: []string{"go", "nodejs"},
+			expected: `{ pkgs ? import <nixpkgs> {} }:
+pkgs.mkShell {
+  packages = with pkgs; [
+      gcc # compiler
+      go
+      nodejs
+    # python3
+  ];
+}`,
+		},
+		{
+			name:     "single-line list grows inline",
+			src:      `pkgs.mkShell { buildInputs = [ gcc python3 ]; }`,
+			add:      []string{"go"},
+			expected: `pkgs.mkShell { buildInputs = [ gcc python3 go ]; }`,
+		},
+		{
+			name:     "empty single-line list",
+			src:      `pkgs.mkShell { packages = []; }`,
+			add:      []string{"go"},
+			expected: `pkgs.mkShell { packages = [ go ]; }`,
+		},
+		{
+			name: "empty multi-line list",
+			src: `pkgs.mkShell {
+  packages = with pkgs; [
+  ];
+}`,
+			add: []string{"go"},
+			expected: `pkgs.mkShell {
+  packages = with pkgs; [
+    go
+  ];
+}`,
+		},
+		{
+			name: "flake targets mkShell instead of other lists",
+			src: `{
+  inputs.systems = [ "x86_64-linux" ];
+  outputs = { self, nixpkgs }: {
+    devShell.x86_64-linux = nixpkgs.legacyPackages.x86_64-linux.mkShell {
+      shellHook = builtins.concatStringsSep "\n" [ "echo hi" ];
+      nativeBuildInputs = [ pkgs.cmake ];
+      buildInputs = [
+        pkgs.gcc
+      ];
+    };
+  };
+}`,
+			add: []string{"go"},
+			expected: `{
+  inputs.systems = [ "x86_64-linux" ];
+  outputs = { self, nixpkgs }: {
+    devShell.x86_64-linux = nixpkgs.legacyPackages.x86_64-linux.mkShell {
+      shellHook = builtins.concatStringsSep "\n" [ "echo hi" ];
+      nativeBuildInputs = [ pkgs.cmake ];
+      buildInputs = [
+        pkgs.gcc
+        pkgs.go
+      ];
+    };
+  };
+}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editor, err := utils.NewNixEditor(tt.src)
+			if err != nil {
+				t.Fatalf("NewNixEditor() error = %v", err)
+			}
+			if err := editor.AddPackages(tt.add); err != nil {
+				t.Fatalf("AddPackages() error = %v", err)
+			}
+			got, err := editor.Result()
+			if err != nil {
+				t.Fatalf("Result() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("AddPackages() result:\n%s\nwant:\n%s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNixEditorRemovePackages(t *testing.T) {
+	src := `{ pkgs ? import <nixpkgs> {} }:
+pkgs.mkShell {
+  packages = with pkgs; [
+    gcc # compiler
+    python3
+    nodejs
+  ];
+  nativeBuildInputs = [ pkgs.cmake pkgs.ninja ];
+}`
+	expected := `{ pkgs ? import <nixpkgs> {} }:
+pkgs.mkShell {
+  packages = with pkgs; [
+    python3
+  ];
+  nativeBuildInputs = [ pkgs.ninja ];
+}`
+
+	editor, err := utils.NewNixEditor(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed := editor.RemovePackages(map[string]bool{"gcc": true, "nodejs": true, "cmake": true, "missing": true})
+	if removed != 3 {
+		t.Errorf("RemovePackages() removed %d, want 3", removed)
+	}
+
+	got, err := editor.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != expected {
+		t.Errorf("RemovePackages() result:\n%s\nwant:\n%s", got, expected)
+	}
+}
+
+func TestNixEditorRemoveAndAdd(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		remove   string
+		expected string
+	}{
+		{
+			name: "last element of a multi-line list",
+			src: `pkgs.mkShell {
+  packages = with pkgs; [
+    gcc
+    nodejs # runtime
+  ];
+}`,
+			remove: "nodejs",
+			expected: `pkgs.mkShell {
+  packages = with pkgs; [
+    gcc
+    go
+  ];
+}`,
+		},
+		{
+			name: "only element of a multi-line list",
+			src: `pkgs.mkShell {
+  packages = with pkgs; [
+    nodejs
+  ];
+}`,
+			remove: "nodejs",
+			expected: `pkgs.mkShell {
+  packages = with pkgs; [
+    go
+  ];
+}`,
+		},
+		{
+			name:     "last element of a single-line list",
+			src:      `pkgs.mkShell { packages = [ gcc nodejs ]; }`,
+			remove:   "nodejs",
+			expected: `pkgs.mkShell { packages = [ gcc go ]; }`,
+		},
+		{
+			name:     "only element of a single-line list",
+			src:      `pkgs.mkShell { packages = [ nodejs ]; }`,
+			remove:   "nodejs",
+			expected: `pkgs.mkShell { packages = [ go ]; }`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editor, err := utils.NewNixEditor(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			editor.RemovePackages(map[string]bool{tt.remove: true})
+			if err := editor.AddPackages([]string{"go"}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := editor.Result()
+			if err != nil {
+				t.Fatalf("Result() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Result():\n%s\nwant:\n%s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNixEditorCRLF(t *testing.T) {
+	crlf := func(s string) string { return strings.ReplaceAll(s, "\n", "\r\n") }
+
+	tests := []struct {
+		name     string
+		src      string
+		edit     func(*utils.NixEditor) error
+		expected string
+	}{
+		{
+			name: "add after the last element",
+			src: `pkgs.mkShell {
+  packages = with pkgs; [
+    gcc # compiler
+  ];
+}
+`,
+			edit: func(e *utils.NixEditor) error { return e.AddPackages([]string{"go", "jq"}) },
+			expected: `pkgs.mkShell {
+  packages = with pkgs; [
+    gcc # compiler
+    go
+    jq
+  ];
+}
+`,
+		},
+		{
+			name: "add in place of the removed elements",
+			src: `pkgs.mkShell {
+  packages = with pkgs; [
+    nodejs
+  ];
+}
+`,
+			edit: func(e *utils.NixEditor) error {
+				e.RemovePackages(map[string]bool{"nodejs": true})
+				return e.AddPackages([]string{"go"})
+			},
+			expected: `pkgs.mkShell {
+  packages = with pkgs; [
+    go
+  ];
+}
+`,
+		},
+		{
+			name: "pin in shell.nix",
+			src:  pinShellNix,
+			edit: func(e *utils.NixEditor) error { return e.PinPackage("nodejs", utils.NixpkgsSource{Revision: pinRev}) },
+		},
+		{
+			name: "pin in flake.nix",
+			src:  pinFlakeNix,
+			edit: func(e *utils.NixEditor) error { return e.PinPackage("nodejs", utils.NixpkgsSource{Revision: pinRev}) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editor, err := utils.NewNixEditor(crlf(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.edit(editor); err != nil {
+				t.Fatal(err)
+			}
+			got, err := editor.Result()
+			if err != nil {
+				t.Fatalf("Result() error = %v", err)
+			}
+			if lines := strings.Count(got, "\n"); strings.Count(got, "\r\n") != lines {
+				t.Errorf("Result() mixes line endings: %q", got)
+			}
+			if tt.expected != "" && got != crlf(tt.expected) {
+				t.Errorf("Result() = %q, want %q", got, crlf(tt.expected))
+			}
+		})
+	}
+}
+
+func TestApplyNixEditsOverlap(t *testing.T) {
+	_, err := utils.ApplyNixEdits("abcdef", []utils.NixEdit{
+		{Start: 1, End: 4, Text: "x"},
+		{Start: 3, End: 5, Text: "y"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "overlapping") {
+		t.Errorf("ApplyNixEdits() error = %v, want overlapping edit error", err)
+	}
+}
@@ -0,0 +1,108 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdaashir/NSM/tests/testutils"
+	"github.com/mdaashir/NSM/utils"
+)
+
+func TestFindMissingPackages(t *testing.T) {
+	t.Run("shell.nix uses nix-instantiate", func(t *testing.T) {
+		fake := &testutils.FakeRunner{Outputs: map[string]string{"nix-instantiate": `["pyhton3"]`}}
+		previous := utils.SetNixRunner(fake)
+		defer utils.SetNixRunner(previous)
+
+		missing, err := utils.FindMissingPackages(context.Background(), "shell.nix", []string{"gcc", "pyhton3"})
+		if err != nil {
+			t.Fatalf("FindMissingPackages() error = %v", err)
+		}
+		if !reflect.DeepEqual(missing, []string{"pyhton3"}) {
+			t.Errorf("FindMissingPackages() = %v, want [pyhton3]", missing)
+		}
+
+		if len(fake.Calls) != 1 {
+			t.Fatalf("got %d nix calls, want 1", len(fake.Calls))
+		}
+		call := strings.Join(fake.Calls[0], " ")
+		if !strings.Contains(call, "--eval") || !strings.Contains(call, `[ "gcc" "pyhton3" ]`) {
+			t.Errorf("unexpected evaluation call: %s", call)
+		}
+	})
+
+	t.Run("flake.nix uses nix eval", func(t *testing.T) {
+		fake := &testutils.FakeRunner{Outputs: map[string]string{"nix": `[]`}}
+		previous := utils.SetNixRunner(fake)
+		defer utils.SetNixRunner(previous)
+
+		missing, err := utils.FindMissingPackages(context.Background(), "flake.nix", []string{"python3Packages.numpy"})
+		if err != nil {
+			t.Fatalf("FindMissingPackages() error = %v", err)
+		}
+		if len(missing) != 0 {
+			t.Errorf("FindMissingPackages() = %v, want none", missing)
+		}
+		if fake.Calls[0][1] != "eval" || !strings.Contains(strings.Join(fake.Calls[0], " "), "builtins.getFlake") {
+			t.Errorf("unexpected evaluation call: %v", fake.Calls[0])
+		}
+	})
+
+	t.Run("evaluation failure", func(t *testing.T) {
+		fake := &testutils.FakeRunner{Errors: map[string]error{"nix-instantiate": errors.New("no nixpkgs")}}
+		previous := utils.SetNixRunner(fake)
+		defer utils.SetNixRunner(previous)
+
+		if _, err := utils.FindMissingPackages(context.Background(), "shell.nix", []string{"gcc"}); err == nil {
+			t.Error("Expected error when evaluation fails")
+		}
+	})
+}
+
+func TestQuoteNixString(t *testing.T) {
+	tests := map[string]string{
+		"gcc":        `"gcc"`,
+		`a"b`:        `"a\"b"`,
+		"${x}":       `"\${x}"`,
+		`back\slash`: `"back\\slash"`,
+	}
+	for in, want := range tests {
+		if got := utils.QuoteNixString(in); got != want {
+			t.Errorf("QuoteNixString(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestProjectNixpkgsExprShell(t *testing.T) {
+	dir := testutils.CreateTempDir(t)
+	defer os.RemoveAll(dir)
+	shellNix := filepath.Join(dir, "shell.nix")
+
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"{ pkgs ? import <nixpkgs> {} }: pkgs.mkShell {}", "import <nixpkgs> {}"},
+		{`{ pkgs ? import (fetchTarball "https://example.org/nixpkgs.tar.gz") {} }: pkgs.mkShell {}`, `import (fetchTarball "https://example.org/nixpkgs.tar.gz") {}`},
+		{"with import <nixpkgs> { config.allowUnfree = true; }; mkShell {}", "import <nixpkgs> { config.allowUnfree = true; }"},
+		{"{ pkgs }: pkgs.mkShell {}", "import <nixpkgs> {}"},
+	}
+
+	for _, tt := range tests {
+		if err := os.WriteFile(shellNix, []byte(tt.src), 0600); err != nil {
+			t.Fatal(err)
+		}
+		expr, err := utils.ProjectNixpkgsExpr(shellNix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expr != tt.expected {
+			t.Errorf("ProjectNixpkgsExpr(%q) = %q, want %q", tt.src, expr, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package unit
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mdaashir/NSM/utils"
+)
+
+func TestParseNix(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		wantKind utils.NixNodeKind
+	}{
+		{"lambda with formals", "{ pkgs ? import <nixpkgs> {} }: pkgs.mkShell {}", utils.NixLambda},
+		{"simple lambda", "x: x", utils.NixLambda},
+		{"attribute set", `{ a = 1; b.c = "x"; inherit (pkgs) gcc; }`, utils.NixAttrSet},
+		{"let expression", "let a = 1; in a", utils.NixLet},
+		{"with expression", "with pkgs; [ gcc ]", utils.NixWith},
+		{"conditional", "if a then b else c", utils.NixIf},
+		{"update operator", "a // { b = 1; }", utils.NixBinaryOp},
+		{"select with default", "pkgs.foo or null", utils.NixSelect},
+		{"interpolated string", `"${pkgs.hello}/bin"`, utils.NixString},
+		{"indented string", "''\n  echo ''${HOME} '''\n''", utils.NixString},
+		{"application", "import ./shell.nix { }", utils.NixApply},
+		{"interpolated path", "./hosts/${name}.nix", utils.NixPath},
+		{"interpolated home path", "~/${dir}/${file}", utils.NixPath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := utils.ParseNix(tt.src)
+			if err != nil {
+				t.Fatalf("ParseNix() error = %v", err)
+			}
+			if root.Kind != tt.wantKind {
+				t.Errorf("root kind = %s, want %s", root.Kind, tt.wantKind)
+			}
+			if root.Start != 0 || root.End != len(tt.src) {
+				t.Errorf("root span = [%d, %d), want [0, %d)", root.Start, root.End, len(tt.src))
+			}
+		})
+	}
+}
+
+func TestParseNixInterpolatedPath(t *testing.T) {
+	src := `{ pkgs ? import ./nix/${channel}/default.nix {} }:
+pkgs.mkShell {
+  packages = [ pkgs.gcc ];
+  shellHook = "source ${./scripts/${name}.sh}";
+}`
+	root, err := utils.ParseNix(src)
+	if err != nil {
+		t.Fatalf("ParseNix() error = %v", err)
+	}
+
+	var paths []string
+	utils.WalkNix(root, func(n *utils.NixNode) bool {
+		if n.Kind == utils.NixPath {
+			if len(n.Children) != 1 || n.Children[0].Kind != utils.NixInterpolation {
+				t.Errorf("path %s has children %v, want one interpolation", n.Value, n.Children)
+			}
+			paths = append(paths, n.Value)
+		}
+		return true
+	})
+	expected := []string{"./nix/${channel}/default.nix", "./scripts/${name}.sh"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("paths = %q, want %q", paths, expected)
+	}
+
+	if packages := utils.ExtractShellNixPackages(src); !reflect.DeepEqual(packages, []string{"gcc"}) {
+		t.Errorf("ExtractShellNixPackages() = %q, want [gcc]", packages)
+	}
+}
+
+func TestParseNixErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		wantLine int
+		wantCol  int
+	}{
+		{"missing semicolon", "{\n  a = 1\n}", 3, 1},
+		{"unterminated list", "[ gcc", 1, 6},
+		{"unterminated string", `{ a = "x; }`, 1, 7},
+		{"unterminated comment", "/* comment", 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := utils.ParseNix(tt.src)
+			perr, ok := err.(*utils.NixParseError)
+			if !ok {
+				t.Fatalf("ParseNix() error = %v, want *NixParseError", err)
+			}
+			if perr.Line != tt.wantLine || perr.Column != tt.wantCol {
+				t.Errorf("error position = %d:%d, want %d:%d", perr.Line, perr.Column, tt.wantLine, tt.wantCol)
+			}
+		})
+	}
+}
+
+func TestExtractNixPackages(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected []string
+	}{
+		{
+			name: "comments and multi-line list",
+			src: `{ pkgs ? import <nixpkgs> {} }:
+pkgs.mkShell {
+  packages = with pkgs; [
+    gcc # the compiler
+    # python3
+    /* inline */ nodejs
+  ];
+}`,
+			expected: []string{"gcc", "nodejs"},
+		},
+		{
+			name: "pkgs prefixed entries and nativeBuildInputs",
+			src: `{ pkgs ? import <nixpkgs> {} }:
+pkgs.mkShell {
+  buildInputs = [ pkgs.gcc pkgs.python3Packages.numpy ];
+  nativeBuildInputs = [
+    pkgs.cmake
+  ] ++ (with pkgs; [ ninja ]);
+}`,
+			expected: []string{"gcc", "python3Packages.numpy", "cmake", "ninja"},
+		},
+		{
+			name: "flake with system interpolation",
+			src: `{
+  outputs = { self, nixpkgs, flake-utils }:
+    flake-utils.lib.eachDefaultSystem (system: {
+      devShell = nixpkgs.legacyPackages.${system}.mkShell {
+        buildInputs = with nixpkgs.legacyPackages.${system}; [
+          go
+          gopls
+        ];
+        shellHook = ''
+          echo "ready ${"]"}"
+        '';
+      };
+    });
+}`,
+			expected: []string{"go", "gopls"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packages, err := utils.ExtractNixPackages(tt.src)
+			if err != nil {
+				t.Fatalf("ExtractNixPackages() error = %v", err)
+			}
+			if !reflect.DeepEqual(packages, tt.expected) {
+				t.Errorf("ExtractNixPackages() = %v, want %v", packages, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFindPackageListsPositions(t *testing.T) {
+	src := "{ pkgs }: pkgs.mkShell { packages = with pkgs; [ gcc ]; }"
+	root, err := utils.ParseNix(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lists := utils.FindPackageLists(src, root)
+	if len(lists) != 1 {
+		t.Fatalf("got %d package lists, want 1", len(lists))
+	}
+
+	list := lists[0]
+	if list.Attr != "packages" || list.Scope != "pkgs" {
+		t.Errorf("list attr/scope = %q/%q, want packages/pkgs", list.Attr, list.Scope)
+	}
+	if got := list.List.Text(src); got != "[ gcc ]" {
+		t.Errorf("list text = %q, want %q", got, "[ gcc ]")
+	}
+}
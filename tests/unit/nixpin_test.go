@@ -0,0 +1,429 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdaashir/NSM/tests/testutils"
+	"github.com/mdaashir/NSM/utils"
+)
+
+const pinRev = "0123456789abcdef0123456789abcdef01234567"
+
+const pinShellNix = `{ pkgs ? import <nixpkgs> {} }:
+
+pkgs.mkShell {
+  packages = with pkgs; [
+    gcc
+    nodejs # runtime
+  ];
+}
+`
+
+const pinFlakeNix = `{
+  description = "Development environment";
+
+  inputs = {
+    nixpkgs.url = "github:nixos/nixpkgs/nixos-unstable";
+    flake-utils.url = "github:numtide/flake-utils";
+  };
+
+  outputs = { self, nixpkgs, flake-utils }:
+    flake-utils.lib.eachDefaultSystem (system: {
+      devShell = nixpkgs.legacyPackages.${system}.mkShell {
+        buildInputs = with nixpkgs.legacyPackages.${system}; [
+          gcc
+          nodejs
+        ];
+      };
+    });
+}
+`
+
+func TestNixEditorPinPackage(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		contains []string
+	}{
+		{
+			name: "shell.nix binds the revision in a let block",
+			src:  pinShellNix,
+			contains: []string{
+				"let\n  nixpkgs-0123456 = import (fetchTarball \"https://github.com/NixOS/nixpkgs/archive/" + pinRev + ".tar.gz\") {};\nin\npkgs.mkShell {",
+				"    nixpkgs-0123456.nodejs # runtime\n",
+			},
+		},
+		{
+			name: "flake.nix adds an input",
+			src:  pinFlakeNix,
+			contains: []string{
+				"    nixpkgs-0123456.url = \"github:NixOS/nixpkgs/" + pinRev + "\";\n  };",
+				"outputs = { self, nixpkgs, flake-utils, nixpkgs-0123456 }:",
+				"          nixpkgs-0123456.legacyPackages.${system}.nodejs\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editor, err := utils.NewNixEditor(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := editor.PinPackage("nodejs", utils.NixpkgsSource{Revision: pinRev}); err != nil {
+				t.Fatalf("PinPackage() error = %v", err)
+			}
+			pinned, err := editor.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(pinned, want) {
+					t.Errorf("pinned source is missing %q:\n%s", want, pinned)
+				}
+			}
+
+			// The pinned source still lists the same packages
+			editor, err = utils.NewNixEditor(pinned)
+			if err != nil {
+				t.Fatalf("pinned source does not parse: %v", err)
+			}
+			if got := editor.Packages(); !reflect.DeepEqual(got, []string{"gcc", "nodejs"}) {
+				t.Errorf("Packages() = %v", got)
+			}
+			if got := editor.PinnedPackages(); !reflect.DeepEqual(got, map[string]utils.NixpkgsSource{"nodejs": {Revision: pinRev}}) {
+				t.Errorf("PinnedPackages() = %v", got)
+			}
+
+			// Unpinning restores the original source
+			if !editor.UnpinPackage("nodejs") {
+				t.Fatal("UnpinPackage() found no pinned package")
+			}
+			restored, err := editor.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored != tt.src {
+				t.Errorf("unpinned source differs from the original:\n%s", restored)
+			}
+		})
+	}
+}
+
+func TestNixEditorPinPackageLetPkgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		element string
+	}{
+		{
+			name: "pkgs bound per system",
+			src: `{
+  inputs.nixpkgs.url = "github:nixos/nixpkgs/nixos-unstable";
+  inputs.flake-utils.url = "github:numtide/flake-utils";
+
+  outputs = { self, nixpkgs, flake-utils }:
+    flake-utils.lib.eachDefaultSystem (system:
+      let
+        pkgs = nixpkgs.legacyPackages.${system};
+      in {
+        devShells.default = pkgs.mkShell {
+          packages = with pkgs; [ go nodejs ];
+        };
+      });
+}
+`,
+			element: "nixpkgs-0123456.legacyPackages.${system}.nodejs",
+		},
+		{
+			name: "pkgs bound for one system",
+			src: `{
+  inputs.nixpkgs.url = "github:nixos/nixpkgs/nixos-unstable";
+
+  outputs = { self, nixpkgs }:
+    let pkgs = nixpkgs.legacyPackages.x86_64-linux; in {
+      devShells.x86_64-linux.default = pkgs.mkShell {
+        packages = [ pkgs.go pkgs.nodejs ];
+      };
+    };
+}
+`,
+			element: "nixpkgs-0123456.legacyPackages.x86_64-linux.nodejs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editor, _ := utils.NewNixEditor(tt.src)
+			if err := editor.PinPackage("nodejs", utils.NixpkgsSource{Revision: pinRev}); err != nil {
+				t.Fatalf("PinPackage() error = %v", err)
+			}
+			pinned, err := editor.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(pinned, tt.element) {
+				t.Errorf("pinned source is missing %q:\n%s", tt.element, pinned)
+			}
+
+			editor, err = utils.NewNixEditor(pinned)
+			if err != nil {
+				t.Fatalf("pinned source does not parse: %v", err)
+			}
+			if got := editor.PinnedPackages(); !reflect.DeepEqual(got, map[string]utils.NixpkgsSource{"nodejs": {Revision: pinRev}}) {
+				t.Errorf("PinnedPackages() = %v", got)
+			}
+			editor.UnpinPackage("nodejs")
+			if restored, _ := editor.Result(); restored != tt.src {
+				t.Errorf("unpinned source differs from the original:\n%s", restored)
+			}
+		})
+	}
+
+	// A package set imported from the input cannot be pinned
+	imported := `{
+  inputs.nixpkgs.url = "github:nixos/nixpkgs/nixos-unstable";
+
+  outputs = { self, nixpkgs }:
+    let pkgs = import nixpkgs { system = "x86_64-linux"; }; in {
+      devShells.x86_64-linux.default = pkgs.mkShell {
+        packages = with pkgs; [ nodejs ];
+      };
+    };
+}
+`
+	editor, _ := utils.NewNixEditor(imported)
+	err := editor.PinPackage("nodejs", utils.NixpkgsSource{Revision: pinRev})
+	if err == nil || !strings.Contains(err.Error(), "cannot tell which package set") {
+		t.Errorf("PinPackage() with an imported package set error = %v", err)
+	}
+}
+
+func TestNixEditorRepinPackage(t *testing.T) {
+	const otherRev = "89abcdef0123456789abcdef0123456789abcdef"
+
+	for name, src := range map[string]string{"shell.nix": pinShellNix, "flake.nix": pinFlakeNix} {
+		t.Run(name, func(t *testing.T) {
+			editor, _ := utils.NewNixEditor(src)
+			if err := editor.PinPackage("nodejs", utils.NixpkgsSource{Revision: pinRev}); err != nil {
+				t.Fatal(err)
+			}
+			pinned, err := editor.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			editor, _ = utils.NewNixEditor(pinned)
+			if err := editor.PinPackage("nodejs", utils.NixpkgsSource{Revision: otherRev}); err != nil {
+				t.Fatalf("PinPackage() error = %v", err)
+			}
+			repinned, err := editor.Result()
+			if err != nil {
+				t.Fatalf("Result() error = %v", err)
+			}
+			if strings.Contains(repinned, "nixpkgs-0123456") {
+				t.Errorf("the previous pinned nixpkgs was left behind:\n%s", repinned)
+			}
+			if got := strings.Count(repinned, "nixpkgs-89abcde ="); got+strings.Count(repinned, "nixpkgs-89abcde.url =") != 1 {
+				t.Errorf("want one binding of nixpkgs-89abcde:\n%s", repinned)
+			}
+
+			editor, err = utils.NewNixEditor(repinned)
+			if err != nil {
+				t.Fatalf("re-pinned source does not parse: %v", err)
+			}
+			if got := editor.PinnedPackages(); !reflect.DeepEqual(got, map[string]utils.NixpkgsSource{"nodejs": {Revision: otherRev}}) {
+				t.Errorf("PinnedPackages() = %v", got)
+			}
+			if !editor.UnpinPackage("nodejs") {
+				t.Fatal("UnpinPackage() found no pinned package")
+			}
+			if restored, _ := editor.Result(); restored != src {
+				t.Errorf("unpinned source differs from the original:\n%s", restored)
+			}
+		})
+	}
+}
+
+func TestNixEditorRemovePinnedPackage(t *testing.T) {
+	editor, _ := utils.NewNixEditor(pinShellNix)
+	if err := editor.PinPackage("nodejs", utils.NixpkgsSource{Revision: pinRev}); err != nil {
+		t.Fatal(err)
+	}
+	pinned, _ := editor.Result()
+
+	editor, _ = utils.NewNixEditor(pinned)
+	if removed := editor.RemovePackages(map[string]bool{"nodejs": true}); removed != 1 {
+		t.Fatalf("RemovePackages() = %d, want 1", removed)
+	}
+	result, err := editor.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(result, "nixpkgs-0123456") {
+		t.Errorf("unused pinned nixpkgs was left behind:\n%s", result)
+	}
+}
+
+func TestResolvePinRevision(t *testing.T) {
+	fake := &testutils.FakeRunner{Outputs: map[string]string{
+		"nix-instantiate": `{"revision": "` + pinRev + `", "packages": {"nodejs": {"name": "nodejs-20.11.1", "version": "20.11.1"}}}`,
+	}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	for _, version := range []string{"20.11.1", "20.11", "v20"} {
+		if rev, err := utils.ResolvePinRevision(context.Background(), "nodejs", version); err != nil || rev != pinRev {
+			t.Errorf("ResolvePinRevision(nodejs, %s) = %q, %v", version, rev, err)
+		}
+	}
+	if _, err := utils.ResolvePinRevision(context.Background(), "nodejs", "18.17.1"); err == nil {
+		t.Error("ResolvePinRevision() accepted a version the channel does not provide")
+	}
+	if _, err := utils.ResolvePinRevision(context.Background(), "nodejs", "20.1"); err == nil {
+		t.Error("ResolvePinRevision() matched 20.1 against 20.11.1")
+	}
+}
+
+func TestCheckRevisionVersion(t *testing.T) {
+	useTempConfigDir(t)
+	fake := &testutils.FakeRunner{Outputs: map[string]string{
+		"nix-instantiate": `{"revision": "", "packages": {"nodejs": {"name": "nodejs-18.17.1", "version": "18.17.1"}}}`,
+	}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	if provided, err := utils.CheckRevisionVersion(context.Background(), "nodejs", "18", pinRev); err != nil || provided != "18.17.1" {
+		t.Errorf("CheckRevisionVersion(nodejs, 18) = %q, %v", provided, err)
+	}
+	if _, err := utils.CheckRevisionVersion(context.Background(), "nodejs", "20.11.1", pinRev); !errors.Is(err, utils.ErrValidationFailed) {
+		t.Errorf("CheckRevisionVersion() with another version error = %v, want %v", err, utils.ErrValidationFailed)
+	}
+
+	fake.Outputs["nix-instantiate"] = `{"revision": "", "packages": {"nodejs": null}}`
+	if _, err := utils.CheckRevisionVersion(context.Background(), "nodejs", "18.17.1", pinRev); !errors.Is(err, utils.ErrInvalidPackage) {
+		t.Errorf("CheckRevisionVersion() of a missing package error = %v, want %v", err, utils.ErrInvalidPackage)
+	}
+
+	// Known revisions are answered from the version history
+	db, err := utils.LoadVersionDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Add(utils.VersionRecord{Attr: "nodejs", Version: "18.17.1", Revision: pinRev})
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+	fake.Calls = nil
+	if _, err := utils.CheckRevisionVersion(context.Background(), "nodejs", "18.17.1", pinRev); err != nil {
+		t.Errorf("CheckRevisionVersion() with a recorded version error = %v", err)
+	}
+	if len(fake.Calls) != 0 {
+		t.Errorf("CheckRevisionVersion() evaluated nixpkgs for a recorded version: %v", fake.Calls)
+	}
+}
+
+func TestNixEditorPinPackageHash(t *testing.T) {
+	editor, _ := utils.NewNixEditor(pinShellNix)
+	source := utils.NixpkgsSource{Revision: pinRev, NarHash: "sha256-AAAA"}
+	if err := editor.PinPackage("gcc", source); err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := editor.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `import (fetchTarball { url = "https://github.com/NixOS/nixpkgs/archive/` + pinRev + `.tar.gz"; sha256 = "sha256-AAAA"; }) {};`
+	if !strings.Contains(pinned, want) {
+		t.Errorf("pinned source does not fetch by hash:\n%s", pinned)
+	}
+
+	editor, _ = utils.NewNixEditor(pinned)
+	if got := editor.PinnedPackages()["gcc"]; got != source {
+		t.Errorf("PinnedPackages()[gcc] = %+v, want %+v", got, source)
+	}
+}
+
+func TestNixEditorPinNixpkgs(t *testing.T) {
+	source := utils.NixpkgsSource{Revision: pinRev, NarHash: "sha256-AAAA"}
+	fetch := `fetchTarball { url = "https://github.com/NixOS/nixpkgs/archive/` + pinRev + `.tar.gz"; sha256 = "sha256-AAAA"; }`
+
+	tests := []struct {
+		name     string
+		src      string
+		contains string
+	}{
+		{
+			name:     "pkgs argument default",
+			src:      pinShellNix,
+			contains: "{ pkgs ? import (" + fetch + ") {} }:",
+		},
+		{
+			name:     "with import",
+			src:      "with import <nixpkgs> {};\nmkShell { packages = [ gcc ]; }\n",
+			contains: "with import (" + fetch + ") {};",
+		},
+		{
+			name:     "previously pinned tarball",
+			src:      `{ pkgs ? import (fetchTarball "https://github.com/NixOS/nixpkgs/archive/` + revA + `.tar.gz") {} }: pkgs.mkShell { packages = [ pkgs.gcc ]; }`,
+			contains: "{ pkgs ? import (" + fetch + ") {} }:",
+		},
+		{
+			name:     "flake input in an inputs set",
+			src:      pinFlakeNix,
+			contains: `nixpkgs.url = "github:NixOS/nixpkgs/` + pinRev + `";`,
+		},
+		{
+			name:     "flake input as a dotted path",
+			src:      "{\n  inputs.nixpkgs.url = \"github:nixos/nixpkgs/nixos-unstable\";\n  outputs = { self, nixpkgs }: {};\n}\n",
+			contains: `inputs.nixpkgs.url = "github:NixOS/nixpkgs/` + pinRev + `";`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editor, err := utils.NewNixEditor(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := editor.PinNixpkgs(source); err != nil {
+				t.Fatal(err)
+			}
+			result, err := editor.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(result, tt.contains) {
+				t.Errorf("result does not contain %q:\n%s", tt.contains, result)
+			}
+			if _, err := utils.ParseNix(result); err != nil {
+				t.Errorf("result does not parse: %v\n%s", err, result)
+			}
+		})
+	}
+
+	// Packages pinned on their own keep their snapshot
+	editor, _ := utils.NewNixEditor(pinShellNix)
+	if err := editor.PinPackage("nodejs", utils.NixpkgsSource{Revision: revA}); err != nil {
+		t.Fatal(err)
+	}
+	pinned, _ := editor.Result()
+	editor, _ = utils.NewNixEditor(pinned)
+	if err := editor.PinNixpkgs(source); err != nil {
+		t.Fatal(err)
+	}
+	result, _ := editor.Result()
+	if !strings.Contains(result, "archive/"+revA+".tar.gz") {
+		t.Errorf("pinned package set was repinned:\n%s", result)
+	}
+
+	editor, _ = utils.NewNixEditor("{ pkgs }: pkgs.mkShell { packages = [ pkgs.gcc ]; }")
+	if err := editor.PinNixpkgs(source); err == nil {
+		t.Error("PinNixpkgs() succeeded without a <nixpkgs> import")
+	}
+}
@@ -0,0 +1,137 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdaashir/NSM/tests/testutils"
+	"github.com/mdaashir/NSM/utils"
+)
+
+func TestExecRunner(t *testing.T) {
+	skipOnWindows(t)
+	runner := utils.ExecRunner{}
+	ctx := context.Background()
+
+	t.Run("environment and stdin", func(t *testing.T) {
+		output, err := runner.Run(ctx, utils.NixCommand{
+			Name:  "sh",
+			Args:  []string{"-c", `printf '%s:' "$NSM_TEST"; cat`},
+			Env:   []string{"NSM_TEST=value"},
+			Stdin: strings.NewReader("input"),
+		})
+		if err != nil || string(output) != "value:input" {
+			t.Errorf("Run() = %q, %v", output, err)
+		}
+	})
+
+	t.Run("streamed output", func(t *testing.T) {
+		var stdout bytes.Buffer
+		output, err := runner.Run(ctx, utils.NixCommand{Name: "sh", Args: []string{"-c", "echo streamed"}, Stdout: &stdout})
+		if err != nil || len(output) != 0 || stdout.String() != "streamed\n" {
+			t.Errorf("Run() = %q, %v; streamed %q", output, err, stdout.String())
+		}
+	})
+
+	t.Run("exit status", func(t *testing.T) {
+		_, err := runner.Run(ctx, utils.NixCommand{Name: "sh", Args: []string{"-c", "echo broken >&2; exit 3"}})
+		var cmdErr *utils.CommandError
+		if !errors.As(err, &cmdErr) {
+			t.Fatalf("Run() error = %v, want a CommandError", err)
+		}
+		if cmdErr.ExitCode != 3 || cmdErr.Stderr != "broken" || cmdErr.TimedOut {
+			t.Errorf("CommandError = %+v", cmdErr)
+		}
+		if !strings.Contains(err.Error(), "broken") {
+			t.Errorf("Error() = %q, want the standard error", err.Error())
+		}
+		if !errors.Is(err, utils.ErrExternalCommandFailed) {
+			t.Errorf("Run() error = %v, want ErrExternalCommandFailed", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		start := time.Now()
+		_, err := runner.Run(ctx, utils.NixCommand{Name: "sleep", Args: []string{"5"}, Timeout: 50 * time.Millisecond})
+		var cmdErr *utils.CommandError
+		if !errors.As(err, &cmdErr) || !cmdErr.TimedOut || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Run() error = %v, want a timeout", err)
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Error("Run() did not stop the command at its timeout")
+		}
+	})
+
+	t.Run("cancel interrupts", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		output, err := runner.Run(ctx, utils.NixCommand{
+			Name: "sh",
+			Args: []string{"-c", `trap 'echo interrupted; exit 130' INT; sleep 5 >/dev/null 2>&1 & wait`},
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Run() error = %v, want a cancellation", err)
+		}
+		if string(output) != "interrupted\n" {
+			t.Errorf("Run() = %q, want the command to handle the interrupt", output)
+		}
+	})
+
+	t.Run("missing command", func(t *testing.T) {
+		_, err := runner.Run(ctx, utils.NixCommand{Name: "nsm-no-such-command"})
+		var cmdErr *utils.CommandError
+		if !errors.As(err, &cmdErr) || cmdErr.ExitCode != -1 {
+			t.Errorf("Run() error = %v, want a CommandError that did not exit", err)
+		}
+	})
+}
+
+func TestFakeRunner(t *testing.T) {
+	fake := &testutils.FakeRunner{
+		Outputs: map[string]string{
+			"nix":           "nix (Nix) 2.18.1",
+			"nix path-info": `{}`,
+			"nix-channel":   "nixpkgs https://nixos.org/channels/nixpkgs-unstable",
+		},
+		Errors:  map[string]error{"nix-store --verify": errors.New("store is corrupt")},
+		Missing: map[string]bool{"nix-env": true},
+	}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	if version, err := utils.GetNixVersion(context.Background()); err != nil || version != "nix (Nix) 2.18.1" {
+		t.Errorf("GetNixVersion() = %q, %v", version, err)
+	}
+	if hashes := utils.GetNarHashes(context.Background(), []string{"/nix/store/aaa-gcc"}); len(hashes) != 0 {
+		t.Errorf("GetNarHashes() = %v", hashes)
+	}
+	if channel, err := utils.GetChannelInfo(context.Background()); err != nil || !strings.HasPrefix(channel, "nixpkgs ") {
+		t.Errorf("GetChannelInfo() = %q, %v", channel, err)
+	}
+	if output, err := utils.UpdateChannels(context.Background()); err != nil || !strings.Contains(output, "nixpkgs-unstable") {
+		t.Errorf("UpdateChannels() = %q, %v", output, err)
+	}
+	if err := utils.VerifyStore(context.Background()); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("VerifyStore() error = %v", err)
+	}
+	if err := utils.CheckNixInstallation(); !errors.Is(err, utils.ErrNixNotInstalled) {
+		t.Errorf("CheckNixInstallation() error = %v, want ErrNixNotInstalled", err)
+	}
+
+	expected := [][]string{
+		{"nix", "--version"},
+		{"nix", "path-info", "--json", "/nix/store/aaa-gcc"},
+		{"nix", "path-info", "--json", "--store", "https://cache.nixos.org", "/nix/store/aaa-gcc"},
+		{"nix-channel", "--list"},
+		{"nix-channel", "--update"},
+		{"nix-store", "--verify"},
+	}
+	if !reflect.DeepEqual(fake.Calls, expected) {
+		t.Errorf("Calls = %v, want %v", fake.Calls, expected)
+	}
+}
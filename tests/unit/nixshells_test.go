@@ -0,0 +1,267 @@
+package unit
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mdaashir/NSM/utils"
+)
+
+const namedShellsFlake = `{
+  outputs = { self, nixpkgs }:
+    let pkgs = nixpkgs.legacyPackages.x86_64-linux; in {
+      devShells.x86_64-linux.default = pkgs.mkShell {
+        packages = with pkgs; [ go ];
+      };
+      devShells.x86_64-linux.ci = pkgs.mkShell {
+        packages = with pkgs; [ jq shellcheck ];
+      };
+    };
+}`
+
+func TestFindNixShells(t *testing.T) {
+	tests := []struct {
+		name       string
+		src        string
+		names      []string
+		attributes []string
+	}{
+		{
+			name:       "shell.nix",
+			src:        `{ pkgs ? import <nixpkgs> {} }: pkgs.mkShell { packages = [ gcc ]; }`,
+			names:      []string{""},
+			attributes: []string{""},
+		},
+		{
+			name:       "legacy devShell",
+			src:        `{ outputs = { nixpkgs, ... }: { devShell.x86_64-linux = pkgs.mkShell { packages = [ ]; }; }; }`,
+			names:      []string{"default"},
+			attributes: []string{"devShell.x86_64-linux"},
+		},
+		{
+			name:       "devShells with and without a system",
+			src:        namedShellsFlake,
+			names:      []string{"default", "ci"},
+			attributes: []string{"devShells.x86_64-linux.default", "devShells.x86_64-linux.ci"},
+		},
+		{
+			name: "nested sets and interpolated systems",
+			src: `{
+  outputs = { nixpkgs, ... }: {
+    devShells = {
+      default = pkgs.mkShell { packages = [ ]; };
+      ${system}.docs = pkgs.mkShell { packages = [ ]; };
+    };
+  };
+}`,
+			names:      []string{"default", "docs"},
+			attributes: []string{"devShells.default", "devShells.${system}.docs"},
+		},
+		{
+			name: "shells bound in let take the name they are exposed under",
+			src: `let
+  base = pkgs.mkShell { packages = [ ]; };
+in {
+  devShells.default = pkgs.mkShell { packages = [ ]; };
+}`,
+			names:      []string{"", "default"},
+			attributes: []string{"base", "devShells.default"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editor, err := utils.NewNixEditor(tt.src)
+			if err != nil {
+				t.Fatalf("NewNixEditor() error = %v", err)
+			}
+			var names, attributes []string
+			for _, shell := range editor.Shells() {
+				names = append(names, shell.Name)
+				attributes = append(attributes, shell.Attribute())
+			}
+			if !slices.Equal(names, tt.names) {
+				t.Errorf("shell names = %q, want %q", names, tt.names)
+			}
+			if !slices.Equal(attributes, tt.attributes) {
+				t.Errorf("shell attributes = %q, want %q", attributes, tt.attributes)
+			}
+		})
+	}
+}
+
+func TestNixEditorSelectShell(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		shell    string
+		packages []string
+		wantErr  string
+	}{
+		{name: "default shell", src: namedShellsFlake, packages: []string{"go"}},
+		{name: "named shell", src: namedShellsFlake, shell: "ci", packages: []string{"jq", "shellcheck"}},
+		{name: "unknown shell", src: namedShellsFlake, shell: "docs", wantErr: "the shells are default, ci"},
+		{
+			name:     "default shell of shell.nix",
+			src:      `pkgs.mkShell { packages = [ gcc ]; }`,
+			shell:    "default",
+			packages: []string{"gcc"},
+		},
+		{
+			name:    "named shell of shell.nix",
+			src:     `pkgs.mkShell { packages = [ gcc ]; }`,
+			shell:   "ci",
+			wantErr: "only flakes define named shells",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editor, err := utils.NewNixEditor(tt.src)
+			if err != nil {
+				t.Fatalf("NewNixEditor() error = %v", err)
+			}
+			err = editor.SelectShell(tt.shell)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("SelectShell(%q) error = %v, want %q", tt.shell, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SelectShell(%q) error = %v", tt.shell, err)
+			}
+			if got := editor.Packages(); !slices.Equal(got, tt.packages) {
+				t.Errorf("Packages() = %q, want %q", got, tt.packages)
+			}
+		})
+	}
+}
+
+func TestNixEditorSelectShellEdits(t *testing.T) {
+	editor, err := utils.NewNixEditor(namedShellsFlake)
+	if err != nil {
+		t.Fatalf("NewNixEditor() error = %v", err)
+	}
+	if err := editor.SelectShell("ci"); err != nil {
+		t.Fatalf("SelectShell() error = %v", err)
+	}
+	if err := editor.AddPackages([]string{"yq"}); err != nil {
+		t.Fatalf("AddPackages() error = %v", err)
+	}
+	got, err := editor.Result()
+	if err != nil {
+		t.Fatalf("Result() error = %v", err)
+	}
+	if !strings.Contains(got, "[ go ]") || !strings.Contains(got, "[ jq shellcheck yq ]") {
+		t.Errorf("AddPackages() changed the wrong shell:\n%s", got)
+	}
+}
+
+func TestNixEditorAddShell(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name: "devShells",
+			src: `{
+  outputs = { nixpkgs, ... }: {
+    devShells.x86_64-linux.default = pkgs.mkShell {
+      packages = with pkgs; [ go ];
+    };
+  };
+}`,
+			expected: `{
+  outputs = { nixpkgs, ... }: {
+    devShells.x86_64-linux.default = pkgs.mkShell {
+      packages = with pkgs; [ go ];
+    };
+
+    devShells.x86_64-linux.ci = pkgs.mkShell {
+      name = "ci";
+
+      packages = with pkgs; [
+      ];
+    };
+  };
+}`,
+		},
+		{
+			name: "legacy devShell",
+			src: `{
+  outputs = { nixpkgs, ... }: {
+    devShell.x86_64-linux = pkgs.mkShell {
+      buildInputs = [ go ];
+    };
+  };
+}`,
+			expected: `{
+  outputs = { nixpkgs, ... }: {
+    devShell.x86_64-linux = pkgs.mkShell {
+      buildInputs = [ go ];
+    };
+
+    devShells.x86_64-linux.ci = pkgs.mkShell {
+      name = "ci";
+
+      buildInputs = [
+      ];
+    };
+  };
+}`,
+		},
+		{name: "existing shell", src: namedShellsFlake, wantErr: true},
+		{name: "no shell", src: `{ outputs = { ... }: { }; }`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editor, err := utils.NewNixEditor(tt.src)
+			if err != nil {
+				t.Fatalf("NewNixEditor() error = %v", err)
+			}
+			err = editor.AddShell("ci")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("AddShell() expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AddShell() error = %v", err)
+			}
+			got, err := editor.Result()
+			if err != nil {
+				t.Fatalf("Result() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("AddShell() =\n%s\nwant\n%s", got, tt.expected)
+			}
+
+			added, err := utils.NewNixEditor(got)
+			if err != nil {
+				t.Fatalf("result does not parse: %v", err)
+			}
+			if !slices.Contains(added.ShellNames(), "ci") {
+				t.Errorf("ShellNames() = %q, want ci", added.ShellNames())
+			}
+		})
+	}
+}
+
+func TestValidateShellName(t *testing.T) {
+	for _, name := range []string{"ci", "docs-site", "py_311", "x'"} {
+		if err := utils.ValidateShellName(name); err != nil {
+			t.Errorf("ValidateShellName(%q) error = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "1ci", "with space", "a.b", "${x}"} {
+		if err := utils.ValidateShellName(name); err == nil {
+			t.Errorf("ValidateShellName(%q) expected an error", name)
+		}
+	}
+}
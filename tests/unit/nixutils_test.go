@@ -1,9 +1,12 @@
 package unit
 
 import (
+	"context"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/mdaashir/NSM/tests/testutils"
@@ -25,12 +28,18 @@ func TestValidatePackage(t *testing.T) {
 	}{
 		{"empty package", "", false},
 		{"valid package", "gcc", true},
-		{"valid package with version", "python3.9", true},
+		{"invalid attribute name starting with digit", "python3.9", false},
 		{"valid package with hyphen", "node-red", true},
 		{"invalid package with space", "gcc python", false},
 		{"invalid package with special chars", "gcc$python", false},
 		{"valid package with dot", "go.mod", true},
 		{"valid package with underscore", "test_package", true},
+		{"valid nested attribute path", "python3Packages.numpy", true},
+		{"valid node package", "nodePackages.pnpm", true},
+		{"valid haskell package", "haskellPackages.ghc", true},
+		{"invalid empty attribute name", "python3Packages..numpy", false},
+		{"invalid trailing dot", "nodePackages.", false},
+		{"invalid keyword attribute", "pkgs.with", false},
 	}
 
 	for _, tt := range tests {
@@ -67,7 +76,7 @@ func TestCheckFlakeSupport(t *testing.T) {
 		}
 		defer os.Setenv("PATH", oldPath)
 
-		if !utils.CheckFlakeSupport() {
+		if !utils.CheckFlakeSupport(context.Background()) {
 			t.Error("Expected flake support for Nix 2.4.0")
 		}
 	})
@@ -92,7 +101,7 @@ func TestCheckFlakeSupport(t *testing.T) {
 		}
 		defer os.Setenv("PATH", oldPath)
 
-		if utils.CheckFlakeSupport() {
+		if utils.CheckFlakeSupport(context.Background()) {
 			t.Error("Expected no flake support for Nix 2.3.0")
 		}
 	})
@@ -166,7 +175,7 @@ func TestGetNixVersion(t *testing.T) {
 	}
 	defer os.Setenv("PATH", oldPath)
 
-	version, err := utils.GetNixVersion()
+	version, err := utils.GetNixVersion(context.Background())
 	if err != nil {
 		t.Fatalf("GetNixVersion() error = %v", err)
 	}
@@ -207,7 +216,7 @@ func TestGetPackageVersion(t *testing.T) {
 	}
 	defer os.Setenv("PATH", oldPath)
 
-	version, err := utils.GetPackageVersion("gcc")
+	version, err := utils.GetPackageVersion(context.Background(), "gcc")
 	if err != nil {
 		t.Fatalf("GetPackageVersion() error = %v", err)
 	}
@@ -217,3 +226,94 @@ func TestGetPackageVersion(t *testing.T) {
 		t.Errorf("GetPackageVersion() = %q, want %q", version, expectedVersion)
 	}
 }
+
+func TestQueryProfilePackages(t *testing.T) {
+	fake := &testutils.FakeRunner{Outputs: map[string]string{
+		"nix-env": `{
+			"nixpkgs.gcc-arm-embedded": {"name": "gcc-arm-embedded-12.3.rel1", "pname": "gcc-arm-embedded", "version": "12.3.rel1"},
+			"nixpkgs.gcc": {"name": "gcc-wrapper-12.3.0", "pname": "gcc-wrapper", "version": "12.3.0",
+				"outputs": {"man": "/nix/store/bbb-gcc-wrapper-12.3.0-man", "out": "/nix/store/aaa-gcc-wrapper-12.3.0"},
+				"meta": {"description": "GNU Compiler Collection", "homepage": ["https://gcc.gnu.org/"], "license": {"spdxId": "GPL-3.0-or-later"}}},
+			"nixos.gcc": {"name": "gcc-wrapper-13.2.0", "pname": "gcc-wrapper", "version": "13.2.0"},
+			"nixpkgs.python3Packages.numpy": {"name": "python3.11-numpy-1.26.4", "pname": "numpy", "version": "1.26.4"}
+		}`,
+	}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	packages, err := utils.QueryProfilePackages(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Calls) != 1 {
+		t.Errorf("ran nix-env %d times, want once", len(fake.Calls))
+	}
+
+	// Attribute paths match exactly, with or without their channel
+	for attr, expected := range map[string]string{
+		"gcc":                   "13.2.0",
+		"nixpkgs.gcc":           "12.3.0",
+		"gcc-arm-embedded":      "12.3.rel1",
+		"python3Packages.numpy": "1.26.4",
+	} {
+		if version, ok := packages.Version(attr); !ok || version != expected {
+			t.Errorf("Version(%q) = %q, %v, want %q", attr, version, ok, expected)
+		}
+	}
+	for _, attr := range []string{"gcc-arm", "numpy", "nodejs"} {
+		if info, ok := packages.Lookup(attr); ok {
+			t.Errorf("Lookup(%q) = %+v, want no package", attr, info)
+		}
+	}
+
+	gcc := packages["nixpkgs.gcc"]
+	expected := utils.PackageInfo{
+		AttrPath:  "gcc",
+		Name:      "gcc-wrapper-12.3.0",
+		Pname:     "gcc-wrapper",
+		Version:   "12.3.0",
+		Outputs:   map[string]string{"man": "/nix/store/bbb-gcc-wrapper-12.3.0-man", "out": "/nix/store/aaa-gcc-wrapper-12.3.0"},
+		StorePath: "/nix/store/aaa-gcc-wrapper-12.3.0",
+		Meta: utils.PackageMeta{
+			Description: "GNU Compiler Collection",
+			Homepage:    "https://gcc.gnu.org/",
+			Licenses:    []string{"GPL-3.0-or-later"},
+		},
+	}
+	if !reflect.DeepEqual(gcc, expected) {
+		t.Errorf("nixpkgs.gcc = %+v, want %+v", gcc, expected)
+	}
+
+	var attrs []string
+	for _, info := range packages.List() {
+		attrs = append(attrs, info.AttrPath)
+	}
+	if want := []string{"gcc", "gcc", "gcc-arm-embedded", "python3Packages.numpy"}; !reflect.DeepEqual(attrs, want) {
+		t.Errorf("List() = %v, want %v", attrs, want)
+	}
+}
+
+func TestLookupPackages(t *testing.T) {
+	fake := &testutils.FakeRunner{Outputs: map[string]string{
+		"nix-instantiate": `{"revision": "", "packages": {
+			"gcc": {"name": "gcc-wrapper-13.2.0", "pname": "gcc-wrapper", "version": "13.2.0", "outputs": {"out": "/nix/store/aaa-gcc-wrapper-13.2.0"}},
+			"nodejs": null
+		}}`,
+	}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	packages, err := utils.LookupPackages(context.Background(), "shell.nix", []string{"gcc", "nodejs", "(not an attr)"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 1 || packages["gcc"].AttrPath != "gcc" || packages["gcc"].StorePath != "/nix/store/aaa-gcc-wrapper-13.2.0" {
+		t.Errorf("LookupPackages() = %+v", packages)
+	}
+
+	// Only valid attribute paths reach the evaluation
+	expr := fake.Calls[len(fake.Calls)-1]
+	if call := strings.Join(expr, " "); strings.Contains(call, "not an attr") {
+		t.Errorf("invalid attribute path was evaluated: %s", call)
+	}
+}
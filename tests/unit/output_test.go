@@ -0,0 +1,65 @@
+package unit
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mdaashir/NSM/utils"
+)
+
+// outputResult is a command result for the rendering tests
+type outputResult struct {
+	Package string   `json:"package"`
+	Version string   `json:"version,omitempty"`
+	Outputs []string `json:"outputs"`
+}
+
+func (r outputResult) Headers() []string {
+	return []string{"Package", "Version"}
+}
+
+func (r outputResult) Rows() [][]string {
+	return [][]string{{r.Package, r.Version}}
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	for _, value := range []string{"table", "plain", "json", "YAML"} {
+		if _, err := utils.ParseOutputFormat(value); err != nil {
+			t.Errorf("ParseOutputFormat(%q) error = %v", value, err)
+		}
+	}
+	if _, err := utils.ParseOutputFormat("xml"); err == nil {
+		t.Error("ParseOutputFormat(xml) accepted an unknown format")
+	}
+}
+
+func TestRenderResult(t *testing.T) {
+	result := outputResult{Package: "nodejs", Version: "20.11.1", Outputs: []string{"out", "dev"}}
+	tests := []struct {
+		format utils.OutputFormat
+		want   string
+	}{
+		{utils.OutputJSON, "{\n  \"package\": \"nodejs\",\n  \"version\": \"20.11.1\",\n  \"outputs\": [\n    \"out\",\n    \"dev\"\n  ]\n}\n"},
+		{utils.OutputYAML, "package: nodejs\nversion: 20.11.1\noutputs:\n  - out\n  - dev\n"},
+		{utils.OutputPlain, "nodejs\t20.11.1\n"},
+		{utils.OutputTable, "Package | Version\n--------+--------\nnodejs  | 20.11.1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := utils.RenderResult(&buf, tt.format, result); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("RenderResult() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+
+	t.Run("not tabular", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := utils.RenderResult(&buf, utils.OutputPlain, map[string]string{"a": "b"}); err == nil {
+			t.Error("RenderResult() printed a result without rows")
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package unit
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdaashir/NSM/tests/testutils"
+	"github.com/mdaashir/NSM/utils"
+)
+
+func TestGetPackageDetails(t *testing.T) {
+	fake := &testutils.FakeRunner{Outputs: map[string]string{"nix-instantiate": `{
+  "name": "jq-1.7.1", "pname": "jq", "version": "1.7.1",
+  "description": "Lightweight and flexible command-line JSON processor",
+  "homepage": "https://jqlang.github.io/jq/",
+  "licenses": ["MIT"], "maintainers": ["raskin"], "platforms": ["x86_64-linux"],
+  "outputs": ["bin", "out", "man"], "storePath": "/nix/store/abc-jq-1.7.1-bin"
+}`}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	details, err := utils.GetPackageDetails(context.Background(), "shell.nix", "jq")
+	if err != nil {
+		t.Fatalf("GetPackageDetails() error = %v", err)
+	}
+	if details.Attr != "jq" || details.Version != "1.7.1" || !reflect.DeepEqual(details.Outputs, []string{"bin", "out", "man"}) {
+		t.Errorf("GetPackageDetails() = %+v", details)
+	}
+	if call := strings.Join(fake.Calls[0], " "); !strings.Contains(call, `lib.splitString "." "jq"`) {
+		t.Errorf("unexpected evaluation call: %s", call)
+	}
+
+	fake.Outputs["nix-instantiate"] = "null"
+	details, err = utils.GetPackageDetails(context.Background(), "shell.nix", "nosuchpackage")
+	if err != nil || details != nil {
+		t.Errorf("GetPackageDetails() for a missing package = %+v, %v; want nil, nil", details, err)
+	}
+}
+
+func TestGetClosureSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected int64
+		wantErr  bool
+	}{
+		{"list format", `[{"path": "/nix/store/abc-jq", "closureSize": 2097152}]`, 2097152, false},
+		{"object format", `{"/nix/store/abc-jq": {"closureSize": 1048576}}`, 1048576, false},
+		{"invalid path", `{"/nix/store/abc-jq": null}`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := utils.SetNixRunner(&testutils.FakeRunner{Outputs: map[string]string{"nix": tt.output}})
+			defer utils.SetNixRunner(previous)
+
+			size, err := utils.GetClosureSize(context.Background(), "/nix/store/abc-jq")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetClosureSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if size != tt.expected {
+				t.Errorf("GetClosureSize() = %d, want %d", size, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		512:        "512 B",
+		2048:       "2.0 KiB",
+		1572864:    "1.5 MiB",
+		3221225472: "3.0 GiB",
+	}
+	for bytes, expected := range tests {
+		if got := utils.FormatSize(bytes); got != expected {
+			t.Errorf("FormatSize(%d) = %q, want %q", bytes, got, expected)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mdaashir/NSM/tests/testutils"
+	"github.com/mdaashir/NSM/utils"
+)
+
+func TestSuggestPackages(t *testing.T) {
+	candidates := []string{"python3", "python311", "python3Full", "pythonFull", "nodejs", "nodejs_18", "go", "gcc", "gcc13", "gocode"}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"transposed letters", "pyhton3", []string{"python3"}},
+		{"prefix completions", "python3", []string{"python311", "python3Full"}},
+		{"missing letter", "nodej", []string{"nodejs", "nodejs_18"}},
+		{"no similar package", "kubernetes", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := utils.SuggestPackages(tt.input, candidates, 2)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("SuggestPackages(%q) = %v, want %v", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadPackageIndex(t *testing.T) {
+	dir := testutils.CreateTempDir(t)
+	defer os.RemoveAll(dir)
+
+	origXdgConfig := os.Getenv("XDG_CONFIG_HOME")
+	if err := os.Setenv("XDG_CONFIG_HOME", dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("XDG_CONFIG_HOME", origXdgConfig)
+
+	fake := &testutils.FakeRunner{Outputs: map[string]string{
+		"nix-instantiate": `"abc123"`,
+		"nix-env":         `{"python3": {"name": "python3-3.11.9"}, "gcc": {"name": "gcc-13.2.0"}}`,
+	}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	index, err := utils.LoadPackageIndex(context.Background())
+	if err != nil {
+		t.Fatalf("LoadPackageIndex() error = %v", err)
+	}
+	if index.Revision != "abc123" || !reflect.DeepEqual(index.Names, []string{"gcc", "python3"}) {
+		t.Errorf("LoadPackageIndex() = %+v", index)
+	}
+	if !index.Contains("gcc") || index.Contains("go") {
+		t.Error("Contains() does not match the indexed names")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "NSM", "package-index.json")); err != nil {
+		t.Errorf("package index was not cached: %v", err)
+	}
+
+	// The cached index is reused while the revision is unchanged
+	fake.Calls = nil
+	if _, err := utils.LoadPackageIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range fake.Calls {
+		if call[0] == "nix-env" {
+			t.Error("cached index was regenerated for the same revision")
+		}
+	}
+}
+
+// metaCatalog is nix-env -qaP --json --meta output for a few packages
+const metaCatalog = `{
+  "ripgrep": {"name": "ripgrep-14.1.0", "pname": "ripgrep", "version": "14.1.0",
+    "meta": {"description": "Line-oriented search tool", "license": [{"spdxId": "MIT"}, {"spdxId": "Unlicense"}], "platforms": ["x86_64-linux", "aarch64-darwin"]}},
+  "ripgrep-all": {"name": "ripgrep-all-0.10.6", "pname": "ripgrep-all", "version": "0.10.6",
+    "meta": {"description": "Ripgrep, but also search in PDFs and archives", "license": {"spdxId": "AGPL-3.0-or-later"}, "platforms": ["x86_64-linux"]}},
+  "jq": {"name": "jq-1.7.1", "pname": "jq", "version": "1.7.1",
+    "meta": {"description": "Lightweight and flexible command-line JSON processor", "license": {"spdxId": "MIT"}, "platforms": ["x86_64-linux", "aarch64-darwin"]}},
+  "yq": {"name": "yq-3.4.3", "pname": "yq", "version": "3.4.3",
+    "meta": {"description": "Command-line YAML/XML/TOML processor - jq wrapper", "license": "asl20"}}
+}`
+
+func TestSearchPackageIndex(t *testing.T) {
+	dir := testutils.CreateTempDir(t)
+	defer os.RemoveAll(dir)
+
+	origXdgConfig := os.Getenv("XDG_CONFIG_HOME")
+	if err := os.Setenv("XDG_CONFIG_HOME", dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("XDG_CONFIG_HOME", origXdgConfig)
+
+	previous := utils.SetNixRunner(&testutils.FakeRunner{Outputs: map[string]string{
+		"nix-instantiate": `"abc123"`,
+		"nix-env":         metaCatalog,
+	}})
+	defer utils.SetNixRunner(previous)
+
+	index, err := utils.LoadPackageIndex(context.Background())
+	if err != nil {
+		t.Fatalf("LoadPackageIndex() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		opts     utils.SearchOptions
+		expected []string
+	}{
+		{"exact name ranks first", "ripgrep", utils.SearchOptions{}, []string{"ripgrep", "ripgrep-all"}},
+		{"description words", "json processor", utils.SearchOptions{}, []string{"jq"}},
+		{"prefix of a word", "proc", utils.SearchOptions{}, []string{"jq", "yq"}},
+		{"name before description", "jq", utils.SearchOptions{}, []string{"jq", "yq"}},
+		{"license filter", "search", utils.SearchOptions{License: "mit"}, []string{"ripgrep"}},
+		{"platform filter", "processor", utils.SearchOptions{Platform: "aarch64-darwin"}, []string{"jq"}},
+		{"limit", "ripgrep", utils.SearchOptions{Limit: 1}, []string{"ripgrep"}},
+		{"no match", "kubernetes", utils.SearchOptions{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, result := range index.Search(tt.query, tt.opts) {
+				got = append(got, result.Attr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.expected)
+			}
+		})
+	}
+
+	pkg, ok := index.Get("yq")
+	if !ok || pkg.Version != "3.4.3" || !reflect.DeepEqual(pkg.Licenses, []string{"asl20"}) {
+		t.Errorf("Get(yq) = %+v, %v", pkg, ok)
+	}
+}
+
+func TestPackageIndexRevisionChange(t *testing.T) {
+	dir := testutils.CreateTempDir(t)
+	defer os.RemoveAll(dir)
+
+	origXdgConfig := os.Getenv("XDG_CONFIG_HOME")
+	if err := os.Setenv("XDG_CONFIG_HOME", dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("XDG_CONFIG_HOME", origXdgConfig)
+
+	fake := &testutils.FakeRunner{Outputs: map[string]string{
+		"nix-instantiate": `"abc123"`,
+		"nix-env":         metaCatalog,
+	}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	if _, err := utils.LoadPackageIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new revision updates the cached index
+	fake.Outputs["nix-instantiate"] = `"def456"`
+	fake.Outputs["nix-env"] = `{
+  "jq": {"name": "jq-1.8.0", "pname": "jq", "version": "1.8.0", "meta": {"description": "Lightweight and flexible command-line JSON processor"}},
+  "fd": {"name": "fd-10.1.0", "pname": "fd", "version": "10.1.0", "meta": {"description": "Simple, fast alternative to find"}}
+}`
+	index, err := utils.LoadPackageIndex(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Revision != "def456" || !reflect.DeepEqual(index.Names, []string{"fd", "jq"}) {
+		t.Errorf("LoadPackageIndex() after revision change = %v at %s", index.Names, index.Revision)
+	}
+	if results := index.Search("1.8", utils.SearchOptions{}); len(results) != 1 || results[0].Attr != "jq" {
+		t.Errorf("updated package was not re-indexed: %+v", results)
+	}
+}
+
+func TestBuildPackageIndexReuse(t *testing.T) {
+	fake := &testutils.FakeRunner{Outputs: map[string]string{
+		"nix-env": `{
+  "ripgrep": {"name": "ripgrep-14.1.0", "pname": "ripgrep", "version": "14.1.0", "meta": {"description": "Line-oriented search tool", "license": {"spdxId": "MIT"}}},
+  "jq": {"name": "jq-1.7.1", "pname": "jq", "version": "1.7.1", "meta": {"description": "JSON processor"}}
+}`,
+	}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	first, err := utils.BuildPackageIndex(context.Background(), "abc123", nil)
+	if err != nil {
+		t.Fatalf("BuildPackageIndex() error = %v", err)
+	}
+
+	// The same revision needs no query
+	fake.Calls = nil
+	again, err := utils.BuildPackageIndex(context.Background(), "abc123", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first {
+		t.Error("BuildPackageIndex() rebuilt the index of an unchanged revision")
+	}
+	for _, call := range fake.Calls {
+		if call[0] == "nix-env" {
+			t.Error("BuildPackageIndex() queried nix-env for an unchanged revision")
+		}
+	}
+
+	// A changed pname or license is re-tokenized even with the same version
+	fake.Outputs["nix-env"] = `{
+  "ripgrep": {"name": "ripgrep-14.1.0", "pname": "rg", "version": "14.1.0", "meta": {"description": "Line-oriented search tool", "license": {"spdxId": "Unlicense"}}},
+  "jq": {"name": "jq-1.7.1", "pname": "jq", "version": "1.7.1", "meta": {"description": "JSON processor"}}
+}`
+	updated, err := utils.BuildPackageIndex(context.Background(), "def456", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results := updated.Search("rg", utils.SearchOptions{}); len(results) != 1 || results[0].Attr != "ripgrep" {
+		t.Errorf("changed pname was not re-indexed: %+v", results)
+	}
+	if results := updated.Search("unlicense", utils.SearchOptions{}); len(results) != 1 {
+		t.Errorf("changed license was not re-indexed: %+v", results)
+	}
+	if results := updated.Search("mit", utils.SearchOptions{}); len(results) != 0 {
+		t.Errorf("stale license terms were kept: %+v", results)
+	}
+}
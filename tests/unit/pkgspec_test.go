@@ -0,0 +1,182 @@
+package unit
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdaashir/NSM/tests/testutils"
+	"github.com/mdaashir/NSM/utils"
+)
+
+func TestParsePackageSpec(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected utils.PackageSpec
+		valid    bool
+	}{
+		{"gcc", utils.PackageSpec{Attr: "gcc"}, true},
+		{"python3@3.11", utils.PackageSpec{Attr: "python3", Version: "3.11"}, true},
+		{"pkgs.nodejs@v18", utils.PackageSpec{Attr: "nodejs", Version: "18"}, true},
+		{"python3Packages.numpy@1.26.4", utils.PackageSpec{Attr: "python3Packages.numpy", Version: "1.26.4"}, true},
+		{"go@1.22rc1", utils.PackageSpec{Attr: "go", Version: "1.22rc1"}, true},
+		{"nodejs@", utils.PackageSpec{}, false},
+		{"nodejs@latest", utils.PackageSpec{}, false},
+		{"nodejs@18@20", utils.PackageSpec{}, false},
+		{"@18", utils.PackageSpec{}, false},
+		{"gcc;rm", utils.PackageSpec{}, false},
+	}
+
+	for _, tt := range tests {
+		spec, err := utils.ParsePackageSpec(tt.input)
+		if tt.valid != (err == nil) {
+			t.Errorf("ParsePackageSpec(%q) error = %v, want valid = %v", tt.input, err, tt.valid)
+			continue
+		}
+		if tt.valid && spec != tt.expected {
+			t.Errorf("ParsePackageSpec(%q) = %+v, want %+v", tt.input, spec, tt.expected)
+		}
+	}
+
+	if got := (utils.PackageSpec{Attr: "python3", Version: "3.11"}).String(); got != "python3@3.11" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestVersionedAttrCandidates(t *testing.T) {
+	tests := []struct {
+		spec     utils.PackageSpec
+		contains []string
+		first    string
+	}{
+		{utils.PackageSpec{Attr: "python3", Version: "3.11"}, []string{"python311", "python_3_11"}, "python311"},
+		{utils.PackageSpec{Attr: "nodejs", Version: "18"}, []string{"nodejs18", "nodejs_18"}, "nodejs18"},
+		{utils.PackageSpec{Attr: "go", Version: "1.22.1"}, []string{"go_1_22", "go122"}, "go1221"},
+	}
+
+	for _, tt := range tests {
+		candidates := utils.VersionedAttrCandidates(tt.spec)
+		for _, want := range tt.contains {
+			found := false
+			for _, c := range candidates {
+				found = found || c == want
+			}
+			if !found {
+				t.Errorf("VersionedAttrCandidates(%s) = %v, missing %s", tt.spec, candidates, want)
+			}
+		}
+		if len(candidates) > 0 && candidates[0] != tt.first {
+			t.Errorf("VersionedAttrCandidates(%s)[0] = %s, want %s", tt.spec, candidates[0], tt.first)
+		}
+	}
+}
+
+func TestResolvePackageSpec(t *testing.T) {
+	useTempConfigDir(t)
+
+	fake := &testutils.FakeRunner{Outputs: map[string]string{
+		"nix-instantiate": `{"revision": "", "packages": {
+			"python3": {"version": "3.12.4"}, "python311": {"version": "3.11.9"}, "python3_11": null,
+			"nodejs": {"version": "20.11.1"}, "nodejs18": null, "nodejs_18": null
+		}}`,
+	}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	// A versioned attribute of the project's nixpkgs
+	resolution, err := utils.ResolvePackageSpec(context.Background(), "shell.nix", utils.PackageSpec{Attr: "python3", Version: "3.11"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolution.Attr != "python311" || resolution.Version != "3.11.9" || resolution.Source != nil {
+		t.Errorf("python3@3.11 resolved to %+v", resolution)
+	}
+
+	// The attribute itself already has the version
+	resolution, err = utils.ResolvePackageSpec(context.Background(), "shell.nix", utils.PackageSpec{Attr: "nodejs", Version: "20"})
+	if err != nil || resolution.Attr != "nodejs" || resolution.Source != nil {
+		t.Errorf("nodejs@20 resolved to %+v, %v", resolution, err)
+	}
+
+	// Another nixpkgs revision from the version history
+	db, _ := utils.LoadVersionDB()
+	db.Add(utils.VersionRecord{Attr: "nodejs", Version: "18.17.1", Revision: revA, NarHash: "sha256-AAAA"})
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+	resolution, err = utils.ResolvePackageSpec(context.Background(), "shell.nix", utils.PackageSpec{Attr: "nodejs", Version: "18"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &utils.NixpkgsSource{Revision: revA, NarHash: "sha256-AAAA"}
+	if resolution.Attr != "nodejs" || resolution.Version != "18.17.1" || !reflect.DeepEqual(resolution.Source, expected) {
+		t.Errorf("nodejs@18 resolved to %+v", resolution)
+	}
+}
+
+func TestNixEditorConstraints(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		contains string
+	}{
+		{
+			name:     "one package per line",
+			src:      pinShellNix,
+			contains: "    nodejs # runtime\n    python311 # nsm: python3@3.11\n",
+		},
+		{
+			name:     "single-line list",
+			src:      "{ pkgs ? import <nixpkgs> {} }:\npkgs.mkShell { packages = with pkgs; [ gcc ]; }\n",
+			contains: "[ gcc python311 /* nsm: python3@3.11 */ ]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editor, _ := utils.NewNixEditor(tt.src)
+			editor.Annotate("python311", "python3@3.11")
+			if err := editor.AddPackages([]string{"python311"}); err != nil {
+				t.Fatal(err)
+			}
+			result, err := editor.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(result, tt.contains) {
+				t.Fatalf("result does not contain %q:\n%s", tt.contains, result)
+			}
+
+			editor, err = utils.NewNixEditor(result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := editor.Constraints(); !reflect.DeepEqual(got, map[string]string{"python311": "python3@3.11"}) {
+				t.Errorf("Constraints() = %v", got)
+			}
+
+			// Removing the package takes its constraint along
+			editor.RemovePackages(map[string]bool{"python311": true})
+			result, _ = editor.Result()
+			if strings.Contains(result, "nsm:") || strings.Contains(result, "python311") {
+				t.Errorf("constraint left behind:\n%s", result)
+			}
+		})
+	}
+}
+
+func TestNixEditorPinConstrainedPackage(t *testing.T) {
+	editor, _ := utils.NewNixEditor(pinShellNix)
+	editor.Annotate("ruby", "ruby@3.1")
+	if err := editor.PinPackage("ruby", utils.NixpkgsSource{Revision: pinRev}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := editor.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result, "    nixpkgs-0123456.ruby # nsm: ruby@3.1\n") {
+		t.Errorf("pinned package lacks its constraint:\n%s", result)
+	}
+}
@@ -0,0 +1,135 @@
+package unit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mdaashir/NSM/utils"
+	"github.com/spf13/viper"
+)
+
+// writeProjectFiles creates empty files below root
+func writeProjectFiles(t *testing.T, root string, files ...string) {
+	t.Helper()
+	for _, file := range files {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindProjectDir(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeProjectFiles(t, root,
+		"app/shell.nix",
+		"app/src/pkg/main.go",
+		"app/nested/flake.nix",
+		"app/nested/deep/file",
+		"app/repo/.git/HEAD",
+		"app/repo/src/file",
+		"legacy/default.nix",
+		"legacy/lib/file",
+	)
+
+	tests := []struct {
+		name    string
+		start   string
+		want    string
+		wantErr error
+	}{
+		{name: "project directory", start: "app", want: "app"},
+		{name: "below the project", start: "app/src/pkg", want: "app"},
+		{name: "nearest project wins", start: "app/nested/deep", want: "app/nested"},
+		{name: "default.nix project", start: "legacy/lib", want: "legacy"},
+		{name: "stops at the git root", start: "app/repo/src", wantErr: utils.ErrNoProjectConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := utils.FindProjectDir(filepath.Join(root, tt.start))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("FindProjectDir() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FindProjectDir() error = %v", err)
+			}
+			if want := filepath.Join(root, tt.want); got != want {
+				t.Errorf("FindProjectDir() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestProjectConfigPreference(t *testing.T) {
+	dir := t.TempDir()
+	writeProjectFiles(t, dir, "shell.nix", "flake.nix")
+	t.Chdir(dir)
+
+	if files := utils.ProjectConfigFiles(dir); len(files) != 2 {
+		t.Errorf("ProjectConfigFiles() = %v, want shell.nix and flake.nix", files)
+	}
+
+	oldFormat := viper.Get("shell.format")
+	defer viper.Set("shell.format", oldFormat)
+
+	viper.Set("shell.format", "flake.nix")
+	if got := utils.GetProjectConfigType(); got != "flake.nix" {
+		t.Errorf("GetProjectConfigType() with shell.format flake.nix = %q, want flake.nix", got)
+	}
+
+	viper.Set("shell.format", "shell.nix")
+	if got := utils.GetProjectConfigType(); got != "shell.nix" {
+		t.Errorf("GetProjectConfigType() with shell.format shell.nix = %q, want shell.nix", got)
+	}
+
+	// default.nix is used when shell.format asks for it or it is the only file
+	writeProjectFiles(t, dir, "default.nix")
+	viper.Set("shell.format", "default.nix")
+	if got := utils.GetProjectConfigType(); got != "default.nix" {
+		t.Errorf("GetProjectConfigType() with shell.format default.nix = %q, want default.nix", got)
+	}
+	for _, file := range []string{"shell.nix", "flake.nix"} {
+		if err := os.Remove(filepath.Join(dir, file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	viper.Set("shell.format", "shell.nix")
+	if got := utils.GetProjectConfigType(); got != "default.nix" {
+		t.Errorf("GetProjectConfigType() with only default.nix = %q, want default.nix", got)
+	}
+}
+
+func TestSetProjectFile(t *testing.T) {
+	dir := t.TempDir()
+	writeProjectFiles(t, dir, "shell.nix")
+	t.Chdir(dir)
+	defer utils.SetProjectFile("")
+
+	if err := utils.SetProjectFile("package.nix"); !errors.Is(err, utils.ErrValidationFailed) {
+		t.Errorf("SetProjectFile(package.nix) error = %v, want %v", err, utils.ErrValidationFailed)
+	}
+
+	if err := utils.SetProjectFile("flake.nix"); err != nil {
+		t.Fatalf("SetProjectFile(flake.nix) error = %v", err)
+	}
+	if got := utils.GetProjectConfigType(); got != "" {
+		t.Errorf("GetProjectConfigType() = %q, want none while flake.nix is missing", got)
+	}
+
+	writeProjectFiles(t, dir, "flake.nix")
+	if got := utils.GetProjectConfigType(); got != "flake.nix" {
+		t.Errorf("GetProjectConfigType() = %q, want flake.nix", got)
+	}
+}
@@ -0,0 +1,247 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdaashir/NSM/lockfile"
+	"github.com/mdaashir/NSM/tests/testutils"
+	"github.com/mdaashir/NSM/utils"
+)
+
+const snapshotFlakeLock = `{
+  "nodes": {
+    "nixpkgs": {
+      "locked": {
+        "lastModified": 1700000000,
+        "narHash": "sha256-LOCKED",
+        "owner": "NixOS",
+        "repo": "nixpkgs",
+        "rev": "` + revB + `",
+        "type": "github"
+      }
+    },
+    "root": {
+      "inputs": {
+        "nixpkgs": "nixpkgs"
+      }
+    }
+  },
+  "root": "root",
+  "version": 7
+}`
+
+func TestSnapshotShell(t *testing.T) {
+	useTempConfigDir(t)
+	dir := testutils.CreateTempDir(t)
+	defer os.RemoveAll(dir)
+
+	shellNix := filepath.Join(dir, "shell.nix")
+	src := `{ pkgs ? import <nixpkgs> {} }:
+pkgs.mkShell {
+  packages = with pkgs; [
+    gcc
+    (python3.withPackages (ps: [ ps.numpy ]))
+  ];
+}
+`
+	if err := os.WriteFile(shellNix, []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+	editor, _ := utils.NewNixEditor(src)
+	if err := editor.PinPackage("nodejs", utils.NixpkgsSource{Revision: revA, NarHash: "sha256-PINNED"}); err != nil {
+		t.Fatal(err)
+	}
+	pinned, _ := editor.Result()
+	if err := os.WriteFile(shellNix, []byte(pinned), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The version history knows the hash of the project's nixpkgs
+	db, _ := utils.LoadVersionDB()
+	db.Add(utils.VersionRecord{Attr: "gcc", Version: "13.2.0", Revision: revB, NarHash: "sha256-CHANNEL"})
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &testutils.FakeRunner{Outputs: map[string]string{
+		"nix-instantiate": `{"revision": "` + revB + `", "packages": {
+			"gcc": {"name": "gcc-wrapper-13.2.0", "version": "13.2.0", "outputs": {"out": "/nix/store/aaa-gcc-wrapper-13.2.0", "man": "/nix/store/bbb-gcc-wrapper-13.2.0-man"}},
+			"nodejs": {"name": "nodejs-18.17.1", "version": "18.17.1", "outputs": {"out": "/nix/store/ccc-nodejs-18.17.1"}}
+		}}`,
+		"nix": `{"/nix/store/aaa-gcc-wrapper-13.2.0": {"narHash": "sha256-GCC"}, "/nix/store/bbb-gcc-wrapper-13.2.0-man": null}`,
+	}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	snapshot, err := utils.SnapshotShell(context.Background(), shellNix, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshot.Nixpkgs != (utils.NixpkgsSource{Revision: revB, NarHash: "sha256-CHANNEL"}) {
+		t.Errorf("Nixpkgs = %+v", snapshot.Nixpkgs)
+	}
+	if !reflect.DeepEqual(snapshot.Unresolved, []string{"(python3.withPackages (ps: [ ps.numpy ]))"}) {
+		t.Errorf("Unresolved = %v", snapshot.Unresolved)
+	}
+	if len(snapshot.Packages) != 2 {
+		t.Fatalf("Packages = %+v", snapshot.Packages)
+	}
+
+	gcc := snapshot.Packages[0]
+	if gcc.Attr != "gcc" || gcc.Version != "13.2.0" || gcc.StorePath != "/nix/store/aaa-gcc-wrapper-13.2.0" {
+		t.Errorf("gcc = %+v", gcc)
+	}
+	if !reflect.DeepEqual(gcc.OutputHashes, map[string]string{"out": "sha256-GCC"}) {
+		t.Errorf("gcc output hashes = %v", gcc.OutputHashes)
+	}
+	if gcc.Nixpkgs.Revision != revB {
+		t.Errorf("gcc nixpkgs = %+v", gcc.Nixpkgs)
+	}
+
+	nodejs := snapshot.Packages[1]
+	if nodejs.Attr != "nodejs" || nodejs.Nixpkgs != (utils.NixpkgsSource{Revision: revA, NarHash: "sha256-PINNED"}) {
+		t.Errorf("nodejs = %+v", nodejs)
+	}
+}
+
+func TestReadFlakeLockInput(t *testing.T) {
+	dir := testutils.CreateTempDir(t)
+	defer os.RemoveAll(dir)
+
+	lockFile := filepath.Join(dir, "flake.lock")
+	if err := os.WriteFile(lockFile, []byte(snapshotFlakeLock), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := utils.ReadFlakeLockInput(lockFile, "nixpkgs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != (utils.NixpkgsSource{Revision: revB, NarHash: "sha256-LOCKED"}) {
+		t.Errorf("ReadFlakeLockInput() = %+v", source)
+	}
+
+	if _, err := utils.ReadFlakeLockInput(lockFile, "flake-utils"); err == nil {
+		t.Error("ReadFlakeLockInput() found an input that is not locked")
+	}
+	if _, err := utils.ReadFlakeLockInput(filepath.Join(dir, "missing.lock"), "nixpkgs"); !os.IsNotExist(err) {
+		t.Errorf("ReadFlakeLockInput() on a missing file = %v", err)
+	}
+}
+
+func TestGetNarHashesInvalidPath(t *testing.T) {
+	// This Nix fails the whole query when one of the paths is not valid
+	invalid := errors.New("path '/nix/store/bbb-jq' is not valid")
+	fake := &testutils.FakeRunner{
+		Outputs: map[string]string{
+			"nix path-info --json /nix/store/aaa-gcc":              `{"/nix/store/aaa-gcc": {"narHash": "sha256-GCC"}}`,
+			"nix path-info --json --store https://cache.nixos.org": `{}`,
+		},
+		Errors: map[string]error{
+			"nix path-info --json /nix/store/aaa-gcc /nix/store/bbb-jq": invalid,
+			"nix path-info --json /nix/store/bbb-jq":                    invalid,
+		},
+	}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	hashes := utils.GetNarHashes(context.Background(), []string{"/nix/store/aaa-gcc", "/nix/store/bbb-jq"})
+	if !reflect.DeepEqual(hashes, map[string]string{"/nix/store/aaa-gcc": "sha256-GCC"}) {
+		t.Errorf("GetNarHashes() = %v, want the hash of the valid path", hashes)
+	}
+}
+
+func TestNixpkgsNarHashes(t *testing.T) {
+	useTempConfigDir(t)
+	revC := strings.Repeat("c", 40)
+
+	db, _ := utils.LoadVersionDB()
+	db.Add(utils.VersionRecord{Attr: "gcc", Version: "13.2.0", Revision: revA, NarHash: "sha256-KNOWN"})
+	db.Add(utils.VersionRecord{Attr: "jq", Version: "1.7.1", Revision: revC})
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &testutils.FakeRunner{Outputs: map[string]string{
+		"nix": `{"hash": "sha256-FETCHED", "storePath": "/nix/store/ddd-source"}`,
+	}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	hashes := utils.NixpkgsNarHashes(context.Background(), []string{revA, revB, revB, revC, "nixos-unstable"})
+	expected := map[string]string{revA: "sha256-KNOWN", revB: "sha256-FETCHED", revC: "sha256-FETCHED"}
+	if !reflect.DeepEqual(hashes, expected) {
+		t.Errorf("NixpkgsNarHashes() = %v, want %v", hashes, expected)
+	}
+	// Known revisions are not fetched, and each other revision only once
+	if len(fake.Calls) != 2 {
+		t.Errorf("ran %d prefetches, want 2: %v", len(fake.Calls), fake.Calls)
+	}
+
+	// Fetched hashes are kept, also for revisions the version history did
+	// not know, so they are not fetched again
+	db, _ = utils.LoadVersionDB()
+	for _, revision := range []string{revB, revC} {
+		if db.Revisions[revision].NarHash != "sha256-FETCHED" {
+			t.Errorf("version history hash of %s = %q", revision, db.Revisions[revision].NarHash)
+		}
+	}
+	utils.NixpkgsNarHashes(context.Background(), []string{revB, revC})
+	if len(fake.Calls) != 2 {
+		t.Errorf("ran %d prefetches after the hashes were saved, want 2: %v", len(fake.Calls), fake.Calls)
+	}
+}
+
+func TestSnapshotShellNamedShell(t *testing.T) {
+	useTempConfigDir(t)
+	dir := testutils.CreateTempDir(t)
+	defer os.RemoveAll(dir)
+
+	flakeNix := filepath.Join(dir, "flake.nix")
+	if err := os.WriteFile(flakeNix, []byte(namedShellsFlake), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "flake.lock"), []byte(snapshotFlakeLock), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &testutils.FakeRunner{Outputs: map[string]string{
+		"nix eval": `{"revision": "` + revB + `", "packages": {
+			"go": {"name": "go-1.22.1", "version": "1.22.1", "outputs": {"out": "/nix/store/aaa-go-1.22.1"}},
+			"jq": {"name": "jq-1.7.1", "version": "1.7.1", "outputs": {"out": "/nix/store/bbb-jq-1.7.1"}},
+			"shellcheck": {"name": "shellcheck-0.9.0", "version": "0.9.0", "outputs": {"out": "/nix/store/ccc-shellcheck-0.9.0"}}
+		}}`,
+		"nix path-info": `{}`,
+	}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	snapshot, err := utils.SnapshotShell(context.Background(), flakeNix, "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Shell != "ci" {
+		t.Errorf("Shell = %q, want ci", snapshot.Shell)
+	}
+	var attrs []string
+	for _, pkg := range snapshot.Packages {
+		attrs = append(attrs, pkg.Attr)
+	}
+	if !reflect.DeepEqual(attrs, []string{"jq", "shellcheck"}) {
+		t.Errorf("Packages = %v, want the packages of the ci shell", attrs)
+	}
+	if lock := lockfile.FromSnapshot(snapshot, ""); lock.Shell != "ci" {
+		t.Errorf("lock shell = %q, want ci", lock.Shell)
+	}
+
+	if _, err := utils.SnapshotShell(context.Background(), flakeNix, "docs"); err == nil || !strings.Contains(err.Error(), `no shell named "docs"`) {
+		t.Errorf("SnapshotShell() of a missing shell error = %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package unit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mdaashir/NSM/utils"
+)
+
+func TestParseTableStyle(t *testing.T) {
+	for _, style := range utils.TableStyles {
+		if _, err := utils.ParseTableStyle(string(style)); err != nil {
+			t.Errorf("ParseTableStyle(%q) error = %v", style, err)
+		}
+	}
+	if _, err := utils.ParseTableStyle("fancy"); err == nil {
+		t.Error("ParseTableStyle(\"fancy\") succeeded, want error")
+	}
+}
+
+func TestRenderTable(t *testing.T) {
+	headers := []string{"Package", "Description"}
+	rows := [][]string{
+		{"jq", "JSON processor"},
+		{"日本", "wide, \"quoted\""},
+	}
+
+	tests := []struct {
+		style utils.TableStyle
+		want  string
+	}{
+		{
+			style: utils.TablePlain,
+			want: "Package | Description   \n" +
+				"--------+---------------\n" +
+				"jq      | JSON processor\n" +
+				"日本    | wide, \"quoted\"\n",
+		},
+		{
+			style: utils.TableMarkdown,
+			want: "| Package | Description |\n" +
+				"| --- | --- |\n" +
+				"| jq | JSON processor |\n" +
+				"| 日本 | wide, \"quoted\" |\n",
+		},
+		{
+			style: utils.TableCSV,
+			want: "Package,Description\n" +
+				"jq,JSON processor\n" +
+				"日本,\"wide, \"\"quoted\"\"\"\n",
+		},
+		{
+			style: utils.TableBox,
+			want: "┌─────────┬────────────────┐\n" +
+				"│ Package │ Description    │\n" +
+				"├─────────┼────────────────┤\n" +
+				"│ jq      │ JSON processor │\n" +
+				"│ 日本    │ wide, \"quoted\" │\n" +
+				"└─────────┴────────────────┘\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.style), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := utils.RenderTable(&buf, tt.style, 0, headers, rows); err != nil {
+				t.Fatalf("RenderTable() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("RenderTable() =\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTableFitsWidth(t *testing.T) {
+	headers := []string{"Package", "Description"}
+	rows := [][]string{
+		{"python3", "A high-level dynamically-typed programming language"},
+	}
+
+	for _, style := range []utils.TableStyle{utils.TablePlain, utils.TableBox} {
+		t.Run(string(style), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := utils.RenderTable(&buf, style, 40, headers, rows); err != nil {
+				t.Fatalf("RenderTable() error = %v", err)
+			}
+
+			for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+				if width := utils.DisplayWidth(line); width != 40 {
+					t.Errorf("line %q is %d columns wide, want 40", line, width)
+				}
+			}
+			if !strings.Contains(buf.String(), "python3") || !strings.Contains(buf.String(), "…") {
+				t.Errorf("expected the description to be truncated:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestMarkdownTable(t *testing.T) {
+	output := utils.MarkdownTable([]string{"Package", "Change"}, [][]string{
+		{"nodejs", "version-changed"},
+		{"a|b", "added"},
+	})
+
+	expected := "| Package | Change |\n" +
+		"| --- | --- |\n" +
+		"| nodejs | version-changed |\n" +
+		"| a\\|b | added |\n"
+	if output != expected {
+		t.Errorf("MarkdownTable() = %q, want %q", output, expected)
+	}
+}
@@ -0,0 +1,74 @@
+package unit
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mdaashir/NSM/utils"
+)
+
+func TestParseColorMode(t *testing.T) {
+	for _, value := range []string{"auto", "always", "never", "Never"} {
+		if _, err := utils.ParseColorMode(value); err != nil {
+			t.Errorf("ParseColorMode(%q) error = %v", value, err)
+		}
+	}
+	if _, err := utils.ParseColorMode("sometimes"); err == nil {
+		t.Error("ParseColorMode(\"sometimes\") succeeded, want error")
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	if utils.IsTerminal(&bytes.Buffer{}) {
+		t.Error("IsTerminal(buffer) = true, want false")
+	}
+	if width := utils.TerminalWidth(&bytes.Buffer{}); width != 0 {
+		t.Errorf("TerminalWidth(buffer) = %d, want 0", width)
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"", 0},
+		{"gcc", 3},
+		{"café", 4},
+		{"cafe\u0301", 4},
+		{"日本語", 6},
+		{"📦 pkgs", 7},
+		{"⚠️", 2},
+		{"✓ ok", 4},
+	}
+
+	for _, tt := range tests {
+		if got := utils.DisplayWidth(tt.input); got != tt.want {
+			t.Errorf("DisplayWidth(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateWidth(t *testing.T) {
+	tests := []struct {
+		input    string
+		maxWidth int
+		want     string
+	}{
+		{"python3", 10, "python3"},
+		{"python3", 7, "python3"},
+		{"python3", 5, "pyth…"},
+		{"日本語テキスト", 5, "日本…"},
+		{"abc", 0, ""},
+	}
+
+	for _, tt := range tests {
+		got := utils.TruncateWidth(tt.input, tt.maxWidth)
+		if got != tt.want {
+			t.Errorf("TruncateWidth(%q, %d) = %q, want %q", tt.input, tt.maxWidth, got, tt.want)
+		}
+		if utils.DisplayWidth(got) > tt.maxWidth {
+			t.Errorf("TruncateWidth(%q, %d) is %d columns wide", tt.input, tt.maxWidth, utils.DisplayWidth(got))
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdaashir/NSM/tests/testutils"
+	"github.com/mdaashir/NSM/utils"
+)
+
+const (
+	revA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	revB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+// useTempConfigDir points the NSM config directory at a temporary directory
+func useTempConfigDir(t *testing.T) {
+	t.Helper()
+	dir := testutils.CreateTempDir(t)
+	origXdgConfig := os.Getenv("XDG_CONFIG_HOME")
+	if err := os.Setenv("XDG_CONFIG_HOME", dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Setenv("XDG_CONFIG_HOME", origXdgConfig)
+		os.RemoveAll(dir)
+	})
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"18.17.1", "18.17.1", 0},
+		{"18.9.0", "18.17.1", -1},
+		{"3.11.9", "3.11", 1},
+		{"1.0.0-rc1", "1.0.0", 1},
+		{"2.0rc1", "2.0.1", -1},
+	}
+	for _, tt := range tests {
+		if got := utils.CompareVersions(tt.a, tt.b); got != tt.expected {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
+
+func TestVersionDB(t *testing.T) {
+	useTempConfigDir(t)
+
+	db, err := utils.LoadVersionDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := db.Add(
+		utils.VersionRecord{Attr: "nodejs", Version: "18.17.1", Revision: revA, NarHash: "sha256-A"},
+		utils.VersionRecord{Attr: "nodejs", Version: "18.9.0", Revision: revA},
+		utils.VersionRecord{Attr: "nodejs", Version: "18.17.1", Revision: revB},
+		utils.VersionRecord{Attr: "nodejs", Version: "20.11.1", Revision: "not-a-commit"},
+	)
+	if added != 2 {
+		t.Errorf("Add() = %d, want 2", added)
+	}
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = utils.LoadVersionDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []string
+	for _, record := range db.Versions("nodejs") {
+		versions = append(versions, record.Version)
+	}
+	if !reflect.DeepEqual(versions, []string{"18.17.1", "18.9.0"}) {
+		t.Errorf("Versions() = %v", versions)
+	}
+
+	// The first revision recorded for a version is kept
+	record, ok := db.Lookup("nodejs", "18.17.1")
+	if !ok || record.Revision != revA || record.NarHash != "sha256-A" {
+		t.Errorf("Lookup(18.17.1) = %+v, %v", record, ok)
+	}
+
+	// Prefixes resolve to the newest matching version
+	if record, ok := db.Lookup("nodejs", "18"); !ok || record.Version != "18.17.1" {
+		t.Errorf("Lookup(18) = %+v, %v", record, ok)
+	}
+	if _, ok := db.Lookup("nodejs", "16"); ok {
+		t.Error("Lookup(16) found a version that was never recorded")
+	}
+}
+
+func TestVersionDBImport(t *testing.T) {
+	useTempConfigDir(t)
+	db, _ := utils.LoadVersionDB()
+
+	list := `[{"attr": "python311", "version": "3.11.9", "revision": "` + revA + `"}]`
+	if added, err := db.Import(strings.NewReader(list)); err != nil || added != 1 {
+		t.Errorf("Import(list) = %d, %v", added, err)
+	}
+
+	object := `{"records": [{"attr": "python312", "version": "3.12.4", "revision": "` + revB + `", "narHash": "sha256-B"}]}`
+	if added, err := db.Import(strings.NewReader(object)); err != nil || added != 1 {
+		t.Errorf("Import(object) = %d, %v", added, err)
+	}
+
+	invalid := `[{"attr": "go", "version": "1.22.5", "revision": "main"}]`
+	if _, err := db.Import(strings.NewReader(invalid)); err == nil {
+		t.Error("Import() accepted a record without a commit hash")
+	}
+
+	if record, ok := db.Lookup("python312", "3.12"); !ok || record.NarHash != "sha256-B" {
+		t.Errorf("Lookup(python312) = %+v, %v", record, ok)
+	}
+}
+
+func TestResolveVersion(t *testing.T) {
+	useTempConfigDir(t)
+
+	db, _ := utils.LoadVersionDB()
+	db.Add(utils.VersionRecord{Attr: "nodejs", Version: "18.17.1", Revision: revA})
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &testutils.FakeRunner{Outputs: map[string]string{
+		"nix": `{"hash": "sha256-A", "storePath": "/nix/store/abc-source"}`,
+	}}
+	previous := utils.SetNixRunner(fake)
+	defer utils.SetNixRunner(previous)
+
+	record, err := utils.ResolveVersion(context.Background(), "nodejs", "18.17")
+	if err != nil {
+		t.Fatalf("ResolveVersion() error = %v", err)
+	}
+	want := utils.VersionRecord{Attr: "nodejs", Version: "18.17.1", Revision: revA, NarHash: "sha256-A"}
+	if record != want {
+		t.Errorf("ResolveVersion() = %+v, want %+v", record, want)
+	}
+
+	// The hash is remembered for the next lookup
+	db, _ = utils.LoadVersionDB()
+	if record, _ := db.Lookup("nodejs", "18.17.1"); record.NarHash != "sha256-A" {
+		t.Errorf("hash was not saved: %+v", record)
+	}
+}
+
+func TestPackageIndexFeedsVersionDB(t *testing.T) {
+	useTempConfigDir(t)
+
+	previous := utils.SetNixRunner(&testutils.FakeRunner{Outputs: map[string]string{
+		"nix-instantiate": `"` + revB + `"`,
+		"nix-env":         `{"jq": {"name": "jq-1.7.1", "pname": "jq", "version": "1.7.1"}}`,
+	}})
+	defer utils.SetNixRunner(previous)
+
+	if _, err := utils.LoadPackageIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := utils.LoadVersionDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record, ok := db.Lookup("jq", "1.7.1"); !ok || record.Revision != revB {
+		t.Errorf("indexed channel was not recorded: %+v, %v", record, ok)
+	}
+}
@@ -0,0 +1,74 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mdaashir/NSM/utils"
+)
+
+func TestForEach(t *testing.T) {
+	t.Run("bounded workers", func(t *testing.T) {
+		var running, peak int32
+		var mu sync.Mutex
+		seen := make(map[int]bool)
+
+		items := []int{1, 2, 3, 4, 5, 6, 7, 8}
+		err := utils.ForEach(context.Background(), 3, items, func(ctx context.Context, item int) error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+
+			mu.Lock()
+			seen[item] = true
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if peak > 3 {
+			t.Errorf("ran %d calls at once, want at most 3", peak)
+		}
+		if len(seen) != len(items) {
+			t.Errorf("called fn for %d items, want %d", len(seen), len(items))
+		}
+	})
+
+	t.Run("first error cancels", func(t *testing.T) {
+		failure := errors.New("evaluation failed")
+		var calls int32
+		items := make([]int, 100)
+		err := utils.ForEach(context.Background(), 2, items, func(ctx context.Context, item int) error {
+			atomic.AddInt32(&calls, 1)
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Errorf("ForEach() error = %v, want %v", err, failure)
+		}
+		if calls >= int32(len(items)) {
+			t.Errorf("ForEach() kept going after the first error (%d calls)", calls)
+		}
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := utils.ForEach(ctx, 2, []int{1, 2, 3}, func(ctx context.Context, item int) error {
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ForEach() error = %v, want context.Canceled", err)
+		}
+	})
+}
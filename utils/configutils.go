@@ -2,8 +2,10 @@
 package utils
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"slices"
+	"sort"
 
 	"github.com/spf13/viper"
 )
@@ -33,10 +35,10 @@ func ValidateConfig() []ConfigValidationError {
 
 	// Check a shell format
 	shellFormat := viper.GetString("shell.format")
-	if shellFormat != "shell.nix" && shellFormat != "flake.nix" {
+	if !slices.Contains(ConfigTypes, shellFormat) {
 		errors = append(errors, ConfigValidationError{
 			Key:     "shell.format",
-			Message: "shell format must be either 'shell.nix' or 'flake.nix'",
+			Message: "shell format must be 'shell.nix', 'flake.nix' or 'default.nix'",
 		})
 	}
 
@@ -61,37 +63,83 @@ func ValidateConfig() []ConfigValidationError {
 
 // Config represents the NSM configuration structure
 type Config struct {
+	// Pins maps pinned packages to their version
 	Pins map[string]string
+	// PinSources maps pinned packages to the nixpkgs snapshot providing their version
+	PinSources map[string]NixpkgsSource
+}
+
+// Pin is a package pinned to a version from a nixpkgs revision. Pins are
+// stored as a list because viper lowercases map keys, which would corrupt
+// attribute names such as python3Packages.numpy.
+type Pin struct {
+	Package  string `mapstructure:"package"`
+	Version  string `mapstructure:"version"`
+	Revision string `mapstructure:"revision"`
+	NarHash  string `mapstructure:"narhash"`
 }
 
 // LoadConfig loads and returns the NSM configuration
 func LoadConfig() (*Config, error) {
 	config := &Config{
-		Pins: make(map[string]string),
+		Pins:       make(map[string]string),
+		PinSources: make(map[string]NixpkgsSource),
 	}
 
-	// Get pins from viper
+	// Get pins from viper, starting with the older map format
 	if pins := viper.GetStringMapString("pins"); pins != nil {
 		config.Pins = pins
 	}
 
+	var pinned []Pin
+	if err := viper.UnmarshalKey("pinned", &pinned); err != nil {
+		return nil, fmt.Errorf("invalid pinned packages: %w", err)
+	}
+	for _, pin := range pinned {
+		config.Pins[pin.Package] = pin.Version
+		if pin.Revision != "" {
+			config.PinSources[pin.Package] = NixpkgsSource{Revision: pin.Revision, NarHash: pin.NarHash}
+		}
+	}
+
 	return config, nil
 }
 
+// PinList returns the pins of the configuration sorted by package
+func (c *Config) PinList() []Pin {
+	pins := make([]Pin, 0, len(c.Pins))
+	for pkg, version := range c.Pins {
+		source := c.PinSources[pkg]
+		pins = append(pins, Pin{Package: pkg, Version: version, Revision: source.Revision, NarHash: source.NarHash})
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i].Package < pins[j].Package })
+	return pins
+}
+
 // SaveConfig saves the NSM configuration
 func SaveConfig(config *Config) error {
 	// Set pins in a viper
 	if config.Pins != nil {
-		viper.Set("pins", config.Pins)
+		var pinned []map[string]string
+		for _, pin := range config.PinList() {
+			pinned = append(pinned, map[string]string{
+				"package":  pin.Package,
+				"version":  pin.Version,
+				"revision": pin.Revision,
+				"narhash":  pin.NarHash,
+			})
+		}
+		viper.Set("pinned", pinned)
+		viper.Set("pins", map[string]string{})
 	}
 
 	return viper.WriteConfig()
 }
 
 // GetConfigSummary returns a human-readable summary of the current configuration
-func GetConfigSummary() map[string]interface{} {
+func GetConfigSummary(ctx context.Context) map[string]interface{} {
 	// Import circular reference resolved by moving CheckNixInstallation call logic here
-	_, nixErr := exec.LookPath("nix-env")
+	_, nixErr := nixRunner.LookPath("nix-env")
 
 	return map[string]interface{}{
 		"channel.url":      viper.GetString("channel.url"),
@@ -99,7 +147,7 @@ func GetConfigSummary() map[string]interface{} {
 		"default.packages": viper.GetStringSlice("default.packages"),
 		"config_file":      viper.ConfigFileUsed(),
 		"environment":      viper.GetString("environment"),
-		"flakes_enabled":   CheckFlakeSupport(),
+		"flakes_enabled":   CheckFlakeSupport(ctx),
 		"nix_installed":    nixErr == nil,
 		"config_validated": len(ValidateConfig()) == 0,
 	}
@@ -149,7 +197,7 @@ func MigrateConfig() error {
 		if err != nil {
 			// If WriteConfig fails, try SafeWriteConfig
 			if err := viper.SafeWriteConfig(); err != nil {
-				return fmt.Errorf("failed to save migrated config: %v", err)
+				return fmt.Errorf("failed to save migrated config: %w", err)
 			}
 			Debug("Saved configuration using SafeWriteConfig fallback")
 		} else {
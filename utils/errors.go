@@ -0,0 +1,20 @@
+package utils
+
+import "errors"
+
+// Classes of failures. Errors returned by NSM wrap one of them when it
+// applies, so callers can tell them apart with errors.Is and nsm can exit
+// with a code for each class.
+var (
+	// ErrNixNotInstalled reports that the Nix commands cannot be found
+	ErrNixNotInstalled = errors.New("nix is not installed")
+	// ErrNoProjectConfig reports a project without shell.nix, flake.nix or default.nix
+	ErrNoProjectConfig = errors.New("no shell.nix, flake.nix or default.nix found")
+	// ErrInvalidPackage reports a package name that is malformed or not in nixpkgs
+	ErrInvalidPackage = errors.New("invalid package")
+	// ErrValidationFailed reports a shell, lock or installation that did not
+	// pass its checks
+	ErrValidationFailed = errors.New("validation failed")
+	// ErrExternalCommandFailed is matched by every *CommandError
+	ErrExternalCommandFailed = errors.New("external command failed")
+)
@@ -66,6 +66,32 @@ func BackupFile(filename string) error {
 	return os.WriteFile(filename+".backup", content, 0600)
 }
 
+// WriteFileAtomic writes data to a temporary file next to filename and
+// renames it into place, so an interrupted write never leaves a truncated file
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
 // EnsureConfigDir ensures the NSM config directory exists and returns its path
 func EnsureConfigDir() (string, error) {
 	home, err := os.UserHomeDir()
@@ -103,43 +129,59 @@ func ReadFile(filename string) (string, error) {
 }
 
 // GetProjectConfigType determines which type of Nix configuration file exists
-// Returns "shell.nix", "flake.nix", or "" if none found
+// Returns "shell.nix", "flake.nix", or "" if none found. The file chosen with
+// SetProjectFile is used when set; when both exist, the shell.format setting
+// picks one.
 func GetProjectConfigType() string {
-	if FileExists("shell.nix") {
-		return "shell.nix"
+	if projectFile != "" {
+		if FileExists(projectFile) {
+			return projectFile
+		}
+		return ""
 	}
-	if FileExists("flake.nix") {
-		return "flake.nix"
+	for _, configType := range preferredConfigTypes() {
+		if FileExists(configType) {
+			return configType
+		}
 	}
 	return ""
 }
 
-// PinPackage pins a package to a specific version
-func PinPackage() error {
+// PinPackage records a pin of pkg to version, provided by the nixpkgs snapshot source
+func PinPackage(pkg, version string, source NixpkgsSource) error {
 	// Get current configuration
 	config, err := LoadConfig()
 	if err != nil {
-		return fmt.Errorf("failed to load config: %v", err)
+		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	// Initialize pins map if needed
-	if config.Pins == nil {
-		config.Pins = make(map[string]string)
+	// Update the pin
+	config.Pins[pkg] = version
+	config.PinSources[pkg] = source
+
+	// Save the configuration
+	if err := SaveConfig(config); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
 	}
 
-	// Get the package version
-	version, err := GetPackageVersion("gcc")
+	return nil
+}
+
+// UnpinPackage removes the pin of pkg and reports whether it was pinned
+func UnpinPackage(pkg string) (bool, error) {
+	config, err := LoadConfig()
 	if err != nil {
-		return fmt.Errorf("failed to get package version: %v", err)
+		return false, fmt.Errorf("failed to load config: %w", err)
 	}
 
-	// Update the pin
-	config.Pins["gcc"] = version
+	if _, ok := config.Pins[pkg]; !ok {
+		return false, nil
+	}
+	delete(config.Pins, pkg)
+	delete(config.PinSources, pkg)
 
-	// Save the configuration
 	if err := SaveConfig(config); err != nil {
-		return fmt.Errorf("failed to save config: %v", err)
+		return false, fmt.Errorf("failed to save config: %w", err)
 	}
-
-	return nil
+	return true, nil
 }
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotInGit is returned for a file that does not exist at a git revision
+var ErrNotInGit = errors.New("file does not exist at this revision")
+
+// IsGitRevision reports whether ref names a commit of the git repository
+// the current directory belongs to
+func IsGitRevision(ref string) bool {
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return false
+	}
+	return exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}").Run() == nil
+}
+
+// GitShowFile returns the content of a file at a git revision. The path is
+// relative to the current directory.
+func GitShowFile(ref, path string) ([]byte, error) {
+	if !IsGitRevision(ref) {
+		return nil, fmt.Errorf("%q is not a git revision", ref)
+	}
+
+	object := ref + ":./" + filepath.ToSlash(path)
+	if exec.Command("git", "cat-file", "-e", object).Run() != nil {
+		return nil, ErrNotInGit
+	}
+	content, err := exec.Command("git", "show", object).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s at %s: %w", path, ref, err)
+	}
+	return content, nil
+}
+
+// CheckoutShell writes the shell definition of the project at a git revision
+// into dir and returns the path of its configuration file. The flake.lock of
+// a flake is written next to it.
+func CheckoutShell(ref, dir string) (string, error) {
+	configTypes := preferredConfigTypes()
+	if projectFile != "" {
+		configTypes = []string{projectFile}
+	}
+	for _, configType := range configTypes {
+		content, err := GitShowFile(ref, configType)
+		if errors.Is(err, ErrNotInGit) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		configFile := filepath.Join(dir, configType)
+		if err := os.WriteFile(configFile, content, 0600); err != nil {
+			return "", err
+		}
+		if configType == "flake.nix" {
+			lock, err := GitShowFile(ref, "flake.lock")
+			if err == nil {
+				err = os.WriteFile(filepath.Join(dir, "flake.lock"), lock, 0600)
+			}
+			if err != nil && !errors.Is(err, ErrNotInGit) {
+				return "", err
+			}
+		}
+		return configFile, nil
+	}
+	return "", fmt.Errorf("no shell.nix or flake.nix at %s", ref)
+}
@@ -1,14 +1,15 @@
 package utils
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
-)
-
-var (
-	debugEnabled bool
-	quietEnabled bool
+	"sync"
+	"time"
 )
 
 // LogLevel represents different logging levels
@@ -23,151 +24,312 @@ const (
 	LevelTip
 )
 
+// String returns the name of the level used in log records
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelSuccess:
+		return "success"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	case LevelTip:
+		return "tip"
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// LogFormat selects how log messages are written
+type LogFormat string
+
+const (
+	LogFormatText LogFormat = "text"
+	LogFormatJSON LogFormat = "json"
+)
+
+// ParseLogFormat validates the value of the --log-format flag
+func ParseLogFormat(value string) (LogFormat, error) {
+	switch format := LogFormat(strings.ToLower(value)); format {
+	case LogFormatText, LogFormatJSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid log format %q, must be text or json", value)
+}
+
+// logSink is the destination shared by a logger and the loggers derived
+// from it with With
+type logSink struct {
+	mu     sync.Mutex
+	debug  bool
+	quiet  bool
+	format LogFormat
+	// stderr keeps standard output free for command results
+	stderr bool
+	// file receives every message, debug included, when set
+	file io.Writer
+}
+
+// Logger writes leveled messages with key/value fields to the console and
+// to the log file
+type Logger struct {
+	sink   *logSink
+	fields []interface{}
+}
+
+var defaultLogger = &Logger{sink: &logSink{format: LogFormatText}}
+
+// DefaultLogger returns the logger used by the package level functions
+func DefaultLogger() *Logger {
+	return defaultLogger
+}
+
+// With returns a logger of the default logger that adds the key/value
+// pairs to every message
+func With(keyvals ...interface{}) *Logger {
+	return defaultLogger.With(keyvals...)
+}
+
+// With returns a logger that adds the key/value pairs to every message
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{sink: l.sink, fields: fields}
+}
+
 // ConfigureLogger sets up the logger with the given options
 func ConfigureLogger(debug, quiet bool) {
-	debugEnabled = debug
-	quietEnabled = quiet
+	sink := defaultLogger.sink
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.debug = debug
+	sink.quiet = quiet
+}
+
+// SetLogFormat selects the format of console and log file messages
+func SetLogFormat(format LogFormat) {
+	sink := defaultLogger.sink
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.format = format
 }
 
-// formatMessage formats a message with optional arguments and color
-func formatMessage(level LogLevel, format string, args ...interface{}) string {
+// SetLogFile sends every message, debug included, to w as well as the
+// console. A nil w stops writing to the log file.
+func SetLogFile(w io.Writer) {
+	sink := defaultLogger.sink
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.file = w
+}
+
+// LogToStderr writes all messages to standard error, so standard output
+// only carries the result of a command
+func LogToStderr(enabled bool) {
+	sink := defaultLogger.sink
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.stderr = enabled
+}
+
+// formatMessage formats a message with optional arguments, colored when
+// color is set
+func formatMessage(level LogLevel, color bool, format string, args ...interface{}) string {
 	var prefix string
-	var color string
+	var code string
 
 	switch level {
 	case LevelDebug:
 		prefix = "[DEBUG] "
-		color = "\033[36m" // Cyan
+		code = "\033[36m" // Cyan
 	case LevelInfo:
 		prefix = ""
-		color = "\033[0m" // Default
+		code = "\033[0m" // Default
 	case LevelSuccess:
 		prefix = "✓ "
-		color = "\033[32m" // Green
+		code = "\033[32m" // Green
 	case LevelWarn:
 		prefix = "⚠️ "
-		color = "\033[33m" // Yellow
+		code = "\033[33m" // Yellow
 	case LevelError:
 		prefix = "✗ "
-		color = "\033[31m" // Red
+		code = "\033[31m" // Red
 	case LevelTip:
 		prefix = "💡 "
-		color = "\033[35m" // Magenta
+		code = "\033[35m" // Magenta
 	}
 
 	message := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
 
-	// Check if we should use colors
-	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+	if !color {
 		return prefix + message
 	}
 
-	return color + prefix + message + "\033[0m"
+	return code + prefix + message + "\033[0m"
+}
+
+// formatFields renders key/value pairs as key=value, quoting values that
+// contain spaces. A key without a value gets the value (MISSING).
+func formatFields(keyvals []interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(keyvals); i += 2 {
+		key, value := fieldPair(keyvals, i)
+		text := fmt.Sprint(value)
+		if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+			text = strconv.Quote(text)
+		}
+		fmt.Fprintf(&b, " %s=%s", key, text)
+	}
+	return b.String()
+}
+
+// fieldPair returns the key and value starting at index i of keyvals
+func fieldPair(keyvals []interface{}, i int) (string, interface{}) {
+	key := fmt.Sprint(keyvals[i])
+	if i+1 >= len(keyvals) {
+		return key, "(MISSING)"
+	}
+	value := keyvals[i+1]
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	return key, value
 }
 
-// logMessage outputs a message to the appropriate destination
-func logMessage(level LogLevel, format string, args ...interface{}) {
+// jsonRecord renders a message as one line of JSON with its fields
+func jsonRecord(now time.Time, level LogLevel, message string, keyvals []interface{}) []byte {
+	var b bytes.Buffer
+	writeField := func(key string, value interface{}) {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded, _ = json.Marshal(fmt.Sprint(value))
+		}
+		encodedKey, _ := json.Marshal(key)
+		b.WriteByte(',')
+		b.Write(encodedKey)
+		b.WriteByte(':')
+		b.Write(encoded)
+	}
+
+	b.WriteString(`{"time":`)
+	encodedTime, _ := json.Marshal(now.Format(time.RFC3339Nano))
+	b.Write(encodedTime)
+	writeField("level", level.String())
+	writeField("msg", message)
+	for i := 0; i < len(keyvals); i += 2 {
+		writeField(fieldPair(keyvals, i))
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// textRecord renders a message as one line of the log file
+func textRecord(now time.Time, level LogLevel, message string, keyvals []interface{}) []byte {
+	line := fmt.Sprintf("%s %-7s %s%s\n", now.Format(time.RFC3339), strings.ToUpper(level.String()), message, formatFields(keyvals))
+	return []byte(line)
+}
+
+// log writes a message to the log file and, depending on the level and the
+// debug and quiet options, to the console
+func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
+	sink := l.sink
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	now := time.Now()
+	message := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+
+	if sink.file != nil {
+		record := textRecord(now, level, message, l.fields)
+		if sink.format == LogFormatJSON {
+			record = jsonRecord(now, level, message, l.fields)
+		}
+		// A broken log file must not break the command
+		_, _ = sink.file.Write(record)
+	}
+
 	// Skip debug messages unless debug is enabled
-	if level == LevelDebug && !debugEnabled {
+	if level == LevelDebug && !sink.debug {
 		return
 	}
 
 	// Skip non-error messages if quiet mode is enabled
-	if quietEnabled && level != LevelError {
+	if sink.quiet && level != LevelError {
 		return
 	}
 
-	message := formatMessage(level, format, args...)
+	var out io.Writer = os.Stdout
+	if level == LevelError || sink.stderr {
+		out = os.Stderr
+	}
 
-	// Write to the appropriate output
-	if level == LevelError {
-		_, err := fmt.Fprintln(os.Stderr, message)
-		if err != nil {
-			return
-		}
-	} else {
-		fmt.Println(message)
+	if sink.format == LogFormatJSON {
+		_, _ = out.Write(jsonRecord(now, level, message, l.fields))
+		return
 	}
+	_, _ = fmt.Fprintln(out, formatMessage(level, colorEnabled(out), "%s", message+formatFields(l.fields)))
 }
 
 // Debug logs a debug message
-func Debug(format string, args ...interface{}) {
-	logMessage(LevelDebug, format, args...)
+func (l *Logger) Debug(format string, args ...interface{}) {
+	l.log(LevelDebug, format, args...)
 }
 
 // Info logs an info message
-func Info(format string, args ...interface{}) {
-	logMessage(LevelInfo, format, args...)
+func (l *Logger) Info(format string, args ...interface{}) {
+	l.log(LevelInfo, format, args...)
 }
 
 // Success logs a success message
-func Success(format string, args ...interface{}) {
-	logMessage(LevelSuccess, format, args...)
+func (l *Logger) Success(format string, args ...interface{}) {
+	l.log(LevelSuccess, format, args...)
 }
 
 // Warn logs a warning message
-func Warn(format string, args ...interface{}) {
-	logMessage(LevelWarn, format, args...)
+func (l *Logger) Warn(format string, args ...interface{}) {
+	l.log(LevelWarn, format, args...)
 }
 
 // Error logs an error message
-func Error(format string, args ...interface{}) {
-	logMessage(LevelError, format, args...)
+func (l *Logger) Error(format string, args ...interface{}) {
+	l.log(LevelError, format, args...)
 }
 
 // Tip logs a tip/hint message
-func Tip(format string, args ...interface{}) {
-	logMessage(LevelTip, format, args...)
+func (l *Logger) Tip(format string, args ...interface{}) {
+	l.log(LevelTip, format, args...)
 }
 
-// Table formats and prints tabular data
-func Table(headers []string, rows [][]string) {
-	if len(rows) == 0 {
-		return
-	}
+// Debug logs a debug message
+func Debug(format string, args ...interface{}) {
+	defaultLogger.log(LevelDebug, format, args...)
+}
 
-	// Calculate column widths
-	widths := make([]int, len(headers))
-	for i, h := range headers {
-		widths[i] = len(h)
-	}
+// Info logs an info message
+func Info(format string, args ...interface{}) {
+	defaultLogger.log(LevelInfo, format, args...)
+}
 
-	for _, row := range rows {
-		for i, cell := range row {
-			if i < len(widths) && len(cell) > widths[i] {
-				widths[i] = len(cell)
-			}
-		}
-	}
+// Success logs a success message
+func Success(format string, args ...interface{}) {
+	defaultLogger.log(LevelSuccess, format, args...)
+}
 
-	// Print headers
-	for i, h := range headers {
-		if i > 0 {
-			fmt.Print(" | ")
-		}
-		fmt.Printf("%-*s", widths[i], h)
-	}
-	fmt.Println()
+// Warn logs a warning message
+func Warn(format string, args ...interface{}) {
+	defaultLogger.log(LevelWarn, format, args...)
+}
 
-	// Print separator
-	for i, w := range widths {
-		if i > 0 {
-			fmt.Print("-+-")
-		}
-		fmt.Print(strings.Repeat("-", w))
-	}
-	fmt.Println()
-
-	// Print rows
-	for _, row := range rows {
-		for i, cell := range row {
-			if i > 0 {
-				fmt.Print(" | ")
-			}
-			if i < len(widths) {
-				fmt.Printf("%-*s", widths[i], cell)
-			}
-		}
-		fmt.Println()
-	}
+// Error logs an error message
+func Error(format string, args ...interface{}) {
+	defaultLogger.log(LevelError, format, args...)
+}
+
+// Tip logs a tip/hint message
+func Tip(format string, args ...interface{}) {
+	defaultLogger.log(LevelTip, format, args...)
 }
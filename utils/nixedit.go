@@ -0,0 +1,495 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// constraintComment matches the comment recording the version constraint an
+// element was resolved from: "# nsm: python3@3.11" after an element on its own
+// line, or "/* nsm: python3@3.11 */" inside single-line lists
+var constraintComment = regexp.MustCompile(`^[ \t]*(?:#[ \t]*nsm:[ \t]*(\S+)[ \t]*(?:\r?\n|$)|/\*[ \t]*nsm:[ \t]*(\S+?)[ \t]*\*/)`)
+
+// NixEdit replaces the byte range [Start, End) of a Nix source with Text
+type NixEdit struct {
+	Start int
+	End   int
+	Text  string
+}
+
+// ApplyNixEdits applies non-overlapping edits to src. Everything outside the
+// edited ranges is left byte-identical. An insertion at the start of a
+// replaced range goes before the replacement.
+func ApplyNixEdits(src string, edits []NixEdit) (string, error) {
+	sorted := make([]NixEdit, len(edits))
+	copy(sorted, edits)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Start != sorted[j].Start {
+			return sorted[i].Start < sorted[j].Start
+		}
+		return sorted[i].End == sorted[i].Start && sorted[j].End != sorted[j].Start
+	})
+
+	var out strings.Builder
+	last := 0
+	for _, edit := range sorted {
+		if edit.Start < last || edit.End < edit.Start || edit.End > len(src) {
+			return "", fmt.Errorf("overlapping or out of range edit at offset %d", edit.Start)
+		}
+		out.WriteString(src[last:edit.Start])
+		out.WriteString(edit.Text)
+		last = edit.End
+	}
+	out.WriteString(src[last:])
+	return out.String(), nil
+}
+
+// NixEditor queues format-preserving edits to the mkShell package lists of a
+// Nix file. Edits refer to the source as it was parsed and are applied together
+// by Result.
+type NixEditor struct {
+	src   string
+	root  *NixNode
+	lists []NixPackageList
+	edits []NixEdit
+
+	// The shells of the source and the package lists of all of them, as
+	// lists may be restricted to one shell by SelectShell
+	shells   []NixShell
+	allLists []NixPackageList
+
+	// Pinned nixpkgs revisions to bind, sets that may have become unused and
+	// the elements that no longer refer to their pinned set
+	sets     []pinnedSet
+	dropSets map[string]bool
+	detached map[*NixNode]bool
+
+	// Elements queued for removal, which new elements are not placed after
+	removed map[*NixNode]bool
+
+	// Version constraints to record next to packages as they are added
+	annotations map[string]string
+}
+
+// listElement is an element to insert into a list, with an optional
+// version constraint comment
+type listElement struct {
+	text       string
+	constraint string
+}
+
+// inline renders the element for a list that keeps several elements per line
+func (el listElement) inline() string {
+	if el.constraint == "" {
+		return el.text
+	}
+	return el.text + " /* nsm: " + el.constraint + " */"
+}
+
+// line renders the element for a list with one element per line
+func (el listElement) line() string {
+	if el.constraint == "" {
+		return el.text
+	}
+	return el.text + " # nsm: " + el.constraint
+}
+
+// joinInline renders elements for a single line
+func joinInline(elements []listElement) string {
+	texts := make([]string, len(elements))
+	for i, el := range elements {
+		texts[i] = el.inline()
+	}
+	return strings.Join(texts, " ")
+}
+
+// NewNixEditor parses src and prepares it for editing
+func NewNixEditor(src string) (*NixEditor, error) {
+	root, err := ParseNix(src)
+	if err != nil {
+		return nil, err
+	}
+	lists := FindPackageLists(src, root)
+	return &NixEditor{
+		src:         src,
+		root:        root,
+		lists:       lists,
+		shells:      FindNixShells(src, root),
+		allLists:    lists,
+		dropSets:    make(map[string]bool),
+		detached:    make(map[*NixNode]bool),
+		removed:     make(map[*NixNode]bool),
+		annotations: make(map[string]string),
+	}, nil
+}
+
+// Root returns the syntax tree of the source being edited
+func (e *NixEditor) Root() *NixNode {
+	return e.root
+}
+
+// Shells returns the mkShell calls of the source
+func (e *NixEditor) Shells() []NixShell {
+	return e.shells
+}
+
+// ShellNames returns the names of the shells exposed under devShells
+func (e *NixEditor) ShellNames() []string {
+	var names []string
+	for _, shell := range e.shells {
+		if shell.Name != "" && !slices.Contains(names, shell.Name) {
+			names = append(names, shell.Name)
+		}
+	}
+	return names
+}
+
+// findShell returns the shell with the given name. The default shell falls
+// back to the first shell of the source, such as the mkShell of shell.nix.
+func (e *NixEditor) findShell(name string) (*NixShell, error) {
+	for i := range e.shells {
+		if e.shells[i].Name == name {
+			return &e.shells[i], nil
+		}
+	}
+	if name == DefaultShellName && len(e.shells) > 0 {
+		return &e.shells[0], nil
+	}
+	if names := e.ShellNames(); len(names) > 0 {
+		return nil, fmt.Errorf("no shell named %q, the shells are %s", name, strings.Join(names, ", "))
+	}
+	return nil, fmt.Errorf("no shell named %q, only flakes define named shells", name)
+}
+
+// SelectShell restricts the package lists that are read and edited to those
+// of the named shell, or of the default shell when name is empty. Sources
+// without a recognizable mkShell call have no shells to select from and
+// only accept the default shell.
+func (e *NixEditor) SelectShell(name string) error {
+	if name == "" {
+		name = DefaultShellName
+	}
+	if len(e.shells) == 0 && name == DefaultShellName {
+		return nil
+	}
+	shell, err := e.findShell(name)
+	if err != nil {
+		return err
+	}
+
+	var lists []NixPackageList
+	for _, list := range e.allLists {
+		if list.Shell == shell.Args {
+			lists = append(lists, list)
+		}
+	}
+	e.lists = lists
+	return nil
+}
+
+// PackageLists returns the package lists found in the source
+func (e *NixEditor) PackageLists() []NixPackageList {
+	return e.lists
+}
+
+// Packages returns the packages of every package list in source order
+func (e *NixEditor) Packages() []string {
+	var packages []string
+	for _, list := range e.lists {
+		for _, elem := range list.List.Children {
+			packages = append(packages, NixPackageName(e.src, list.Scope, elem))
+		}
+	}
+	return packages
+}
+
+// Annotate records the version constraint a package was resolved from, such
+// as python3@3.11 for python311. The constraint is written as an "nsm:"
+// comment next to the element when the package is added or pinned.
+func (e *NixEditor) Annotate(pkg, constraint string) {
+	e.annotations[pkg] = constraint
+}
+
+// Constraints returns the version constraints recorded next to the packages
+// of the package lists
+func (e *NixEditor) Constraints() map[string]string {
+	constraints := make(map[string]string)
+	for _, list := range e.lists {
+		for _, elem := range list.List.Children {
+			if m := constraintComment.FindStringSubmatch(e.src[elem.End:]); m != nil {
+				constraints[NixPackageName(e.src, list.Scope, elem)] = m[1] + m[2]
+			}
+		}
+	}
+	return constraints
+}
+
+// TargetList returns the list new packages are added to: the packages list of
+// the first mkShell call, falling back to buildInputs and then nativeBuildInputs
+func (e *NixEditor) TargetList() (*NixPackageList, error) {
+	if len(e.lists) == 0 {
+		return nil, fmt.Errorf("no package list found")
+	}
+
+	shell := e.lists[0].Shell
+	for _, attr := range []string{"packages", "buildInputs", "nativeBuildInputs"} {
+		for i := range e.lists {
+			if e.lists[i].Shell == shell && e.lists[i].Attr == attr {
+				return &e.lists[i], nil
+			}
+		}
+	}
+	return &e.lists[0], nil
+}
+
+// AddPackages appends packages to the target list, following the layout of
+// the existing elements
+func (e *NixEditor) AddPackages(packages []string) error {
+	if len(packages) == 0 {
+		return nil
+	}
+	target, err := e.TargetList()
+	if err != nil {
+		return err
+	}
+
+	prefix := e.elementPrefix(target)
+	elements := make([]listElement, len(packages))
+	for i, pkg := range packages {
+		elements[i] = listElement{text: prefix + pkg, constraint: e.annotations[pkg]}
+	}
+	e.edits = append(e.edits, e.insertIntoList(target.List, elements))
+	return nil
+}
+
+// elementPrefix returns the package set prefix shared by the elements of a
+// list without a with scope, such as "pkgs." in [ pkgs.gcc pkgs.go ]
+func (e *NixEditor) elementPrefix(list *NixPackageList) string {
+	if list.Scope != "" {
+		return ""
+	}
+
+	prefix := ""
+	seen := false
+	for _, elem := range list.List.Children {
+		if pinnedSetOf(e.src, elem) != "" {
+			continue
+		}
+		text := compactNixText(elem.Text(e.src))
+		name := NixPackageName(e.src, list.Scope, elem)
+		if text == name {
+			return ""
+		}
+		p := strings.TrimSuffix(text, name)
+		if seen && p != prefix {
+			return ""
+		}
+		prefix, seen = p, true
+	}
+	return prefix
+}
+
+// insertIntoList builds the edit that appends elements to a list node.
+// Elements queued for removal are skipped, so the insertion never falls
+// inside their removal.
+func (e *NixEditor) insertIntoList(list *NixNode, elements []listElement) NixEdit {
+	src := e.src
+	closing := list.End - 1
+
+	var children []*NixNode
+	for _, elem := range list.Children {
+		if !e.removed[elem] {
+			children = append(children, elem)
+		}
+	}
+
+	// Single-line lists grow inline: [ gcc ] -> [ gcc go ]
+	if !strings.Contains(src[list.Start:list.End], "\n") {
+		switch {
+		case len(list.Children) == 0:
+			return NixEdit{Start: list.Start + 1, End: closing, Text: " " + joinInline(elements) + " "}
+		case len(children) == 0:
+			return NixEdit{Start: list.Start + 1, End: list.Start + 1, Text: " " + joinInline(elements)}
+		}
+		end := e.elementEnd(children[len(children)-1])
+		return NixEdit{Start: end, End: end, Text: " " + joinInline(elements)}
+	}
+
+	// New lines end like the line that opens the list, so CRLF files stay
+	// CRLF
+	newline := lineBreak(src, list.Start)
+
+	// Every element is removed: take the place of the first one
+	if len(children) == 0 && len(list.Children) > 0 {
+		first := list.Children[0]
+		start := lineStart(src, first.Start)
+		if indent := src[start:first.Start]; strings.TrimSpace(indent) == "" {
+			var text strings.Builder
+			for _, elem := range elements {
+				text.WriteString(indent + elem.line() + newline)
+			}
+			return NixEdit{Start: start, End: start, Text: text.String()}
+		}
+	}
+
+	if len(children) == 0 {
+		indent := lineIndent(src, closing)
+		if strings.TrimSpace(src[lineStart(src, closing):closing]) != "" {
+			return NixEdit{Start: closing, End: closing, Text: " " + joinInline(elements) + " "}
+		}
+		indent += indentUnit(indent)
+		var text strings.Builder
+		for _, elem := range elements {
+			text.WriteString(indent + elem.line() + newline)
+		}
+		pos := lineStart(src, closing)
+		return NixEdit{Start: pos, End: pos, Text: text.String()}
+	}
+
+	last := children[len(children)-1]
+	start := lineStart(src, last.Start)
+
+	// Several elements per line: keep appending to the same line
+	if strings.TrimSpace(src[start:last.Start]) != "" {
+		end := e.elementEnd(last)
+		return NixEdit{Start: end, End: end, Text: " " + joinInline(elements)}
+	}
+
+	indent := src[start:last.Start]
+	var text strings.Builder
+	for _, elem := range elements {
+		text.WriteString(newline + indent + elem.line())
+	}
+
+	// Keep a trailing comment attached to the element it describes
+	pos := last.End
+	end := lineEnd(src, last.End)
+	if rest := strings.TrimSpace(src[last.End:end]); rest == "" || strings.HasPrefix(rest, "#") {
+		pos = len(strings.TrimRight(src[:end], "\r"))
+	}
+	return NixEdit{Start: pos, End: pos, Text: text.String()}
+}
+
+// RemovePackages queues the removal of every element of the package lists
+// whose package name is in names and returns the number of elements removed.
+// Elements that sit alone on their line are removed together with the line,
+// including a trailing comment.
+func (e *NixEditor) RemovePackages(names map[string]bool) int {
+	removed := 0
+	for _, list := range e.lists {
+		for _, elem := range list.List.Children {
+			if names[NixPackageName(e.src, list.Scope, elem)] {
+				e.edits = append(e.edits, e.removalEdit(elem))
+				e.removed[elem] = true
+				if set := pinnedSetOf(e.src, elem); set != "" {
+					e.dropSets[set] = true
+					e.detached[elem] = true
+				}
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// removalEdit builds the edit that deletes a list element
+func (e *NixEditor) removalEdit(elem *NixNode) NixEdit {
+	src := e.src
+	start := lineStart(src, elem.Start)
+	end := lineEnd(src, elem.End)
+	rest := strings.TrimSpace(src[elem.End:end])
+	if strings.TrimSpace(src[start:elem.Start]) == "" && (rest == "" || strings.HasPrefix(rest, "#")) {
+		if end < len(src) {
+			end++
+		}
+		return NixEdit{Start: start, End: end, Text: ""}
+	}
+
+	// Take an inline constraint comment and the following spaces along so no
+	// double spaces are left behind
+	end = e.elementEnd(elem)
+	for end < len(src) && (src[end] == ' ' || src[end] == '\t') {
+		end++
+	}
+	return NixEdit{Start: elem.Start, End: end, Text: ""}
+}
+
+// elementEnd returns the end of a list element including an inline
+// constraint comment
+func (e *NixEditor) elementEnd(elem *NixNode) int {
+	if m := constraintComment.FindStringSubmatchIndex(e.src[elem.End:]); m != nil && m[4] >= 0 {
+		return elem.End + m[1]
+	}
+	return elem.End
+}
+
+// Replace queues a replacement of the source covered by node
+func (e *NixEditor) Replace(node *NixNode, text string) {
+	e.edits = append(e.edits, NixEdit{Start: node.Start, End: node.End, Text: text})
+}
+
+// Changed reports whether any edits are queued
+func (e *NixEditor) Changed() bool {
+	return len(e.edits) > 0 || len(e.sets) > 0 || len(e.dropSets) > 0
+}
+
+// Result applies the queued edits and returns the new source
+func (e *NixEditor) Result() (string, error) {
+	edits := append([]NixEdit(nil), e.edits...)
+	setEdits, err := e.pinnedSetEdits()
+	if err != nil {
+		return "", err
+	}
+	return ApplyNixEdits(e.src, append(edits, setEdits...))
+}
+
+// lineStart returns the offset of the first byte of the line containing pos
+func lineStart(src string, pos int) int {
+	return strings.LastIndexByte(src[:pos], '\n') + 1
+}
+
+// lineEnd returns the offset of the newline ending the line containing pos,
+// or len(src) on the last line
+func lineEnd(src string, pos int) int {
+	if i := strings.IndexByte(src[pos:], '\n'); i >= 0 {
+		return pos + i
+	}
+	return len(src)
+}
+
+// lineBreak returns the terminator of the line containing pos, or the one
+// the source uses when that line is the last
+func lineBreak(src string, pos int) string {
+	end := lineEnd(src, pos)
+	if end == len(src) {
+		if strings.Contains(src, "\r\n") {
+			return "\r\n"
+		}
+		return "\n"
+	}
+	if end > 0 && src[end-1] == '\r' {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// lineIndent returns the leading whitespace of the line containing pos
+func lineIndent(src string, pos int) string {
+	start := lineStart(src, pos)
+	end := start
+	for end < len(src) && (src[end] == ' ' || src[end] == '\t') {
+		end++
+	}
+	return src[start:end]
+}
+
+// indentUnit guesses one level of indentation from an existing indent
+func indentUnit(indent string) string {
+	if strings.HasPrefix(indent, "\t") {
+		return "\t"
+	}
+	return "  "
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// QuoteNixString quotes s as a Nix string literal
+func QuoteNixString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// QuoteNixList renders values as a Nix list of strings
+func QuoteNixList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = QuoteNixString(v)
+	}
+	return "[ " + strings.Join(quoted, " ") + " ]"
+}
+
+// isFlake reports whether a project configuration file is a flake
+func isFlake(configFile string) bool {
+	return filepath.Base(configFile) == "flake.nix"
+}
+
+// ProjectNixpkgsExpr returns a Nix expression for the package set a project
+// builds against: the nixpkgs input of a flake, or the package set shell.nix
+// imports
+func ProjectNixpkgsExpr(configFile string) (string, error) {
+	if !isFlake(configFile) {
+		return shellNixpkgsExpr(configFile), nil
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(configFile))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`let flake = builtins.getFlake %s; in
+  if flake.inputs ? nixpkgs
+  then flake.inputs.nixpkgs.legacyPackages.${builtins.currentSystem}
+  else import <nixpkgs> {}`, QuoteNixString("path:"+dir)), nil
+}
+
+// shellNixpkgsExpr returns the package set of a shell.nix: the default of its
+// pkgs argument or the scope of a top-level "with import ...;". Anything else
+// falls back to <nixpkgs>.
+func shellNixpkgsExpr(configFile string) string {
+	const fallback = "import <nixpkgs> {}"
+	content, err := ReadFile(configFile)
+	if err != nil {
+		return fallback
+	}
+	root, err := ParseNix(content)
+	if err != nil {
+		return fallback
+	}
+
+	body := root
+	if root.Kind == NixLambda {
+		if formals := root.Children[0]; len(root.Children) == 2 && formals.Kind == NixFormals {
+			for _, formal := range formals.Children {
+				if formal.Value == "pkgs" && len(formal.Children) > 0 {
+					return formal.Children[0].Text(content)
+				}
+			}
+		}
+		body = root.Children[len(root.Children)-1]
+	}
+	if body.Kind == NixWith {
+		if scope := body.Children[0]; scope.Kind == NixApply && strings.HasPrefix(scope.Text(content), "import") {
+			return scope.Text(content)
+		}
+	}
+	return fallback
+}
+
+// EvalNixJSON evaluates a Nix expression strictly and decodes its JSON value
+// into v. Flake projects are evaluated with "nix eval", everything else with
+// nix-instantiate.
+func EvalNixJSON(ctx context.Context, configFile, expr string, v interface{}) error {
+	var output []byte
+	var err error
+	if isFlake(configFile) {
+		output, err = nixOutput(ctx, "nix", "eval", "--json", "--impure", "--expr", expr)
+	} else {
+		output, err = nixOutput(ctx, "nix-instantiate", "--eval", "--strict", "--json", "-E", expr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to evaluate nix expression: %w", err)
+	}
+
+	if err := json.Unmarshal(output, v); err != nil {
+		return fmt.Errorf("failed to parse evaluation result: %w", err)
+	}
+	return nil
+}
+
+// FindMissingPackages evaluates the project's nixpkgs and returns the
+// attribute paths in pkgs that it does not provide
+func FindMissingPackages(ctx context.Context, configFile string, pkgs []string) ([]string, error) {
+	if len(pkgs) == 0 {
+		return nil, nil
+	}
+
+	nixpkgs, err := ProjectNixpkgsExpr(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	expr := fmt.Sprintf(`let pkgs = %s; in
+  builtins.filter (p: !(pkgs.lib.hasAttrByPath (pkgs.lib.splitString "." p) pkgs)) %s`,
+		nixpkgs, QuoteNixList(pkgs))
+
+	var missing []string
+	if err := EvalNixJSON(ctx, configFile, expr, &missing); err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
@@ -0,0 +1,1143 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NixNodeKind identifies the type of a node in a Nix syntax tree
+type NixNodeKind int
+
+const (
+	// NixIdent is an identifier; Value holds its name
+	NixIdent NixNodeKind = iota
+	// NixInt is an integer literal; Value holds its source text
+	NixInt
+	// NixFloat is a floating point literal; Value holds its source text
+	NixFloat
+	// NixString is a string or indented string; Value holds the literal text
+	// with escapes resolved and Children holds NixInterpolation nodes
+	NixString
+	// NixPath is a path literal (./foo, /foo, ~/foo or <foo>); Value holds its
+	// source text and Children holds NixInterpolation nodes, as in ./foo/${bar}
+	NixPath
+	// NixURI is an unquoted URI literal; Value holds its source text
+	NixURI
+	// NixList is a list; Children holds its elements
+	NixList
+	// NixAttrSet is an attribute set; Children holds NixBinding and NixInherit nodes.
+	// Value is "rec" for recursive sets and "let" for the bindings of a let expression
+	NixAttrSet
+	// NixBinding is an "attrpath = value;" binding; Children is [NixAttrPath, value]
+	NixBinding
+	// NixInherit is an inherit statement; Children holds the inherited names,
+	// preceded by a NixParen node for "inherit (expr) ..."
+	NixInherit
+	// NixAttrPath is a dotted attribute path; Children holds NixIdent,
+	// NixString and NixInterpolation nodes
+	NixAttrPath
+	// NixSelect is an attribute selection; Children is [expr, NixAttrPath] or
+	// [expr, NixAttrPath, default] for "expr.path or default"
+	NixSelect
+	// NixHasAttr is an "expr ? attrpath" test; Children is [expr, NixAttrPath]
+	NixHasAttr
+	// NixApply is a function application; Children is [function, argument]
+	NixApply
+	// NixLambda is a function; Value holds the argument name (if any) and
+	// Children is [body] or [NixFormals, body]
+	NixLambda
+	// NixFormals is a "{ a, b ? x, ... }" argument pattern; Children holds
+	// NixFormal nodes and Value is "..." when the pattern has an ellipsis
+	NixFormals
+	// NixFormal is a single formal argument; Value holds its name and
+	// Children holds the default value, if any
+	NixFormal
+	// NixLet is a let expression; Children is [NixAttrSet, body]
+	NixLet
+	// NixWith is a with expression; Children is [scope, body]
+	NixWith
+	// NixAssert is an assert expression; Children is [condition, body]
+	NixAssert
+	// NixIf is a conditional; Children is [condition, then, else]
+	NixIf
+	// NixBinaryOp is a binary operation; Value holds the operator and Children is [left, right]
+	NixBinaryOp
+	// NixUnaryOp is a unary operation; Value holds the operator and Children is [operand]
+	NixUnaryOp
+	// NixParen is a parenthesized expression; Children is [expr]
+	NixParen
+	// NixInterpolation is a "${expr}" interpolation; Children is [expr]
+	NixInterpolation
+)
+
+var nixNodeKindNames = [...]string{
+	"Ident", "Int", "Float", "String", "Path", "URI", "List", "AttrSet",
+	"Binding", "Inherit", "AttrPath", "Select", "HasAttr", "Apply", "Lambda",
+	"Formals", "Formal", "Let", "With", "Assert", "If", "BinaryOp", "UnaryOp",
+	"Paren", "Interpolation",
+}
+
+func (k NixNodeKind) String() string {
+	if int(k) < len(nixNodeKindNames) {
+		return nixNodeKindNames[k]
+	}
+	return fmt.Sprintf("NixNodeKind(%d)", int(k))
+}
+
+// NixNode is a node of a Nix syntax tree. Start and End are byte offsets
+// into the parsed source, so the exact text of any node can be recovered
+// and edited in place.
+type NixNode struct {
+	Kind     NixNodeKind
+	Start    int
+	End      int
+	Value    string
+	Children []*NixNode
+}
+
+// Text returns the source text covered by the node
+func (n *NixNode) Text(src string) string {
+	return src[n.Start:n.End]
+}
+
+// WalkNix visits n and its descendants in source order. Children of a node
+// are skipped when fn returns false for it.
+func WalkNix(n *NixNode, fn func(n *NixNode) bool) {
+	if n == nil || !fn(n) {
+		return
+	}
+	for _, child := range n.Children {
+		WalkNix(child, fn)
+	}
+}
+
+// NixParseError describes a syntax error in a Nix expression
+type NixParseError struct {
+	Offset  int
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *NixParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// NixPosition converts a byte offset in src into a 1-based line and column
+func NixPosition(src string, offset int) (line, col int) {
+	if offset > len(src) {
+		offset = len(src)
+	}
+	line = 1 + strings.Count(src[:offset], "\n")
+	col = offset - strings.LastIndex(src[:offset], "\n")
+	return line, col
+}
+
+// ParseNix parses a Nix expression and returns its syntax tree
+func ParseNix(src string) (root *NixNode, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pe, ok := r.(*NixParseError)
+			if !ok {
+				panic(r)
+			}
+			root, err = nil, pe
+		}
+	}()
+
+	p := newNixParser(src, 0, len(src))
+	root = p.parseExpr()
+	p.expectEOF()
+	return root, nil
+}
+
+// nixSyntaxError aborts parsing with an error located at offset
+func nixSyntaxError(src string, offset int, format string, args ...interface{}) {
+	line, col := NixPosition(src, offset)
+	panic(&NixParseError{
+		Offset:  offset,
+		Line:    line,
+		Column:  col,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+type nixTokenKind int
+
+const (
+	tokEOF nixTokenKind = iota
+	tokID
+	tokInt
+	tokFloat
+	tokPath
+	tokURI
+	tokString
+	tokInterpStart
+	tokOp
+)
+
+// nixStringPart is either literal text or the span of an interpolated expression
+type nixStringPart struct {
+	text   string
+	start  int
+	end    int
+	interp bool
+}
+
+type nixToken struct {
+	kind  nixTokenKind
+	text  string
+	start int
+	end   int
+	parts []nixStringPart
+}
+
+func (t nixToken) isOp(op string) bool {
+	return t.kind == tokOp && t.text == op
+}
+
+func (t nixToken) isKeyword(kw string) bool {
+	return t.kind == tokID && t.text == kw
+}
+
+func (t nixToken) describe() string {
+	if t.kind == tokEOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+var nixKeywords = map[string]bool{
+	"if": true, "then": true, "else": true, "assert": true, "with": true,
+	"let": true, "in": true, "rec": true, "inherit": true,
+}
+
+// nixTokenRules mirror the lexer rules of the Nix grammar. The longest match
+// wins and earlier rules win ties.
+var nixTokenRules = []struct {
+	kind nixTokenKind
+	re   *regexp.Regexp
+}{
+	{tokID, regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_'\-]*`)},
+	{tokInt, regexp.MustCompile(`^[0-9]+`)},
+	{tokFloat, regexp.MustCompile(`^(([1-9][0-9]*\.[0-9]*)|(0?\.[0-9]+))([Ee][+-]?[0-9]+)?`)},
+	{tokPath, regexp.MustCompile(`^[a-zA-Z0-9._\-+]*(/[a-zA-Z0-9._\-+]+)+`)},
+	{tokPath, regexp.MustCompile(`^~(/[a-zA-Z0-9._\-+]+)+`)},
+	{tokPath, regexp.MustCompile(`^<[a-zA-Z0-9._\-+]+(/[a-zA-Z0-9._\-+]+)*>`)},
+	{tokURI, regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+\-.]*:[a-zA-Z0-9%/?:@&=+$,\-_.!~*']+`)},
+}
+
+// nixPathStart matches the literal start of a path that continues with an
+// interpolation, such as ./foo/ in ./foo/${bar}
+var nixPathStart = regexp.MustCompile(`^(~|[a-zA-Z0-9._\-+]*)(/[a-zA-Z0-9._\-+]+)*/`)
+
+// nixPathChars matches the literal text between the interpolations of a path
+var nixPathChars = regexp.MustCompile(`^[a-zA-Z0-9._\-+/]+`)
+
+var nixOperators = []string{
+	"...", "==", "!=", "<=", ">=", "&&", "||", "->", "//", "++",
+	"{", "}", "[", "]", "(", ")", ";", ":", "=", ",", ".", "@", "?",
+	"<", ">", "+", "-", "*", "/", "!",
+}
+
+type nixLexer struct {
+	src   string
+	pos   int
+	limit int
+}
+
+func (l *nixLexer) hasPrefix(s string) bool {
+	return strings.HasPrefix(l.src[l.pos:l.limit], s)
+}
+
+// skipSpace skips whitespace and comments
+func (l *nixLexer) skipSpace() {
+	for l.pos < l.limit {
+		switch c := l.src[l.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			l.pos++
+		case c == '#':
+			if i := strings.IndexByte(l.src[l.pos:l.limit], '\n'); i >= 0 {
+				l.pos += i + 1
+			} else {
+				l.pos = l.limit
+			}
+		case l.hasPrefix("/*"):
+			i := strings.Index(l.src[l.pos+2:l.limit], "*/")
+			if i < 0 {
+				nixSyntaxError(l.src, l.pos, "unterminated comment")
+			}
+			l.pos += i + 4
+		default:
+			return
+		}
+	}
+}
+
+func (l *nixLexer) next() nixToken {
+	l.skipSpace()
+	if l.pos >= l.limit {
+		return nixToken{kind: tokEOF, start: l.limit, end: l.limit}
+	}
+
+	switch {
+	case l.src[l.pos] == '"':
+		return l.lexString()
+	case l.hasPrefix("''"):
+		return l.lexIndString()
+	case l.hasPrefix("${"):
+		start := l.pos
+		l.pos += 2
+		return nixToken{kind: tokInterpStart, text: "${", start: start, end: l.pos}
+	}
+	if t, ok := l.lexInterpolatedPath(); ok {
+		return t
+	}
+
+	rest := l.src[l.pos:l.limit]
+	best, kind := "", tokEOF
+	for _, rule := range nixTokenRules {
+		if m := rule.re.FindString(rest); len(m) > len(best) {
+			best, kind = m, rule.kind
+		}
+	}
+	if best == "" {
+		for _, op := range nixOperators {
+			if strings.HasPrefix(rest, op) {
+				best, kind = op, tokOp
+				break
+			}
+		}
+	}
+	if best == "" {
+		nixSyntaxError(l.src, l.pos, "unexpected character %q", l.src[l.pos])
+	}
+
+	start := l.pos
+	l.pos += len(best)
+	return nixToken{kind: kind, text: best, start: start, end: l.pos}
+}
+
+// skipInterpolation finds the closing brace of an interpolation whose
+// expression starts at from and returns its offset
+func (l *nixLexer) skipInterpolation(from int) int {
+	sub := &nixLexer{src: l.src, pos: from, limit: l.limit}
+	depth := 1
+	for {
+		t := sub.next()
+		switch {
+		case t.kind == tokEOF:
+			nixSyntaxError(l.src, from-2, "unterminated interpolation")
+		case t.kind == tokInterpStart || t.isOp("{"):
+			depth++
+		case t.isOp("}"):
+			depth--
+			if depth == 0 {
+				return t.start
+			}
+		}
+	}
+}
+
+// lexInterpolatedPath lexes a path with interpolations, such as
+// ./foo/${bar}.nix, into parts like an interpolated string
+func (l *nixLexer) lexInterpolatedPath() (nixToken, bool) {
+	literal := nixPathStart.FindString(l.src[l.pos:l.limit])
+	if literal == "" || !strings.HasPrefix(l.src[l.pos+len(literal):l.limit], "${") {
+		return nixToken{}, false
+	}
+
+	start := l.pos
+	l.pos += len(literal)
+	parts := []nixStringPart{{text: literal}}
+	for {
+		rest := l.src[l.pos:l.limit]
+		if strings.HasPrefix(rest, "${") {
+			exprStart := l.pos + 2
+			exprEnd := l.skipInterpolation(exprStart)
+			parts = append(parts, nixStringPart{start: exprStart, end: exprEnd, interp: true})
+			l.pos = exprEnd + 1
+			continue
+		}
+		text := nixPathChars.FindString(rest)
+		if text == "" {
+			return nixToken{kind: tokPath, text: l.src[start:l.pos], start: start, end: l.pos, parts: parts}, true
+		}
+		parts = append(parts, nixStringPart{text: text})
+		l.pos += len(text)
+	}
+}
+
+func (l *nixLexer) lexString() nixToken {
+	start := l.pos
+	l.pos++
+
+	var parts []nixStringPart
+	var buf strings.Builder
+	flush := func() {
+		if buf.Len() > 0 {
+			parts = append(parts, nixStringPart{text: buf.String()})
+			buf.Reset()
+		}
+	}
+
+	for {
+		if l.pos >= l.limit {
+			nixSyntaxError(l.src, start, "unterminated string")
+		}
+		switch c := l.src[l.pos]; {
+		case c == '"':
+			l.pos++
+			flush()
+			return nixToken{kind: tokString, text: l.src[start:l.pos], start: start, end: l.pos, parts: parts}
+		case c == '\\' && l.pos+1 < l.limit:
+			buf.WriteString(unescapeNixChar(l.src[l.pos+1]))
+			l.pos += 2
+		case l.hasPrefix("$$"):
+			buf.WriteString("$$")
+			l.pos += 2
+		case l.hasPrefix("${"):
+			flush()
+			exprStart := l.pos + 2
+			exprEnd := l.skipInterpolation(exprStart)
+			parts = append(parts, nixStringPart{start: exprStart, end: exprEnd, interp: true})
+			l.pos = exprEnd + 1
+		default:
+			buf.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+func (l *nixLexer) lexIndString() nixToken {
+	start := l.pos
+	l.pos += 2
+
+	var parts []nixStringPart
+	var buf strings.Builder
+	flush := func() {
+		if buf.Len() > 0 {
+			parts = append(parts, nixStringPart{text: buf.String()})
+			buf.Reset()
+		}
+	}
+
+	for {
+		if l.pos >= l.limit {
+			nixSyntaxError(l.src, start, "unterminated indented string")
+		}
+		switch {
+		case l.hasPrefix("'''"):
+			buf.WriteString("''")
+			l.pos += 3
+		case l.hasPrefix("''$"):
+			buf.WriteString("$")
+			l.pos += 3
+		case l.hasPrefix("''\\") && l.pos+3 < l.limit:
+			buf.WriteString(unescapeNixChar(l.src[l.pos+3]))
+			l.pos += 4
+		case l.hasPrefix("''"):
+			l.pos += 2
+			flush()
+			return nixToken{kind: tokString, text: l.src[start:l.pos], start: start, end: l.pos, parts: parts}
+		case l.hasPrefix("$$"):
+			buf.WriteString("$$")
+			l.pos += 2
+		case l.hasPrefix("${"):
+			flush()
+			exprStart := l.pos + 2
+			exprEnd := l.skipInterpolation(exprStart)
+			parts = append(parts, nixStringPart{start: exprStart, end: exprEnd, interp: true})
+			l.pos = exprEnd + 1
+		default:
+			buf.WriteByte(l.src[l.pos])
+			l.pos++
+		}
+	}
+}
+
+func unescapeNixChar(c byte) string {
+	switch c {
+	case 'n':
+		return "\n"
+	case 't':
+		return "\t"
+	case 'r':
+		return "\r"
+	default:
+		return string(c)
+	}
+}
+
+type nixParser struct {
+	src  string
+	toks []nixToken
+	i    int
+}
+
+// newNixParser tokenizes src[start:end] up front so the parser can backtrack freely
+func newNixParser(src string, start, end int) *nixParser {
+	l := &nixLexer{src: src, pos: start, limit: end}
+	var toks []nixToken
+	for {
+		t := l.next()
+		toks = append(toks, t)
+		if t.kind == tokEOF {
+			break
+		}
+	}
+	return &nixParser{src: src, toks: toks}
+}
+
+func (p *nixParser) peek() nixToken {
+	return p.peekAt(0)
+}
+
+func (p *nixParser) peekAt(n int) nixToken {
+	if p.i+n < len(p.toks) {
+		return p.toks[p.i+n]
+	}
+	return p.toks[len(p.toks)-1]
+}
+
+func (p *nixParser) advance() nixToken {
+	t := p.peek()
+	if p.i < len(p.toks)-1 {
+		p.i++
+	}
+	return t
+}
+
+func (p *nixParser) errorf(t nixToken, format string, args ...interface{}) {
+	nixSyntaxError(p.src, t.start, format, args...)
+}
+
+func (p *nixParser) expectOp(op string) nixToken {
+	t := p.peek()
+	if !t.isOp(op) {
+		p.errorf(t, "expected %q, found %s", op, t.describe())
+	}
+	return p.advance()
+}
+
+func (p *nixParser) expectKeyword(kw string) nixToken {
+	t := p.peek()
+	if !t.isKeyword(kw) {
+		p.errorf(t, "expected %q, found %s", kw, t.describe())
+	}
+	return p.advance()
+}
+
+func (p *nixParser) expectEOF() {
+	if t := p.peek(); t.kind != tokEOF {
+		p.errorf(t, "unexpected %s", t.describe())
+	}
+}
+
+func (p *nixParser) parseExpr() *NixNode {
+	t := p.peek()
+	switch {
+	case t.isKeyword("let") && !p.peekAt(1).isOp("{"):
+		return p.parseLet()
+	case t.isKeyword("with"):
+		p.advance()
+		scope := p.parseExpr()
+		p.expectOp(";")
+		body := p.parseExpr()
+		return &NixNode{Kind: NixWith, Start: t.start, End: body.End, Children: []*NixNode{scope, body}}
+	case t.isKeyword("assert"):
+		p.advance()
+		cond := p.parseExpr()
+		p.expectOp(";")
+		body := p.parseExpr()
+		return &NixNode{Kind: NixAssert, Start: t.start, End: body.End, Children: []*NixNode{cond, body}}
+	case t.isKeyword("if"):
+		p.advance()
+		cond := p.parseExpr()
+		p.expectKeyword("then")
+		then := p.parseExpr()
+		p.expectKeyword("else")
+		els := p.parseExpr()
+		return &NixNode{Kind: NixIf, Start: t.start, End: els.End, Children: []*NixNode{cond, then, els}}
+	case t.kind == tokID && !nixKeywords[t.text] && (p.peekAt(1).isOp(":") || p.peekAt(1).isOp("@")):
+		return p.parseLambda()
+	case t.isOp("{"):
+		if lambda := p.tryFormalsLambda(); lambda != nil {
+			return lambda
+		}
+	}
+	return p.parseImpl()
+}
+
+func (p *nixParser) parseLet() *NixNode {
+	letTok := p.advance()
+	binds := p.parseBinds(func(t nixToken) bool { return t.isKeyword("in") })
+	inTok := p.expectKeyword("in")
+	body := p.parseExpr()
+	set := &NixNode{Kind: NixAttrSet, Value: "let", Start: letTok.end, End: inTok.start, Children: binds}
+	return &NixNode{Kind: NixLet, Start: letTok.start, End: body.End, Children: []*NixNode{set, body}}
+}
+
+// parseLambda parses "x: body" and "x @ { formals }: body"
+func (p *nixParser) parseLambda() *NixNode {
+	arg := p.advance()
+	lambda := &NixNode{Kind: NixLambda, Value: arg.text, Start: arg.start}
+	if p.peek().isOp("@") {
+		p.advance()
+		lambda.Children = append(lambda.Children, p.parseFormals())
+	}
+	p.expectOp(":")
+	body := p.parseExpr()
+	lambda.Children = append(lambda.Children, body)
+	lambda.End = body.End
+	return lambda
+}
+
+// tryFormalsLambda parses "{ formals }: body" and "{ formals } @ x: body",
+// restoring the parser position when the braces turn out to be an attribute set
+func (p *nixParser) tryFormalsLambda() (lambda *NixNode) {
+	saved := p.i
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(*NixParseError); !ok {
+				panic(r)
+			}
+			p.i = saved
+			lambda = nil
+		}
+	}()
+
+	formals := p.parseFormals()
+	lambda = &NixNode{Kind: NixLambda, Start: formals.Start}
+	if p.peek().isOp("@") {
+		p.advance()
+		arg := p.advance()
+		if arg.kind != tokID || nixKeywords[arg.text] {
+			p.errorf(arg, "expected identifier, found %s", arg.describe())
+		}
+		lambda.Value = arg.text
+	}
+	if !p.peek().isOp(":") {
+		p.i = saved
+		return nil
+	}
+	p.advance()
+	body := p.parseExpr()
+	lambda.Children = []*NixNode{formals, body}
+	lambda.End = body.End
+	return lambda
+}
+
+func (p *nixParser) parseFormals() *NixNode {
+	open := p.expectOp("{")
+	formals := &NixNode{Kind: NixFormals, Start: open.start}
+	for {
+		t := p.peek()
+		if t.isOp("}") {
+			break
+		}
+		if t.isOp("...") {
+			p.advance()
+			formals.Value = "..."
+			break
+		}
+		if t.kind != tokID || nixKeywords[t.text] {
+			p.errorf(t, "expected formal argument, found %s", t.describe())
+		}
+		p.advance()
+		formal := &NixNode{Kind: NixFormal, Value: t.text, Start: t.start, End: t.end}
+		if p.peek().isOp("?") {
+			p.advance()
+			def := p.parseExpr()
+			formal.Children = []*NixNode{def}
+			formal.End = def.End
+		}
+		formals.Children = append(formals.Children, formal)
+		if !p.peek().isOp(",") {
+			break
+		}
+		p.advance()
+	}
+	formals.End = p.expectOp("}").end
+	return formals
+}
+
+// parseBinds parses bindings and inherit statements until done reports true
+func (p *nixParser) parseBinds(done func(nixToken) bool) []*NixNode {
+	var binds []*NixNode
+	for {
+		t := p.peek()
+		if done(t) {
+			return binds
+		}
+		if t.kind == tokEOF {
+			p.errorf(t, "unexpected end of input")
+		}
+		if t.isKeyword("inherit") {
+			binds = append(binds, p.parseInherit())
+			continue
+		}
+		path := p.parseAttrPath()
+		p.expectOp("=")
+		value := p.parseExpr()
+		semi := p.expectOp(";")
+		binds = append(binds, &NixNode{Kind: NixBinding, Start: path.Start, End: semi.end, Children: []*NixNode{path, value}})
+	}
+}
+
+func (p *nixParser) parseInherit() *NixNode {
+	kw := p.advance()
+	inherit := &NixNode{Kind: NixInherit, Start: kw.start}
+	if p.peek().isOp("(") {
+		open := p.advance()
+		from := p.parseExpr()
+		closing := p.expectOp(")")
+		inherit.Children = append(inherit.Children, &NixNode{Kind: NixParen, Start: open.start, End: closing.end, Children: []*NixNode{from}})
+	}
+	for !p.peek().isOp(";") {
+		inherit.Children = append(inherit.Children, p.parseAttrName())
+	}
+	inherit.End = p.advance().end
+	return inherit
+}
+
+func (p *nixParser) parseAttrPath() *NixNode {
+	first := p.parseAttrName()
+	path := &NixNode{Kind: NixAttrPath, Start: first.Start, End: first.End, Children: []*NixNode{first}}
+	for p.peek().isOp(".") {
+		p.advance()
+		name := p.parseAttrName()
+		path.Children = append(path.Children, name)
+		path.End = name.End
+	}
+	return path
+}
+
+func (p *nixParser) parseAttrName() *NixNode {
+	t := p.peek()
+	switch {
+	case t.kind == tokID && (!nixKeywords[t.text]):
+		p.advance()
+		return &NixNode{Kind: NixIdent, Value: t.text, Start: t.start, End: t.end}
+	case t.kind == tokString:
+		p.advance()
+		return p.stringNode(t)
+	case t.kind == tokInterpStart:
+		p.advance()
+		expr := p.parseExpr()
+		closing := p.expectOp("}")
+		return &NixNode{Kind: NixInterpolation, Start: t.start, End: closing.end, Children: []*NixNode{expr}}
+	}
+	p.errorf(t, "expected attribute name, found %s", t.describe())
+	return nil
+}
+
+// parseBinaryLeft parses a left-associative chain of the given operators
+func (p *nixParser) parseBinaryLeft(next func() *NixNode, ops ...string) *NixNode {
+	left := next()
+	for {
+		op, ok := p.matchOp(ops...)
+		if !ok {
+			return left
+		}
+		right := next()
+		left = &NixNode{Kind: NixBinaryOp, Value: op, Start: left.Start, End: right.End, Children: []*NixNode{left, right}}
+	}
+}
+
+// parseBinaryRight parses a right-associative chain of the given operators
+func (p *nixParser) parseBinaryRight(next func() *NixNode, ops ...string) *NixNode {
+	left := next()
+	op, ok := p.matchOp(ops...)
+	if !ok {
+		return left
+	}
+	right := p.parseBinaryRight(next, ops...)
+	return &NixNode{Kind: NixBinaryOp, Value: op, Start: left.Start, End: right.End, Children: []*NixNode{left, right}}
+}
+
+// matchOp consumes the next token if it is one of ops
+func (p *nixParser) matchOp(ops ...string) (string, bool) {
+	t := p.peek()
+	for _, op := range ops {
+		if t.isOp(op) {
+			p.advance()
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *nixParser) parseImpl() *NixNode {
+	return p.parseBinaryRight(p.parseOr, "->")
+}
+
+func (p *nixParser) parseOr() *NixNode {
+	return p.parseBinaryLeft(p.parseAnd, "||")
+}
+
+func (p *nixParser) parseAnd() *NixNode {
+	return p.parseBinaryLeft(p.parseEquality, "&&")
+}
+
+func (p *nixParser) parseEquality() *NixNode {
+	return p.parseBinaryLeft(p.parseComparison, "==", "!=")
+}
+
+func (p *nixParser) parseComparison() *NixNode {
+	return p.parseBinaryLeft(p.parseUpdate, "<=", ">=", "<", ">")
+}
+
+func (p *nixParser) parseUpdate() *NixNode {
+	return p.parseBinaryRight(p.parseNot, "//")
+}
+
+func (p *nixParser) parseNot() *NixNode {
+	if t := p.peek(); t.isOp("!") {
+		p.advance()
+		operand := p.parseNot()
+		return &NixNode{Kind: NixUnaryOp, Value: "!", Start: t.start, End: operand.End, Children: []*NixNode{operand}}
+	}
+	return p.parseAdd()
+}
+
+func (p *nixParser) parseAdd() *NixNode {
+	return p.parseBinaryLeft(p.parseMul, "+", "-")
+}
+
+func (p *nixParser) parseMul() *NixNode {
+	return p.parseBinaryLeft(p.parseConcat, "*", "/")
+}
+
+func (p *nixParser) parseConcat() *NixNode {
+	return p.parseBinaryRight(p.parseHasAttr, "++")
+}
+
+func (p *nixParser) parseHasAttr() *NixNode {
+	expr := p.parseNegate()
+	for p.peek().isOp("?") {
+		p.advance()
+		path := p.parseAttrPath()
+		expr = &NixNode{Kind: NixHasAttr, Start: expr.Start, End: path.End, Children: []*NixNode{expr, path}}
+	}
+	return expr
+}
+
+func (p *nixParser) parseNegate() *NixNode {
+	if t := p.peek(); t.isOp("-") {
+		p.advance()
+		operand := p.parseNegate()
+		return &NixNode{Kind: NixUnaryOp, Value: "-", Start: t.start, End: operand.End, Children: []*NixNode{operand}}
+	}
+	return p.parseApply()
+}
+
+func (p *nixParser) parseApply() *NixNode {
+	fn := p.parseSelect()
+	for p.startsSimple(p.peek()) {
+		arg := p.parseSelect()
+		fn = &NixNode{Kind: NixApply, Start: fn.Start, End: arg.End, Children: []*NixNode{fn, arg}}
+	}
+	return fn
+}
+
+// startsSimple reports whether t can begin a function argument
+func (p *nixParser) startsSimple(t nixToken) bool {
+	switch t.kind {
+	case tokInt, tokFloat, tokPath, tokURI, tokString:
+		return true
+	case tokID:
+		return t.text == "rec" || (!nixKeywords[t.text] && t.text != "or")
+	case tokOp:
+		return t.text == "(" || t.text == "[" || t.text == "{"
+	}
+	return false
+}
+
+func (p *nixParser) parseSelect() *NixNode {
+	expr := p.parseSimple()
+	if !p.peek().isOp(".") {
+		return expr
+	}
+	p.advance()
+	path := p.parseAttrPath()
+	sel := &NixNode{Kind: NixSelect, Start: expr.Start, End: path.End, Children: []*NixNode{expr, path}}
+	if p.peek().isKeyword("or") {
+		p.advance()
+		def := p.parseSelect()
+		sel.Children = append(sel.Children, def)
+		sel.End = def.End
+	}
+	return sel
+}
+
+func (p *nixParser) parseSimple() *NixNode {
+	t := p.peek()
+	switch t.kind {
+	case tokID:
+		if t.text == "rec" {
+			p.advance()
+			set := p.parseAttrSet()
+			set.Start = t.start
+			set.Value = "rec"
+			return set
+		}
+		if nixKeywords[t.text] {
+			p.errorf(t, "unexpected %s", t.describe())
+		}
+		p.advance()
+		return &NixNode{Kind: NixIdent, Value: t.text, Start: t.start, End: t.end}
+	case tokInt:
+		p.advance()
+		return &NixNode{Kind: NixInt, Value: t.text, Start: t.start, End: t.end}
+	case tokFloat:
+		p.advance()
+		return &NixNode{Kind: NixFloat, Value: t.text, Start: t.start, End: t.end}
+	case tokPath:
+		p.advance()
+		path := &NixNode{Kind: NixPath, Value: t.text, Start: t.start, End: t.end}
+		for _, part := range t.parts {
+			if part.interp {
+				path.Children = append(path.Children, p.interpolationNode(part))
+			}
+		}
+		return path
+	case tokURI:
+		p.advance()
+		return &NixNode{Kind: NixURI, Value: t.text, Start: t.start, End: t.end}
+	case tokString:
+		p.advance()
+		return p.stringNode(t)
+	case tokOp:
+		switch t.text {
+		case "(":
+			p.advance()
+			inner := p.parseExpr()
+			closing := p.expectOp(")")
+			return &NixNode{Kind: NixParen, Start: t.start, End: closing.end, Children: []*NixNode{inner}}
+		case "[":
+			p.advance()
+			list := &NixNode{Kind: NixList, Start: t.start}
+			for !p.peek().isOp("]") {
+				if p.peek().kind == tokEOF {
+					p.errorf(p.peek(), "unterminated list")
+				}
+				list.Children = append(list.Children, p.parseSelect())
+			}
+			list.End = p.advance().end
+			return list
+		case "{":
+			return p.parseAttrSet()
+		}
+	}
+	p.errorf(t, "unexpected %s", t.describe())
+	return nil
+}
+
+func (p *nixParser) parseAttrSet() *NixNode {
+	open := p.expectOp("{")
+	binds := p.parseBinds(func(t nixToken) bool { return t.isOp("}") })
+	closing := p.advance()
+	return &NixNode{Kind: NixAttrSet, Start: open.start, End: closing.end, Children: binds}
+}
+
+// stringNode builds a string node, parsing each interpolated expression in place
+func (p *nixParser) stringNode(t nixToken) *NixNode {
+	str := &NixNode{Kind: NixString, Start: t.start, End: t.end}
+	var text strings.Builder
+	for _, part := range t.parts {
+		if !part.interp {
+			text.WriteString(part.text)
+			continue
+		}
+		str.Children = append(str.Children, p.interpolationNode(part))
+	}
+	str.Value = text.String()
+	return str
+}
+
+// interpolationNode parses the expression of an interpolated part of a
+// string or path
+func (p *nixParser) interpolationNode(part nixStringPart) *NixNode {
+	sub := newNixParser(p.src, part.start, part.end)
+	expr := sub.parseExpr()
+	sub.expectEOF()
+	return &NixNode{Kind: NixInterpolation, Start: part.start - 2, End: part.end + 1, Children: []*NixNode{expr}}
+}
+
+// NixAttrNames returns the static names of an attribute path. The second
+// result is false when the path contains an interpolation.
+func NixAttrNames(path *NixNode) ([]string, bool) {
+	var names []string
+	for _, child := range path.Children {
+		if child.Kind == NixInterpolation || (child.Kind == NixString && len(child.Children) > 0) {
+			return nil, false
+		}
+		names = append(names, child.Value)
+	}
+	return names, true
+}
+
+// nixPackageAttrs are the mkShell attributes that hold package lists
+var nixPackageAttrs = map[string]bool{
+	"packages":          true,
+	"buildInputs":       true,
+	"nativeBuildInputs": true,
+}
+
+// NixPackageList is a package list bound to one of the mkShell package attributes
+type NixPackageList struct {
+	// Attr is the attribute holding the list: packages, buildInputs or nativeBuildInputs
+	Attr string
+	// Scope is the source text of the enclosing "with" scope, such as "pkgs"
+	Scope string
+	// Shell is the attribute set passed to mkShell, or nil when the list was
+	// found outside of a recognizable mkShell call
+	Shell *NixNode
+	// Binding is the binding node the list belongs to
+	Binding *NixNode
+	// List is the list node itself
+	List *NixNode
+}
+
+// FindMkShellArgs returns the attribute sets passed to mkShell in source order
+func FindMkShellArgs(root *NixNode) []*NixNode {
+	var shells []*NixNode
+	WalkNix(root, func(n *NixNode) bool {
+		if n.Kind == NixApply && isMkShell(n.Children[0]) {
+			arg := n.Children[1]
+			for arg.Kind == NixParen {
+				arg = arg.Children[0]
+			}
+			if arg.Kind == NixAttrSet {
+				shells = append(shells, arg)
+			}
+		}
+		return true
+	})
+	return shells
+}
+
+// isMkShell reports whether fn refers to mkShell, as in pkgs.mkShell or
+// nixpkgs.legacyPackages.${system}.mkShell
+func isMkShell(fn *NixNode) bool {
+	name := ""
+	switch fn.Kind {
+	case NixIdent:
+		name = fn.Value
+	case NixSelect:
+		if len(fn.Children) == 2 {
+			path := fn.Children[1].Children
+			name = path[len(path)-1].Value
+		}
+	}
+	return name == "mkShell" || name == "mkShellNoCC"
+}
+
+// FindPackageLists returns the package lists of every mkShell call in source
+// order. Files without a recognizable mkShell call are searched for package
+// attributes anywhere.
+func FindPackageLists(src string, root *NixNode) []NixPackageList {
+	shells := FindMkShellArgs(root)
+
+	var lists []NixPackageList
+	for _, shell := range shells {
+		for _, binding := range shell.Children {
+			lists = append(lists, bindingPackageLists(src, shell, binding)...)
+		}
+	}
+	if len(shells) > 0 {
+		return lists
+	}
+
+	WalkNix(root, func(n *NixNode) bool {
+		found := bindingPackageLists(src, nil, n)
+		lists = append(lists, found...)
+		return found == nil
+	})
+	return lists
+}
+
+// bindingPackageLists returns the lists bound by n when n binds a package attribute
+func bindingPackageLists(src string, shell, n *NixNode) []NixPackageList {
+	if n.Kind != NixBinding {
+		return nil
+	}
+	names, ok := NixAttrNames(n.Children[0])
+	if !ok || len(names) != 1 || !nixPackageAttrs[names[0]] {
+		return nil
+	}
+	lists := collectPackageLists(src, names[0], "", n, n.Children[1])
+	for i := range lists {
+		lists[i].Shell = shell
+	}
+	return lists
+}
+
+// collectPackageLists unwraps "with", parentheses and "++" around package lists
+func collectPackageLists(src, attr, scope string, binding, value *NixNode) []NixPackageList {
+	switch value.Kind {
+	case NixList:
+		return []NixPackageList{{Attr: attr, Scope: scope, Binding: binding, List: value}}
+	case NixWith:
+		return collectPackageLists(src, attr, compactNixText(value.Children[0].Text(src)), binding, value.Children[1])
+	case NixParen:
+		return collectPackageLists(src, attr, scope, binding, value.Children[0])
+	case NixBinaryOp:
+		if value.Value == "++" {
+			left := collectPackageLists(src, attr, scope, binding, value.Children[0])
+			return append(left, collectPackageLists(src, attr, scope, binding, value.Children[1])...)
+		}
+	}
+	return nil
+}
+
+// pinnedElement matches the package set prefix of list elements resolved
+// from a pinned nixpkgs revision, such as nixpkgs-1a2b3c4.gcc in shell.nix or
+// nixpkgs-1a2b3c4.legacyPackages.${system}.gcc in flake.nix
+var pinnedElement = regexp.MustCompile(`^(?:inputs\.)?(nixpkgs-[0-9a-f]+)\.(?:legacyPackages\.[^.\s]+\.)?`)
+
+// NixPackageName returns the package an element of a package list refers to,
+// with the package set prefix ("pkgs.", the with scope or a pinned nixpkgs) removed
+func NixPackageName(src, scope string, elem *NixNode) string {
+	name := compactNixText(elem.Text(src))
+	for _, prefix := range []string{scope, "pkgs"} {
+		if prefix != "" && strings.HasPrefix(name, prefix+".") {
+			return strings.TrimPrefix(name, prefix+".")
+		}
+	}
+	if m := pinnedElement.FindString(name); m != "" {
+		return strings.TrimPrefix(name, m)
+	}
+	return name
+}
+
+// pinnedSetOf returns the pinned nixpkgs an element is resolved from, or ""
+func pinnedSetOf(src string, elem *NixNode) string {
+	if m := pinnedElement.FindStringSubmatch(compactNixText(elem.Text(src))); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// compactNixText collapses whitespace runs in Nix source text
+func compactNixText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// ExtractNixPackages parses Nix source and returns the packages of every package list
+func ExtractNixPackages(content string) ([]string, error) {
+	root, err := ParseNix(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []string
+	for _, list := range FindPackageLists(content, root) {
+		for _, elem := range list.List.Children {
+			packages = append(packages, NixPackageName(content, list.Scope, elem))
+		}
+	}
+	return packages, nil
+}
@@ -0,0 +1,653 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// nixpkgsCommit matches a full nixpkgs commit hash
+var nixpkgsCommit = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// pinnedRevision finds the nixpkgs commit in a fetchTarball URL or flake reference
+var pinnedRevision = regexp.MustCompile(`(?:archive/|nixpkgs/)([0-9a-f]{40})`)
+
+// pinnedSetName matches the names nsm binds pinned nixpkgs revisions to
+var pinnedSetName = regexp.MustCompile(`^nixpkgs-[0-9a-f]+$`)
+
+// pinnedHash finds the source hash passed to fetchTarball
+var pinnedHash = regexp.MustCompile(`sha256\s*=\s*"([^"]+)"`)
+
+// IsNixpkgsCommit reports whether rev is a full nixpkgs commit hash
+func IsNixpkgsCommit(rev string) bool {
+	return nixpkgsCommit.MatchString(rev)
+}
+
+// PinnedSetName returns the name a pinned nixpkgs revision is bound to in
+// shell.nix and flake.nix, such as nixpkgs-1a2b3c4
+func PinnedSetName(rev string) string {
+	if len(rev) > 7 {
+		rev = rev[:7]
+	}
+	return "nixpkgs-" + rev
+}
+
+// NixpkgsTarballURL returns the source tarball of a nixpkgs commit
+func NixpkgsTarballURL(rev string) string {
+	return "https://github.com/NixOS/nixpkgs/archive/" + rev + ".tar.gz"
+}
+
+// NixpkgsFlakeRef returns the flake reference of a nixpkgs commit
+func NixpkgsFlakeRef(rev string) string {
+	return "github:NixOS/nixpkgs/" + rev
+}
+
+// ResolvePinRevision finds a nixpkgs commit that provides version of pkg.
+// The configured channel is used when its package matches the version; a
+// version matches when it equals the requested one or extends it with more
+// components, so 3.11 matches 3.11.9.
+func ResolvePinRevision(ctx context.Context, pkg, version string) (string, error) {
+	info, revision, err := lookupChannelPackage(ctx, pkg)
+	if err != nil {
+		return "", err
+	}
+
+	if info == nil || info.Version == "" {
+		return "", fmt.Errorf("the configured channel does not provide %s", pkg)
+	}
+	if !VersionMatches(info.Version, version) {
+		return "", fmt.Errorf("the configured channel provides %s %s, not %s", pkg, info.Version, version)
+	}
+	if !IsNixpkgsCommit(revision) {
+		return "", fmt.Errorf("the configured channel does not report its nixpkgs commit")
+	}
+	return revision, nil
+}
+
+// CheckRevisionVersion confirms that a nixpkgs commit provides the requested
+// version of pkg and returns the version it provides. The version history is
+// consulted first; commits it does not know are evaluated.
+func CheckRevisionVersion(ctx context.Context, pkg, version, revision string) (string, error) {
+	provided := ""
+	if db, err := LoadVersionDB(); err != nil {
+		Debug("Could not load version database: %v", err)
+	} else {
+		for _, record := range db.Versions(pkg) {
+			if record.Revision == revision {
+				provided = record.Version
+				break
+			}
+		}
+	}
+
+	if provided == "" {
+		info, err := lookupRevisionPackage(ctx, pkg, revision)
+		if err != nil {
+			return "", err
+		}
+		if info == nil || info.Version == "" {
+			return "", fmt.Errorf("%w: nixpkgs %s does not provide %s", ErrInvalidPackage, revision, pkg)
+		}
+		provided = info.Version
+	}
+
+	if !VersionMatches(provided, version) {
+		return "", fmt.Errorf("%w: nixpkgs %s provides %s %s, not %s", ErrValidationFailed, revision, pkg, provided, version)
+	}
+	return provided, nil
+}
+
+// VersionMatches reports whether version satisfies the requested version
+func VersionMatches(version, requested string) bool {
+	requested = strings.TrimPrefix(requested, "v")
+	return version == requested || strings.HasPrefix(version, requested+".")
+}
+
+// pinnedSet is a nixpkgs snapshot bound to a name in the edited source
+type pinnedSet struct {
+	name   string
+	source NixpkgsSource
+}
+
+// PinnedPackages returns the packages resolved from a pinned nixpkgs
+// snapshot, mapped to that snapshot
+func (e *NixEditor) PinnedPackages() map[string]NixpkgsSource {
+	sources := e.pinnedSetSources()
+	pins := make(map[string]NixpkgsSource)
+	for _, list := range e.lists {
+		for _, elem := range list.List.Children {
+			if source, ok := sources[pinnedSetOf(e.src, elem)]; ok {
+				pins[NixPackageName(e.src, list.Scope, elem)] = source
+			}
+		}
+	}
+	return pins
+}
+
+// pinnedSetSources maps the pinned nixpkgs bound in the source to their
+// snapshots, from let bindings in shell.nix and inputs in flake.nix
+func (e *NixEditor) pinnedSetSources() map[string]NixpkgsSource {
+	sources := make(map[string]NixpkgsSource)
+	WalkNix(e.root, func(n *NixNode) bool {
+		if n.Kind != NixBinding {
+			return true
+		}
+		names, _ := NixAttrNames(n.Children[0])
+		for _, name := range names {
+			if !pinnedSetName.MatchString(name) {
+				continue
+			}
+			value := n.Children[1].Text(e.src)
+			if m := pinnedRevision.FindStringSubmatch(value); m != nil {
+				source := NixpkgsSource{Revision: m[1]}
+				if h := pinnedHash.FindStringSubmatch(value); h != nil {
+					source.NarHash = h[1]
+				}
+				sources[name] = source
+			}
+		}
+		return true
+	})
+	return sources
+}
+
+// PinPackage resolves pkg from a pinned nixpkgs snapshot. Existing elements
+// for the package are replaced; otherwise the package is added to the target list.
+func (e *NixEditor) PinPackage(pkg string, source NixpkgsSource) error {
+	target, err := e.TargetList()
+	if err != nil {
+		return err
+	}
+
+	set := PinnedSetName(source.Revision)
+	ref, err := e.pinnedRef(target, set)
+	if err != nil {
+		return err
+	}
+	element := ref + "." + pkg
+
+	replaced := false
+	for _, list := range e.lists {
+		for _, elem := range list.List.Children {
+			if NixPackageName(e.src, list.Scope, elem) != pkg {
+				continue
+			}
+			if old := pinnedSetOf(e.src, elem); old != "" && old != set {
+				e.dropSets[old] = true
+			}
+			e.detached[elem] = true
+			e.Replace(elem, element)
+			replaced = true
+		}
+	}
+	if !replaced {
+		e.edits = append(e.edits, e.insertIntoList(target.List, []listElement{{text: element, constraint: e.annotations[pkg]}}))
+	}
+
+	if _, ok := e.pinnedSetSources()[set]; ok {
+		return nil
+	}
+	for _, s := range e.sets {
+		if s.name == set {
+			return nil
+		}
+	}
+	e.sets = append(e.sets, pinnedSet{name: set, source: source})
+	return nil
+}
+
+// UnpinPackage resolves pkg from the default package set again and reports
+// whether it was pinned. Pinned sets left unused are removed.
+func (e *NixEditor) UnpinPackage(pkg string) bool {
+	found := false
+	for i := range e.lists {
+		list := &e.lists[i]
+		prefix := e.elementPrefix(list)
+		for _, elem := range list.List.Children {
+			set := pinnedSetOf(e.src, elem)
+			if set == "" || NixPackageName(e.src, list.Scope, elem) != pkg {
+				continue
+			}
+			e.Replace(elem, prefix+pkg)
+			e.detached[elem] = true
+			e.dropSets[set] = true
+			found = true
+		}
+	}
+	return found
+}
+
+// pinnedRef returns the expression list elements use to refer to the
+// packages of a pinned set
+func (e *NixEditor) pinnedRef(target *NixPackageList, set string) (string, error) {
+	outputs := e.flakeOutputs()
+	if outputs == nil {
+		return set, nil
+	}
+
+	base := set
+	if lambda := outputs.Children[1]; lambda.Kind == NixLambda && (len(lambda.Children) < 2 || lambda.Children[0].Kind != NixFormals) {
+		base = lambda.Value + "." + set
+	}
+
+	// Follow the path the shell takes into the nixpkgs input, as in
+	// nixpkgs.legacyPackages.${system}.mkShell
+	var candidates []string
+	if fn := e.mkShellFn(target.Shell); fn != nil && fn.Kind == NixSelect {
+		text := compactNixText(fn.Text(e.src))
+		candidates = append(candidates, text[:strings.LastIndex(text, ".")])
+	}
+	candidates = append(candidates, target.Scope)
+	for _, candidate := range candidates {
+		candidate = e.resolveLetPath(candidate, target.List)
+		for _, input := range []string{"nixpkgs.", "inputs.nixpkgs."} {
+			if strings.HasPrefix(candidate, input) {
+				return base + "." + strings.TrimPrefix(candidate, input), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("cannot tell which package set of the nixpkgs input the shell uses, " +
+		"it must come from nixpkgs.legacyPackages, directly or through a let binding")
+}
+
+// resolveLetPath expands the first name of an attribute path when a let
+// block around node binds it to another attribute path, so pkgs.mkShell
+// becomes nixpkgs.legacyPackages.${system}.mkShell with
+// pkgs = nixpkgs.legacyPackages.${system}
+func (e *NixEditor) resolveLetPath(path string, node *NixNode) string {
+	for range 8 {
+		head, rest, _ := strings.Cut(path, ".")
+		var value *NixNode
+		WalkNix(e.root, func(n *NixNode) bool {
+			if n.Start > node.Start || n.End < node.End {
+				return false
+			}
+			if n.Kind == NixLet {
+				for _, binding := range n.Children[0].Children {
+					if binding.Kind != NixBinding {
+						continue
+					}
+					if names, ok := NixAttrNames(binding.Children[0]); ok && len(names) == 1 && names[0] == head {
+						value = binding.Children[1]
+					}
+				}
+			}
+			return true
+		})
+		if value == nil || (value.Kind != NixSelect && value.Kind != NixIdent) {
+			return path
+		}
+		resolved := compactNixText(value.Text(e.src))
+		if resolved == head {
+			return path
+		}
+		if rest != "" {
+			resolved += "." + rest
+		}
+		path = resolved
+	}
+	return path
+}
+
+// mkShellFn returns the function applied to the mkShell attribute set shell
+func (e *NixEditor) mkShellFn(shell *NixNode) *NixNode {
+	var fn *NixNode
+	WalkNix(e.root, func(n *NixNode) bool {
+		if fn != nil {
+			return false
+		}
+		if n.Kind == NixApply {
+			arg := n.Children[1]
+			for arg.Kind == NixParen {
+				arg = arg.Children[0]
+			}
+			if arg == shell {
+				fn = n.Children[0]
+				return false
+			}
+		}
+		return true
+	})
+	return fn
+}
+
+// flakeOutputs returns the outputs binding of a flake, or nil when the source
+// is not a flake
+func (e *NixEditor) flakeOutputs() *NixNode {
+	if e.root.Kind != NixAttrSet {
+		return nil
+	}
+	for _, binding := range e.root.Children {
+		if binding.Kind != NixBinding {
+			continue
+		}
+		if names, ok := NixAttrNames(binding.Children[0]); ok && len(names) == 1 && names[0] == "outputs" {
+			return binding
+		}
+	}
+	return nil
+}
+
+// pinnedSetEdits builds the edits that bind newly pinned sets and remove the
+// sets no element refers to anymore. New sets are never anchored on a binding
+// that is removed, so a package can move from one pinned set to another.
+func (e *NixEditor) pinnedSetEdits() ([]NixEdit, error) {
+	stale := make(map[string]bool)
+	for set := range e.dropSets {
+		if !e.setInUse(set) {
+			stale[set] = true
+		}
+	}
+
+	var edits []NixEdit
+	if len(e.sets) > 0 {
+		var added []NixEdit
+		var err error
+		if outputs := e.flakeOutputs(); outputs != nil {
+			added, err = e.flakeInputEdits(outputs, stale)
+		} else {
+			added = e.letBindingEdits(stale)
+		}
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, added...)
+	}
+
+	for set := range stale {
+		edits = append(edits, e.setRemovalEdits(set)...)
+	}
+	return edits, nil
+}
+
+// setInUse reports whether any element still refers to a pinned set
+func (e *NixEditor) setInUse(set string) bool {
+	for _, s := range e.sets {
+		if s.name == set {
+			return true
+		}
+	}
+	for _, list := range e.allLists {
+		for _, elem := range list.List.Children {
+			if !e.detached[elem] && pinnedSetOf(e.src, elem) == set {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// letBindingEdits binds the new sets in a let block around the shell.nix body
+func (e *NixEditor) letBindingEdits(stale map[string]bool) []NixEdit {
+	var bindings []string
+	for _, set := range e.sets {
+		fetch := QuoteNixString(NixpkgsTarballURL(set.source.Revision))
+		if set.source.NarHash != "" {
+			fetch = fmt.Sprintf("{ url = %s; sha256 = %s; }", fetch, QuoteNixString(set.source.NarHash))
+		}
+		bindings = append(bindings, fmt.Sprintf("%s = import (fetchTarball %s) {};", set.name, fetch))
+	}
+
+	body := e.root
+	if body.Kind == NixLambda {
+		body = body.Children[len(body.Children)-1]
+	}
+
+	src := e.src
+	if body.Kind == NixLet {
+		attrs := body.Children[0]
+		if len(attrs.Children) == 0 {
+			return []NixEdit{{Start: attrs.Start, End: attrs.Start, Text: " " + strings.Join(bindings, " ")}}
+		}
+		return []NixEdit{e.addBindings(attrs.Children, bindings, stale)}
+	}
+
+	start := lineStart(src, body.Start)
+	if strings.TrimSpace(src[start:body.Start]) != "" {
+		return []NixEdit{{Start: body.Start, End: body.Start, Text: "let " + strings.Join(bindings, " ") + " in "}}
+	}
+	indent := src[start:body.Start]
+	newline := lineBreak(src, body.Start)
+	var text strings.Builder
+	text.WriteString(indent + "let" + newline)
+	for _, binding := range bindings {
+		text.WriteString(indent + indentUnit(indent) + binding + newline)
+	}
+	text.WriteString(indent + "in" + newline)
+	return []NixEdit{{Start: start, End: start, Text: text.String()}}
+}
+
+// flakeInputEdits adds the new sets as flake inputs and outputs arguments
+func (e *NixEditor) flakeInputEdits(outputs *NixNode, stale map[string]bool) ([]NixEdit, error) {
+	var edits []NixEdit
+
+	var inputsSet *NixNode
+	var inputBindings []*NixNode
+	for _, binding := range e.root.Children {
+		if binding.Kind != NixBinding {
+			continue
+		}
+		names, _ := NixAttrNames(binding.Children[0])
+		if len(names) == 0 || names[0] != "inputs" {
+			continue
+		}
+		if value := binding.Children[1]; len(names) == 1 && value.Kind == NixAttrSet {
+			inputsSet = value
+		}
+		inputBindings = append(inputBindings, binding)
+	}
+
+	var inputs []string
+	for _, set := range e.sets {
+		inputs = append(inputs, fmt.Sprintf("%s.url = %s;", set.name, QuoteNixString(NixpkgsFlakeRef(set.source.Revision))))
+	}
+
+	switch {
+	case inputsSet != nil && len(inputsSet.Children) > 0:
+		edits = append(edits, e.addBindings(inputsSet.Children, inputs, stale))
+	case inputsSet != nil:
+		edits = append(edits, NixEdit{Start: inputsSet.Start + 1, End: inputsSet.Start + 1, Text: " " + strings.Join(inputs, " ") + " "})
+	default:
+		for i := range inputs {
+			inputs[i] = "inputs." + inputs[i]
+		}
+		if len(inputBindings) > 0 {
+			edits = append(edits, e.addBindings(inputBindings, inputs, stale))
+		} else {
+			start := lineStart(e.src, outputs.Start)
+			indent := lineIndent(e.src, outputs.Start)
+			newline := lineBreak(e.src, outputs.Start)
+			edits = append(edits, NixEdit{Start: start, End: start, Text: indent + strings.Join(inputs, newline+indent) + newline})
+		}
+	}
+
+	// Pass the new inputs to outputs unless it takes the inputs as one argument
+	lambda := outputs.Children[1]
+	if lambda.Kind != NixLambda {
+		return nil, fmt.Errorf("flake outputs is not a function")
+	}
+	if formals := lambda.Children[0]; len(lambda.Children) == 2 && formals.Kind == NixFormals {
+		var names []string
+		for _, set := range e.sets {
+			names = append(names, set.name)
+		}
+		if len(formals.Children) > 0 {
+			last := formals.Children[len(formals.Children)-1]
+			edits = append(edits, NixEdit{Start: last.End, End: last.End, Text: ", " + strings.Join(names, ", ")})
+		} else {
+			edits = append(edits, NixEdit{Start: formals.Start + 1, End: formals.Start + 1, Text: " " + strings.Join(names, ", ") + ","})
+		}
+	}
+	return edits, nil
+}
+
+// addBindings inserts lines after the last of bindings that is kept. When
+// every binding binds a stale set, the lines go before the first one instead,
+// as the bindings are removed by the same edit.
+func (e *NixEditor) addBindings(bindings []*NixNode, lines []string, stale map[string]bool) NixEdit {
+	for i := len(bindings) - 1; i >= 0; i-- {
+		kept := true
+		for set := range stale {
+			kept = kept && !bindsName(bindings[i], set)
+		}
+		if kept {
+			return e.appendAfter(bindings[i], lines)
+		}
+	}
+
+	first := bindings[0]
+	start := lineStart(e.src, first.Start)
+	if strings.TrimSpace(e.src[start:first.Start]) != "" {
+		return NixEdit{Start: first.Start, End: first.Start, Text: strings.Join(lines, " ") + " "}
+	}
+	indent := e.src[start:first.Start]
+	newline := lineBreak(e.src, first.Start)
+	var text strings.Builder
+	for _, line := range lines {
+		text.WriteString(indent + line + newline)
+	}
+	return NixEdit{Start: start, End: start, Text: text.String()}
+}
+
+// appendAfter inserts lines after a binding, at the binding's indentation
+func (e *NixEditor) appendAfter(node *NixNode, lines []string) NixEdit {
+	indent := lineIndent(e.src, node.Start)
+	newline := lineBreak(e.src, node.Start)
+	var text strings.Builder
+	for _, line := range lines {
+		text.WriteString(newline + indent + line)
+	}
+	return NixEdit{Start: node.End, End: node.End, Text: text.String()}
+}
+
+// setRemovalEdits removes the binding of a pinned set, and for flakes its
+// outputs argument
+func (e *NixEditor) setRemovalEdits(set string) []NixEdit {
+	var edits []NixEdit
+	WalkNix(e.root, func(n *NixNode) bool {
+		switch n.Kind {
+		case NixLet:
+			attrs := n.Children[0]
+			if len(attrs.Children) == 1 && bindsName(attrs.Children[0], set) && len(e.sets) == 0 {
+				// Drop the whole let block nsm created for the set
+				edits = append(edits, NixEdit{Start: n.Start, End: n.Children[1].Start, Text: ""})
+				return false
+			}
+		case NixBinding:
+			if bindsName(n, set) {
+				edits = append(edits, e.removalEdit(n))
+				return false
+			}
+		case NixFormals:
+			for i, formal := range n.Children {
+				if formal.Value != set {
+					continue
+				}
+				if i > 0 {
+					edits = append(edits, NixEdit{Start: n.Children[i-1].End, End: formal.End, Text: ""})
+				} else if i+1 < len(n.Children) {
+					edits = append(edits, NixEdit{Start: formal.Start, End: n.Children[i+1].Start, Text: ""})
+				}
+			}
+		}
+		return true
+	})
+	return edits
+}
+
+// bindsName reports whether a binding defines name, directly or as an input
+func bindsName(binding *NixNode, name string) bool {
+	if binding.Kind != NixBinding {
+		return false
+	}
+	names, _ := NixAttrNames(binding.Children[0])
+	if len(names) > 0 && names[0] == "inputs" {
+		names = names[1:]
+	}
+	return len(names) > 0 && names[0] == name
+}
+
+// PinNixpkgs points the nixpkgs the shell builds against at a snapshot: the
+// nixpkgs input of a flake, or the <nixpkgs> imports of shell.nix. Pinned
+// package sets are left alone.
+func (e *NixEditor) PinNixpkgs(source NixpkgsSource) error {
+	if !IsNixpkgsCommit(source.Revision) {
+		return fmt.Errorf("invalid nixpkgs revision %q: expected a full commit hash", source.Revision)
+	}
+
+	if e.flakeOutputs() != nil {
+		url := findAttr(e.root, []string{"inputs", "nixpkgs", "url"})
+		if url == nil || url.Kind != NixString {
+			return fmt.Errorf("flake has no nixpkgs input URL")
+		}
+		e.Replace(url, QuoteNixString(NixpkgsFlakeRef(source.Revision)))
+		return nil
+	}
+
+	fetch := "fetchTarball " + QuoteNixString(NixpkgsTarballURL(source.Revision))
+	if source.NarHash != "" {
+		fetch = fmt.Sprintf("fetchTarball { url = %s; sha256 = %s; }",
+			QuoteNixString(NixpkgsTarballURL(source.Revision)), QuoteNixString(source.NarHash))
+	}
+
+	found := false
+	WalkNix(e.root, func(n *NixNode) bool {
+		switch n.Kind {
+		case NixBinding:
+			// Packages pinned on their own keep their snapshot
+			names, _ := NixAttrNames(n.Children[0])
+			return len(names) != 1 || !pinnedSetName.MatchString(names[0])
+		case NixPath:
+			if n.Value == "<nixpkgs>" {
+				e.Replace(n, "("+fetch+")")
+				found = true
+			}
+		case NixApply:
+			// A nixpkgs tarball pinned before
+			if fn := compactNixText(n.Children[0].Text(e.src)); fn == "fetchTarball" || fn == "builtins.fetchTarball" {
+				if pinnedRevision.MatchString(n.Text(e.src)) {
+					e.Replace(n, fetch)
+					found = true
+				}
+				return false
+			}
+		}
+		return true
+	})
+	if !found {
+		return fmt.Errorf("no <nixpkgs> import found")
+	}
+	return nil
+}
+
+// findAttr returns the value bound to an attribute path in an attribute set,
+// following nested sets such as inputs = { nixpkgs.url = ...; }
+func findAttr(set *NixNode, path []string) *NixNode {
+	if set.Kind != NixAttrSet {
+		return nil
+	}
+	for _, binding := range set.Children {
+		if binding.Kind != NixBinding {
+			continue
+		}
+		names, ok := NixAttrNames(binding.Children[0])
+		if !ok || len(names) > len(path) {
+			continue
+		}
+		matches := true
+		for i, name := range names {
+			matches = matches && name == path[i]
+		}
+		if !matches {
+			continue
+		}
+		if len(names) == len(path) {
+			return binding.Children[1]
+		}
+		if value := findAttr(binding.Children[1], path[len(names):]); value != nil {
+			return value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// NixCommand describes one invocation of an external Nix command
+type NixCommand struct {
+	Name string
+	Args []string
+	// Dir is the working directory, the current one when empty
+	Dir string
+	// Env holds KEY=value entries added to the environment of NSM
+	Env []string
+	// Stdin is passed to the command's standard input
+	Stdin io.Reader
+	// Stdout receives the standard output instead of it being returned
+	Stdout io.Writer
+	// Stderr receives the standard error instead of it being captured for
+	// the error message
+	Stderr io.Writer
+	// Timeout bounds how long the command may run; zero means no limit
+	Timeout time.Duration
+}
+
+// String renders the command line
+func (c NixCommand) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// maxLoggedArgLength bounds the arguments written to the log, which may be
+// whole Nix expressions
+const maxLoggedArgLength = 80
+
+// summary renders the command line for the log, shortening long arguments
+func (c NixCommand) summary() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		if arg = strings.Join(strings.Fields(arg), " "); len(arg) > maxLoggedArgLength {
+			arg = arg[:maxLoggedArgLength] + "..."
+		}
+		args[i] = arg
+	}
+	return NixCommand{Name: c.Name, Args: args}.String()
+}
+
+// NixRunner runs external Nix commands. Tests replace it with a fake
+// evaluator through SetNixRunner so no Nix installation is needed.
+type NixRunner interface {
+	// Run runs the command and returns its standard output, unless
+	// cmd.Stdout is set. Failures are reported as *CommandError.
+	Run(ctx context.Context, cmd NixCommand) ([]byte, error)
+	// LookPath reports where the named command is installed
+	LookPath(name string) (string, error)
+}
+
+// CommandError reports an external command that could not be started, did
+// not finish in time or exited with a non-zero status
+type CommandError struct {
+	Command string
+	// ExitCode is the exit status, or -1 when the command did not exit
+	ExitCode int
+	// Stderr is the captured standard error, if any
+	Stderr   string
+	TimedOut bool
+	Err      error
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("%s failed: %v", e.Command, e.Err)
+	if e.TimedOut {
+		msg = fmt.Sprintf("%s timed out", e.Command)
+	}
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes every CommandError match ErrExternalCommandFailed
+func (e *CommandError) Is(target error) bool {
+	return target == ErrExternalCommandFailed
+}
+
+// interruptGrace is how long a cancelled command may take to exit
+const interruptGrace = 5 * time.Second
+
+// ExecRunner is the NixRunner that runs real commands
+type ExecRunner struct{}
+
+// Run starts the command and waits for it. Cancelling ctx or reaching the
+// timeout interrupts it, and kills it when it has not exited after
+// interruptGrace.
+func (ExecRunner) Run(ctx context.Context, cmd NixCommand) ([]byte, error) {
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+
+	c := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	c.Cancel = func() error {
+		// Forward the interrupt so Nix can clean up, as after Ctrl-C
+		if err := c.Process.Signal(os.Interrupt); err != nil {
+			return c.Process.Kill()
+		}
+		return nil
+	}
+	c.WaitDelay = interruptGrace
+	c.Dir = cmd.Dir
+	if len(cmd.Env) > 0 {
+		c.Env = append(os.Environ(), cmd.Env...)
+	}
+	c.Stdin = cmd.Stdin
+
+	var stdout, stderr bytes.Buffer
+	c.Stdout, c.Stderr = &stdout, &stderr
+	if cmd.Stdout != nil {
+		c.Stdout = cmd.Stdout
+	}
+	if cmd.Stderr != nil {
+		c.Stderr = cmd.Stderr
+	}
+
+	start := time.Now()
+	err := c.Run()
+	logger := With("command", cmd.summary(), "duration", time.Since(start).Round(time.Millisecond).String())
+	if err != nil {
+		cmdErr := &CommandError{
+			Command:  cmd.Name,
+			ExitCode: -1,
+			Stderr:   strings.TrimSpace(stderr.String()),
+			Err:      err,
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			cmdErr.ExitCode = exitErr.ExitCode()
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			cmdErr.Err = ctxErr
+			cmdErr.TimedOut = errors.Is(ctxErr, context.DeadlineExceeded)
+		}
+		logger.With("exit_code", cmdErr.ExitCode).Debug("Nix command failed: %v", cmdErr.Err)
+		return stdout.Bytes(), cmdErr
+	}
+	logger.Debug("Ran nix command")
+	return stdout.Bytes(), nil
+}
+
+// LookPath searches for the command in PATH
+func (ExecRunner) LookPath(name string) (string, error) {
+	return exec.LookPath(name)
+}
+
+var nixRunner NixRunner = ExecRunner{}
+
+// SetNixRunner replaces the runner used for Nix commands and returns the previous one
+func SetNixRunner(runner NixRunner) NixRunner {
+	previous := nixRunner
+	nixRunner = runner
+	return previous
+}
+
+// nixOutput runs a Nix command and returns its standard output
+func nixOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return nixRunner.Run(ctx, NixCommand{Name: name, Args: args})
+}
+
+// RunNix runs a Nix command, such as an interactive shell, through the
+// configured runner
+func RunNix(ctx context.Context, cmd NixCommand) ([]byte, error) {
+	return nixRunner.Run(ctx, cmd)
+}
+
+// LookPath reports where a Nix command is installed
+func LookPath(name string) (string, error) {
+	return nixRunner.LookPath(name)
+}
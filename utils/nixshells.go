@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DefaultShellName is the shell entered when no shell is named, as with
+// "nix develop" and nix-shell
+const DefaultShellName = "default"
+
+// shellNamePattern matches the names accepted for new shells, which are
+// used unquoted as Nix attribute names
+var shellNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_'-]*$`)
+
+// nixSystemPattern matches the system names flakes expose shells under,
+// such as x86_64-linux
+var nixSystemPattern = regexp.MustCompile(`^[a-z0-9_]+-(linux|darwin|freebsd|netbsd|openbsd|windows|none|wasi)$`)
+
+// NixShell is a mkShell call together with the development shell it defines
+type NixShell struct {
+	// Name is the name of the shell under devShells. It is "default" for
+	// the legacy devShell attribute and empty when the shell is not exposed
+	// under either, as in shell.nix.
+	Name string
+	// Path is the attribute path of the bindings enclosing the shell, such
+	// as outputs.devShells.default
+	Path []string
+	// Binding is the innermost binding holding the shell, or nil
+	Binding *NixNode
+	// Func is the mkShell function, such as pkgs.mkShell
+	Func *NixNode
+	// Args is the attribute set passed to mkShell
+	Args *NixNode
+}
+
+// Attribute returns the flake attribute of the shell from devShells or
+// devShell on, or its full binding path otherwise
+func (s NixShell) Attribute() string {
+	for i, name := range s.Path {
+		if name == "devShells" || name == "devShell" {
+			return strings.Join(s.Path[i:], ".")
+		}
+	}
+	return strings.Join(s.Path, ".")
+}
+
+// ValidateShellName reports whether name can be used for a new shell
+func ValidateShellName(name string) error {
+	if !shellNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: invalid shell name %q", ErrValidationFailed, name)
+	}
+	return nil
+}
+
+// FindNixShells returns the mkShell calls of a Nix file in source order,
+// named after the devShells attribute they are bound to
+func FindNixShells(src string, root *NixNode) []NixShell {
+	var shells []NixShell
+	collectNixShells(src, root, nil, nil, &shells)
+	return shells
+}
+
+// collectNixShells walks n, tracking the attribute path of the enclosing
+// bindings. The bindings of a let block start a new path, since the shells
+// they define are exposed under another name.
+func collectNixShells(src string, n *NixNode, path []string, binding *NixNode, shells *[]NixShell) {
+	switch n.Kind {
+	case NixBinding:
+		names := bindingPathNames(src, n.Children[0])
+		collectNixShells(src, n.Children[1], append(slices.Clip(path), names...), n, shells)
+		return
+	case NixLet:
+		collectNixShells(src, n.Children[0], nil, nil, shells)
+		collectNixShells(src, n.Children[1], path, binding, shells)
+		return
+	case NixApply:
+		if isMkShell(n.Children[0]) {
+			arg := n.Children[1]
+			for arg.Kind == NixParen {
+				arg = arg.Children[0]
+			}
+			if arg.Kind == NixAttrSet {
+				*shells = append(*shells, NixShell{
+					Name:    shellName(path),
+					Path:    path,
+					Binding: binding,
+					Func:    n.Children[0],
+					Args:    arg,
+				})
+				return
+			}
+		}
+	}
+	for _, child := range n.Children {
+		collectNixShells(src, child, path, binding, shells)
+	}
+}
+
+// bindingPathNames returns the names of an attribute path, keeping the
+// source text of interpolated names such as ${system}
+func bindingPathNames(src string, path *NixNode) []string {
+	names := make([]string, len(path.Children))
+	for i, child := range path.Children {
+		if child.Kind == NixInterpolation || (child.Kind == NixString && len(child.Children) > 0) {
+			names[i] = child.Text(src)
+		} else {
+			names[i] = child.Value
+		}
+	}
+	return names
+}
+
+// isSystemName reports whether an attribute name is a system, including
+// interpolations such as ${system}
+func isSystemName(name string) bool {
+	return strings.HasPrefix(name, "${") || nixSystemPattern.MatchString(name)
+}
+
+// shellName names a shell after its binding path: devShells.<name> or
+// devShells.<system>.<name>, and "default" for devShell.<system>
+func shellName(path []string) string {
+	for i, name := range path {
+		switch name {
+		case "devShell":
+			return DefaultShellName
+		case "devShells":
+			rest := path[i+1:]
+			if len(rest) == 0 {
+				return ""
+			}
+			if len(rest) == 1 && isSystemName(rest[0]) {
+				return DefaultShellName
+			}
+			return rest[len(rest)-1]
+		}
+	}
+	return ""
+}
+
+// AddShell queues a new shell named name next to the default shell, bound
+// under devShells the same way and with an empty package list. The legacy
+// devShell attribute becomes devShells.<name>.
+func (e *NixEditor) AddShell(name string) error {
+	if err := ValidateShellName(name); err != nil {
+		return err
+	}
+	if slices.Contains(e.ShellNames(), name) {
+		return fmt.Errorf("a shell named %q already exists", name)
+	}
+	if len(e.shells) == 0 {
+		return fmt.Errorf("no mkShell call found to model the new shell on")
+	}
+	template, _ := e.findShell(DefaultShellName)
+	if template.Name == "" || template.Binding == nil {
+		return fmt.Errorf("new shells need a flake that exposes its shells under devShells")
+	}
+
+	// Rename the last attribute of the binding path, keeping systems and
+	// interpolations: devShells.${system}.default -> devShells.${system}.ci
+	var parts []string
+	for _, part := range template.Binding.Children[0].Children {
+		parts = append(parts, part.Text(e.src))
+	}
+	if i := slices.Index(parts, "devShell"); i >= 0 {
+		parts[i] = "devShells"
+		parts = append(parts, name)
+	} else {
+		parts[len(parts)-1] = name
+	}
+
+	// Use the package list attribute and scope of the default shell
+	attr, scope := "packages", ""
+	for _, list := range e.allLists {
+		if list.Shell == template.Args {
+			attr, scope = list.Attr, list.Scope
+			break
+		}
+	}
+	list := "["
+	if scope != "" {
+		list = "with " + scope + "; ["
+	}
+
+	indent := lineIndent(e.src, template.Binding.Start)
+	unit := indentUnit(indent)
+	lines := []string{
+		"",
+		strings.Join(parts, ".") + " = " + compactNixText(template.Func.Text(e.src)) + " {",
+		unit + "name = " + QuoteNixString(name) + ";",
+		"",
+		unit + attr + " = " + list,
+		unit + "];",
+		"};",
+	}
+	newline := lineBreak(e.src, template.Binding.Start)
+	var text strings.Builder
+	for _, line := range lines {
+		if line == "" {
+			text.WriteString(newline)
+			continue
+		}
+		text.WriteString(newline + indent + line)
+	}
+	e.edits = append(e.edits, NixEdit{Start: template.Binding.End, End: template.Binding.End, Text: text.String()})
+	return nil
+}
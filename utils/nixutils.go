@@ -2,52 +2,41 @@
 package utils
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
 	"fmt"
-	"os/exec"
+	"regexp"
 	"strings"
+
+	"github.com/spf13/viper"
 )
 
-// GetPackageVersion retrieves the version of an installed package by parsing the nix-env output
-func GetPackageVersion(pkg string) (string, error) {
-	// Run nix-env --query --JSON to get package information
-	cmd := exec.Command("nix-env", "--query", "--json")
-	output, err := cmd.Output()
+// GetPackageVersion returns the version of the installed package with
+// exactly the attribute path pkg. Use QueryProfilePackages to look up several
+// packages.
+func GetPackageVersion(ctx context.Context, pkg string) (string, error) {
+	packages, err := QueryProfilePackages(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to query package info: %v", err)
-	}
-
-	// Parse JSON output
-	var pkgInfo map[string]struct {
-		Version string `json:"version"`
+		return "", err
 	}
-	if err := json.Unmarshal(output, &pkgInfo); err != nil {
-		return "", fmt.Errorf("failed to parse package info: %v", err)
+	if version, ok := packages.Version(pkg); ok {
+		return version, nil
 	}
-
-	// Look for the package version
-	for name, info := range pkgInfo {
-		if strings.HasPrefix(name, "nixpkgs."+pkg) {
-			return info.Version, nil
-		}
-	}
-
 	return "", fmt.Errorf("package %s not found", pkg)
 }
 
 // GetNixVersion gets the installed Nix version
-func GetNixVersion() (string, error) {
-	cmd := exec.Command("nix", "--version")
-	output, err := cmd.Output()
+func GetNixVersion(ctx context.Context) (string, error) {
+	output, err := nixOutput(ctx, "nix", "--version")
 	if err != nil {
-		return "", fmt.Errorf("failed to get Nix version: %v", err)
+		return "", fmt.Errorf("failed to get Nix version: %w", err)
 	}
 	return strings.TrimSpace(string(output)), nil
 }
 
 // CheckFlakeSupport checks if Nix flakes are enabled
-func CheckFlakeSupport() bool {
-	version, err := GetNixVersion()
+func CheckFlakeSupport(ctx context.Context) bool {
+	version, err := GetNixVersion(ctx)
 	if err != nil {
 		return false
 	}
@@ -75,116 +64,129 @@ func CheckFlakeSupport() bool {
 }
 
 // GetChannelInfo gets the current Nixpkgs channel information
-func GetChannelInfo() (string, error) {
-	cmd := exec.Command("nix-channel", "--list")
-	output, err := cmd.Output()
+func GetChannelInfo(ctx context.Context) (string, error) {
+	output, err := nixOutput(ctx, "nix-channel", "--list")
 	if err != nil {
-		return "", fmt.Errorf("failed to get channel info: %v", err)
+		return "", fmt.Errorf("failed to get channel info: %w", err)
 	}
 	return strings.TrimSpace(string(output)), nil
 }
 
-// GetNixpkgsRevision gets the current Nixpkgs revision
-func GetNixpkgsRevision() (string, error) {
-	cmd := exec.Command("nix-instantiate", "--eval", "-E", "<nixpkgs>.rev")
-	output, err := cmd.Output()
+// UpdateChannels downloads the latest revision of every Nix channel and
+// returns the output of nix-channel
+func UpdateChannels(ctx context.Context) (string, error) {
+	var output bytes.Buffer
+	_, err := nixRunner.Run(ctx, NixCommand{
+		Name:   "nix-channel",
+		Args:   []string{"--update"},
+		Stdout: &output,
+		Stderr: &output,
+	})
+	return output.String(), err
+}
+
+// CollectGarbage deletes old profile generations and unused store paths and
+// returns the output of nix-collect-garbage
+func CollectGarbage(ctx context.Context) (string, error) {
+	var output bytes.Buffer
+	_, err := nixRunner.Run(ctx, NixCommand{
+		Name:   "nix-collect-garbage",
+		Args:   []string{"-d"},
+		Stdout: &output,
+		Stderr: &output,
+	})
+	return output.String(), err
+}
+
+// VerifyStore checks the consistency of the Nix store
+func VerifyStore(ctx context.Context) error {
+	_, err := nixOutput(ctx, "nix-store", "--verify")
+	return err
+}
+
+// nixpkgsPathArgs returns the -I arguments that point <nixpkgs> at the
+// channel configured in channel.url, such as nixos-unstable
+func nixpkgsPathArgs() []string {
+	channel := viper.GetString("channel.url")
+	if channel == "" {
+		return nil
+	}
+	if !strings.Contains(channel, "://") {
+		channel = "channel:" + channel
+	}
+	return []string{"-I", "nixpkgs=" + channel}
+}
+
+// GetNixpkgsRevision gets the revision of the configured nixpkgs channel.
+// Channels without revision information report their nixpkgs version instead.
+func GetNixpkgsRevision(ctx context.Context) (string, error) {
+	args := append(nixpkgsPathArgs(), "--eval", "-E",
+		"let lib = import <nixpkgs/lib>; in lib.trivial.revisionWithDefault lib.trivial.version")
+	output, err := nixOutput(ctx, "nix-instantiate", args...)
 	if err != nil {
-		return "", fmt.Errorf("failed to get nixpkgs revision: %v", err)
+		return "", fmt.Errorf("failed to get nixpkgs revision: %w", err)
 	}
 	return strings.Trim(string(output), "\"\n"), nil
 }
 
-// ValidatePackage checks if a package name is valid
+// nixIdentifier matches a plain Nix identifier, the building block of attribute paths
+var nixIdentifier = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_'-]*$`)
+
+// ValidatePackage checks if a package name is a valid nixpkgs attribute path,
+// such as gcc or python3Packages.numpy
 func ValidatePackage(pkg string) bool {
-	if pkg == "" {
-		return false
-	}
+	_, ok := ParseAttrPath(pkg)
+	return ok
+}
 
-	// Allow alphanumeric, dash, dot, and underscore
-	validChars := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_."
+// ParseAttrPath splits a dotted attribute path such as nodePackages.pnpm into
+// its attribute names. The second result is false when any name is not a valid
+// Nix identifier.
+func ParseAttrPath(path string) ([]string, bool) {
+	if path == "" {
+		return nil, false
+	}
 
-	for _, c := range pkg {
-		if !strings.ContainsRune(validChars, c) {
-			return false
+	names := strings.Split(path, ".")
+	for _, name := range names {
+		if !nixIdentifier.MatchString(name) || nixKeywords[name] {
+			return nil, false
 		}
 	}
-	return true
+	return names, true
+}
+
+// NormalizePackageName strips the "pkgs." package set prefix from a package name
+func NormalizePackageName(pkg string) string {
+	return strings.TrimPrefix(pkg, "pkgs.")
 }
 
 // ExtractShellNixPackages extracts package names from shell.nix content
 func ExtractShellNixPackages(content string) []string {
-	var packages []string
-	lines := strings.Split(content, "\n")
-	inPackages := false
-
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if strings.Contains(trimmed, "packages = with pkgs; [") {
-			inPackages = true
-			continue
-		}
-		if inPackages {
-			if strings.Contains(trimmed, "];") {
-				break
-			}
-			// Skip empty lines and comments
-			if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
-				// Split by whitespace in case there are multiple packages per line
-				for _, pkg := range strings.Fields(trimmed) {
-					pkg = strings.TrimRight(pkg, ",") // Remove trailing comma if present
-					if pkg != "" {
-						packages = append(packages, pkg)
-					}
-				}
-			}
-		}
+	packages, err := ExtractNixPackages(content)
+	if err != nil {
+		Debug("Could not parse shell.nix: %v", err)
+		return nil
 	}
 	return packages
 }
 
 // ExtractFlakePackages extracts package names from flake.nix content
 func ExtractFlakePackages(content string) []string {
-	var packages []string
-	lines := strings.Split(content, "\n")
-
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if strings.Contains(trimmed, "buildInputs = [") {
-			// Extract packages from the current line
-			start := strings.Index(trimmed, "[")
-			if start != -1 {
-				end := strings.Index(trimmed, "]")
-				if end != -1 {
-					pkgPart := trimmed[start+1 : end]
-					// Split by whitespace and clean each package name
-					for _, pkg := range strings.Fields(pkgPart) {
-						pkg = strings.TrimSpace(pkg)
-						if pkg != "" {
-							packages = append(packages, pkg)
-						}
-					}
-				}
-			}
-			break // Since we found and processed the buildInputs line
-		}
+	packages, err := ExtractNixPackages(content)
+	if err != nil {
+		Debug("Could not parse flake.nix: %v", err)
+		return nil
 	}
 	return packages
 }
 
-// GetInstalledPackages returns a list of installed packages
-func GetInstalledPackages() ([]string, error) {
-	cmd := exec.Command("nix-env", "--query")
-	output, err := cmd.Output()
+// GetInstalledPackages returns the packages installed in the user profile,
+// sorted by attribute path
+func GetInstalledPackages(ctx context.Context) ([]PackageInfo, error) {
+	packages, err := QueryProfilePackages(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query installed packages: %v", err)
-	}
-
-	lines := strings.Split(string(output), "\n")
-	var packages []string
-	for _, line := range lines {
-		if pkg := strings.TrimSpace(line); pkg != "" {
-			packages = append(packages, pkg)
-		}
+		return nil, err
 	}
-	return packages, nil
+	return packages.List(), nil
 }
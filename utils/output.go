@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OutputFormat selects how command results are printed
+type OutputFormat string
+
+const (
+	// OutputTable is the human-readable output of each command
+	OutputTable OutputFormat = "table"
+	// OutputPlain prints the rows of a result tab-separated, without headers
+	OutputPlain OutputFormat = "plain"
+	// OutputJSON serializes the result as JSON
+	OutputJSON OutputFormat = "json"
+	// OutputYAML serializes the result as YAML
+	OutputYAML OutputFormat = "yaml"
+)
+
+// OutputFormats lists the accepted values of --output
+var OutputFormats = []OutputFormat{OutputTable, OutputPlain, OutputJSON, OutputYAML}
+
+// ParseOutputFormat validates the value of --output
+func ParseOutputFormat(value string) (OutputFormat, error) {
+	for _, format := range OutputFormats {
+		if string(format) == strings.ToLower(value) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q: use table, plain, json or yaml", value)
+}
+
+// Tabular is implemented by command results that can be printed as rows
+type Tabular interface {
+	Headers() []string
+	Rows() [][]string
+}
+
+// RenderResult writes a command result in the given format. JSON and YAML
+// serialize the result with its JSON field names; table and plain print the
+// rows of a Tabular result.
+func RenderResult(w io.Writer, format OutputFormat, result any) error {
+	switch format {
+	case OutputJSON:
+		output, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode result: %w", err)
+		}
+		_, err = fmt.Fprintln(w, string(output))
+		return err
+	case OutputYAML:
+		if err := writeYAML(w, result); err != nil {
+			return fmt.Errorf("failed to encode result: %w", err)
+		}
+		return nil
+	}
+
+	table, ok := result.(Tabular)
+	if !ok {
+		return fmt.Errorf("result cannot be printed as %s", format)
+	}
+	if format == OutputPlain {
+		for _, row := range table.Rows() {
+			if _, err := fmt.Fprintln(w, strings.Join(row, "\t")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return RenderTable(w, tableStyle, TerminalWidth(w), table.Headers(), table.Rows())
+}
+
+// writeYAML writes a value as YAML through its JSON encoding, so both
+// formats share field names and order
+func writeYAML(w io.Writer, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	// JSON is valid YAML; decoding it into a node keeps the key order
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	blockStyle(&node)
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// blockStyle drops the JSON flow style and quoting from a YAML node tree
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// PackageDetails is the metadata of a nixpkgs package as shown by nsm show
+type PackageDetails struct {
+	Attr        string   `json:"attr"`
+	Name        string   `json:"name"`
+	Pname       string   `json:"pname"`
+	Version     string   `json:"version"`
+	Description string   `json:"description"`
+	Homepage    string   `json:"homepage"`
+	Licenses    []string `json:"licenses"`
+	Maintainers []string `json:"maintainers"`
+	Platforms   []string `json:"platforms"`
+	Outputs     []string `json:"outputs"`
+	StorePath   string   `json:"storePath"`
+	ClosureSize int64    `json:"closureSize,omitempty"`
+}
+
+// packageDetailsExpr extracts the metadata of one attribute path. Packages
+// that refuse to evaluate, such as unfree ones, still report their metadata
+// with an empty store path.
+const packageDetailsExpr = `let
+  pkgs = %s;
+  lib = pkgs.lib;
+  path = lib.splitString "." %s;
+in
+  if !(lib.hasAttrByPath path pkgs) then null else
+  let
+    pkg = lib.getAttrFromPath path pkgs;
+    meta = pkg.meta or {};
+    outPath = builtins.tryEval (pkg.outPath or "");
+    homepage = lib.toList (meta.homepage or []);
+  in {
+    name = pkg.name or "";
+    pname = pkg.pname or "";
+    version = pkg.version or "";
+    description = meta.description or "";
+    homepage = if homepage == [] then "" else builtins.head homepage;
+    licenses = map (l: if builtins.isString l then l else l.spdxId or l.shortName or "unknown")
+      (lib.toList (meta.license or []));
+    maintainers = map (m: m.github or m.name or "") (meta.maintainers or []);
+    platforms = builtins.filter builtins.isString (meta.platforms or []);
+    outputs = pkg.outputs or [ "out" ];
+    storePath = if outPath.success then builtins.unsafeDiscardStringContext outPath.value else "";
+  }`
+
+// GetPackageDetails evaluates the metadata of attr in the nixpkgs the project
+// builds against. It returns nil when the package does not exist.
+func GetPackageDetails(ctx context.Context, configFile, attr string) (*PackageDetails, error) {
+	nixpkgs, err := ProjectNixpkgsExpr(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var details *PackageDetails
+	expr := fmt.Sprintf(packageDetailsExpr, nixpkgs, QuoteNixString(attr))
+	if err := EvalNixJSON(ctx, configFile, expr, &details); err != nil {
+		return nil, err
+	}
+	if details != nil {
+		details.Attr = attr
+	}
+	return details, nil
+}
+
+// GetClosureSize returns the total size in bytes of a store path and its
+// dependencies. Paths that are not in the local store are looked up in the
+// binary cache.
+func GetClosureSize(ctx context.Context, storePath string) (int64, error) {
+	output, err := nixOutput(ctx, "nix", "path-info", "-S", "--json", storePath)
+	if err != nil {
+		Debug("%s is not in the local store: %v", storePath, err)
+		output, err = nixOutput(ctx, "nix", "path-info", "-S", "--json",
+			"--store", "https://cache.nixos.org", storePath)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get closure size: %w", err)
+		}
+	}
+	return parseClosureSize(output)
+}
+
+// parseClosureSize reads the closure size from "nix path-info -S --json",
+// which prints a list of path records in older Nix versions and an object
+// keyed by store path in newer ones
+func parseClosureSize(output []byte) (int64, error) {
+	type pathInfo struct {
+		Path        string `json:"path"`
+		ClosureSize int64  `json:"closureSize"`
+	}
+
+	var list []pathInfo
+	if err := json.Unmarshal(output, &list); err == nil {
+		if len(list) == 0 {
+			return 0, fmt.Errorf("no path information returned")
+		}
+		return list[0].ClosureSize, nil
+	}
+
+	var byPath map[string]*pathInfo
+	if err := json.Unmarshal(output, &byPath); err != nil {
+		return 0, fmt.Errorf("failed to parse path information: %w", err)
+	}
+	for _, info := range byPath {
+		if info != nil {
+			return info.ClosureSize, nil
+		}
+	}
+	return 0, fmt.Errorf("store path is not valid")
+}
+
+// FormatSize renders a byte count in human-readable binary units
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,515 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// IndexedPackage is the searchable metadata of one nixpkgs attribute
+type IndexedPackage struct {
+	Attr        string   `json:"attr"`
+	Pname       string   `json:"pname"`
+	Version     string   `json:"version"`
+	Description string   `json:"description"`
+	Licenses    []string `json:"licenses,omitempty"`
+	Platforms   []string `json:"platforms,omitempty"`
+	Terms       []string `json:"terms"`
+}
+
+// PackageIndex is a locally cached catalog of the packages provided by one
+// nixpkgs revision, with an inverted index for full-text search
+type PackageIndex struct {
+	Revision string           `json:"revision"`
+	Packages []IndexedPackage `json:"packages"`
+
+	// Names holds the sorted attribute names of all packages
+	Names []string `json:"-"`
+
+	byAttr   map[string]int
+	postings map[string][]int
+	terms    []string
+}
+
+// packageIndexPath returns the location of the cached package index
+func packageIndexPath() (string, error) {
+	configDir, err := EnsureConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "package-index.json"), nil
+}
+
+// LoadPackageIndex returns the package index for the current nixpkgs
+// revision. The cached index is reused while the revision is unchanged and
+// updated incrementally from nix-env otherwise.
+func LoadPackageIndex(ctx context.Context) (*PackageIndex, error) {
+	revision, err := GetNixpkgsRevision(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := packageIndexPath()
+	if err != nil {
+		return nil, err
+	}
+
+	var cached *PackageIndex
+	if data, err := os.ReadFile(path); err == nil {
+		var index PackageIndex
+		if err := json.Unmarshal(data, &index); err == nil && len(index.Packages) > 0 {
+			if index.Revision == revision {
+				index.buildLookup()
+				return &index, nil
+			}
+			cached = &index
+			Debug("Package index is for nixpkgs %s, updating to %s", index.Revision, revision)
+		}
+	}
+
+	index, err := BuildPackageIndex(ctx, revision, cached)
+	if err != nil {
+		return nil, err
+	}
+	recordIndexVersions(index)
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteFileAtomic(path, data, 0600); err != nil {
+		Debug("Could not cache package index: %v", err)
+	}
+	return index, nil
+}
+
+// nixEnvPackage is one entry of "nix-env -qaP --json --meta"
+type nixEnvPackage struct {
+	Name    string `json:"name"`
+	Pname   string `json:"pname"`
+	Version string `json:"version"`
+	// Outputs is only reported with --out-path
+	Outputs map[string]string `json:"outputs"`
+	Meta    struct {
+		Description string          `json:"description"`
+		Homepage    json.RawMessage `json:"homepage"`
+		License     json.RawMessage `json:"license"`
+		Platforms   json.RawMessage `json:"platforms"`
+	} `json:"meta"`
+}
+
+// BuildPackageIndex queries the packages of the configured channel with
+// nix-env. A previous index of the same revision is returned as is, without
+// a query. Otherwise entries whose searchable metadata did not change keep
+// their search terms, so only new and changed packages are tokenized.
+func BuildPackageIndex(ctx context.Context, revision string, previous *PackageIndex) (*PackageIndex, error) {
+	if previous != nil && revision != "" && previous.Revision == revision {
+		if previous.byAttr == nil {
+			previous.buildLookup()
+		}
+		return previous, nil
+	}
+
+	Info("📚 Indexing nixpkgs packages (this only happens once per revision)...")
+	args := append([]string{"-f", "<nixpkgs>"}, nixpkgsPathArgs()...)
+	args = append(args, "-qaP", "--json", "--meta")
+	output, err := nixOutput(ctx, "nix-env", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query nixpkgs packages: %w", err)
+	}
+
+	var entries map[string]nixEnvPackage
+	if err := json.Unmarshal(output, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse nixpkgs packages: %w", err)
+	}
+
+	if previous != nil && previous.byAttr == nil {
+		previous.buildLookup()
+	}
+
+	index := &PackageIndex{Revision: revision, Packages: make([]IndexedPackage, 0, len(entries))}
+	reused := 0
+	for attr, entry := range entries {
+		pkg := IndexedPackage{
+			Attr:        attr,
+			Pname:       entry.Pname,
+			Version:     entry.Version,
+			Description: entry.Meta.Description,
+			Licenses:    parseLicenses(entry.Meta.License),
+			Platforms:   parsePlatforms(entry.Meta.Platforms),
+		}
+		if pkg.Pname == "" {
+			pkg.Pname = entry.Name
+		}
+
+		if old, ok := previous.lookup(attr); ok && sameTermFields(old, pkg) {
+			pkg.Terms = old.Terms
+			reused++
+		} else {
+			pkg.Terms = packageTerms(pkg)
+		}
+		index.Packages = append(index.Packages, pkg)
+	}
+	sort.Slice(index.Packages, func(i, j int) bool { return index.Packages[i].Attr < index.Packages[j].Attr })
+	index.buildLookup()
+
+	Debug("Indexed %d packages (%d unchanged)", len(index.Packages), reused)
+	return index, nil
+}
+
+// parseLicenses extracts license identifiers from a meta.license value, which
+// may be a string, an attribute set or a list of either
+func parseLicenses(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		list = []json.RawMessage{raw}
+	}
+
+	var licenses []string
+	for _, item := range list {
+		var name string
+		if err := json.Unmarshal(item, &name); err == nil {
+			licenses = append(licenses, name)
+			continue
+		}
+		var license struct {
+			SpdxID    string `json:"spdxId"`
+			ShortName string `json:"shortName"`
+		}
+		if err := json.Unmarshal(item, &license); err == nil {
+			if license.SpdxID != "" {
+				licenses = append(licenses, license.SpdxID)
+			} else if license.ShortName != "" {
+				licenses = append(licenses, license.ShortName)
+			}
+		}
+	}
+	return licenses
+}
+
+// parsePlatforms extracts the system names from a meta.platforms list
+func parsePlatforms(raw json.RawMessage) []string {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil
+	}
+
+	var platforms []string
+	for _, item := range items {
+		var system string
+		if err := json.Unmarshal(item, &system); err == nil {
+			platforms = append(platforms, system)
+		}
+	}
+	return platforms
+}
+
+// packageTerms returns the distinct search terms of a package
+func packageTerms(pkg IndexedPackage) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	fields := append([]string{pkg.Attr, pkg.Pname, pkg.Version, pkg.Description}, pkg.Licenses...)
+	for _, field := range fields {
+		for _, term := range tokenize(field) {
+			if !seen[term] {
+				seen[term] = true
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
+
+// sameTermFields reports whether two entries of a package agree on every
+// field that packageTerms tokenizes
+func sameTermFields(a, b IndexedPackage) bool {
+	return a.Attr == b.Attr && a.Pname == b.Pname && a.Version == b.Version &&
+		a.Description == b.Description && slices.Equal(a.Licenses, b.Licenses)
+}
+
+// tokenize splits text into lowercase alphanumeric search terms
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// buildLookup builds the in-memory name list and inverted index
+func (idx *PackageIndex) buildLookup() {
+	idx.Names = make([]string, len(idx.Packages))
+	idx.byAttr = make(map[string]int, len(idx.Packages))
+	idx.postings = make(map[string][]int)
+	for i, pkg := range idx.Packages {
+		idx.Names[i] = pkg.Attr
+		idx.byAttr[pkg.Attr] = i
+		for _, term := range pkg.Terms {
+			idx.postings[term] = append(idx.postings[term], i)
+		}
+	}
+	sort.Strings(idx.Names)
+
+	idx.terms = make([]string, 0, len(idx.postings))
+	for term := range idx.postings {
+		idx.terms = append(idx.terms, term)
+	}
+	sort.Strings(idx.terms)
+}
+
+// lookup returns the indexed package with the given attribute name
+func (idx *PackageIndex) lookup(attr string) (IndexedPackage, bool) {
+	if idx == nil {
+		return IndexedPackage{}, false
+	}
+	i, ok := idx.byAttr[attr]
+	if !ok {
+		return IndexedPackage{}, false
+	}
+	return idx.Packages[i], true
+}
+
+// Contains reports whether the index lists the attribute name
+func (idx *PackageIndex) Contains(name string) bool {
+	_, ok := idx.lookup(name)
+	return ok
+}
+
+// Get returns the indexed metadata of a package
+func (idx *PackageIndex) Get(name string) (IndexedPackage, bool) {
+	return idx.lookup(name)
+}
+
+// Suggest returns up to limit indexed names that look like a misspelling of name
+func (idx *PackageIndex) Suggest(name string, limit int) []string {
+	return SuggestPackages(name, idx.Names, limit)
+}
+
+// SearchOptions narrows down search results
+type SearchOptions struct {
+	// License keeps packages with a license containing this text
+	License string
+	// Platform keeps packages available on this system, such as x86_64-linux
+	Platform string
+	// Limit caps the number of results; zero means no limit
+	Limit int
+}
+
+// SearchResult is a package matching a search query
+type SearchResult struct {
+	Attr        string   `json:"attr"`
+	Pname       string   `json:"pname"`
+	Version     string   `json:"version"`
+	Description string   `json:"description"`
+	Licenses    []string `json:"licenses"`
+	Platforms   []string `json:"platforms"`
+	Score       float64  `json:"score"`
+}
+
+// Search returns the packages matching every word of query, best matches first
+func (idx *PackageIndex) Search(query string, opts SearchOptions) []SearchResult {
+	words := tokenize(query)
+	if len(words) == 0 {
+		return nil
+	}
+
+	// Intersect the candidates of each query word, matching term prefixes
+	var candidates map[int]bool
+	for _, word := range words {
+		matched := make(map[int]bool)
+		for i := sort.SearchStrings(idx.terms, word); i < len(idx.terms) && strings.HasPrefix(idx.terms[i], word); i++ {
+			for _, pkg := range idx.postings[idx.terms[i]] {
+				if candidates == nil || candidates[pkg] {
+					matched[pkg] = true
+				}
+			}
+		}
+		candidates = matched
+	}
+
+	needle := strings.ToLower(strings.TrimSpace(query))
+	var results []SearchResult
+	for i := range candidates {
+		pkg := idx.Packages[i]
+		if !matchesFilters(pkg, opts) {
+			continue
+		}
+		results = append(results, SearchResult{
+			Attr:        pkg.Attr,
+			Pname:       pkg.Pname,
+			Version:     pkg.Version,
+			Description: pkg.Description,
+			Licenses:    pkg.Licenses,
+			Platforms:   pkg.Platforms,
+			Score:       scorePackage(pkg, needle, words),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Attr < results[j].Attr
+	})
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+	return results
+}
+
+// matchesFilters applies the license and platform filters
+func matchesFilters(pkg IndexedPackage, opts SearchOptions) bool {
+	if opts.License != "" {
+		found := false
+		for _, license := range pkg.Licenses {
+			if strings.Contains(strings.ToLower(license), strings.ToLower(opts.License)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if opts.Platform != "" {
+		found := false
+		for _, platform := range pkg.Platforms {
+			if platform == opts.Platform {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// scorePackage ranks name matches above description matches
+func scorePackage(pkg IndexedPackage, needle string, words []string) float64 {
+	attr := strings.ToLower(pkg.Attr)
+	pname := strings.ToLower(pkg.Pname)
+
+	score := 0.0
+	switch {
+	case attr == needle:
+		score += 100
+	case pname == needle:
+		score += 80
+	case strings.HasPrefix(attr, needle):
+		score += 50
+	case strings.Contains(attr, needle):
+		score += 25
+	}
+
+	description := tokenize(pkg.Description)
+	for _, word := range words {
+		for _, term := range description {
+			if term == word {
+				score += 5
+			} else if strings.HasPrefix(term, word) {
+				score += 2
+			}
+		}
+	}
+
+	// Prefer shorter attribute names among otherwise equal matches
+	return score - float64(len(attr))/100
+}
+
+// SuggestPackages ranks candidates by edit distance and prefix match against
+// name and returns up to limit of the closest ones
+func SuggestPackages(name string, candidates []string, limit int) []string {
+	target := strings.ToLower(name)
+	threshold := len(target) / 3
+	if threshold < 2 {
+		threshold = 2
+	}
+
+	type match struct {
+		name  string
+		score float64
+	}
+	var matches []match
+	for _, candidate := range candidates {
+		lower := strings.ToLower(candidate)
+		if lower == target {
+			continue
+		}
+
+		prefix := strings.HasPrefix(lower, target)
+		if !prefix && abs(len(lower)-len(target)) > threshold {
+			continue
+		}
+
+		score := float64(editDistance(target, lower))
+		if prefix {
+			// Longer completions of the name rank after closer ones
+			if p := 1 + float64(len(lower)-len(target))/10; p < score {
+				score = p
+			}
+		}
+		if score <= float64(threshold) {
+			matches = append(matches, match{candidate, score})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score < matches[j].score
+		}
+		if len(matches[i].name) != len(matches[j].name) {
+			return len(matches[i].name) < len(matches[j].name)
+		}
+		return matches[i].name < matches[j].name
+	})
+
+	var suggestions []string
+	for i := 0; i < len(matches) && i < limit; i++ {
+		suggestions = append(suggestions, matches[i].name)
+	}
+	return suggestions
+}
+
+// editDistance computes the optimal string alignment distance between a and
+// b: insertions, deletions, substitutions and adjacent transpositions
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(b)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PackageInfo is the record of one package, looked up by its exact
+// attribute path in a nixpkgs snapshot or the user profile
+type PackageInfo struct {
+	AttrPath string `json:"attrPath"`
+	Name     string `json:"name"`
+	Pname    string `json:"pname"`
+	Version  string `json:"version"`
+	// Outputs maps each output of the package to its store path
+	Outputs   map[string]string `json:"outputs"`
+	StorePath string            `json:"storePath"`
+	Meta      PackageMeta       `json:"meta"`
+}
+
+// PackageMeta is the part of a package's meta attribute NSM reports
+type PackageMeta struct {
+	Description string   `json:"description,omitempty"`
+	Homepage    string   `json:"homepage,omitempty"`
+	Licenses    []string `json:"licenses,omitempty"`
+}
+
+// mainStorePath returns the store path of the "out" output, or of the first
+// output by name for packages without one
+func mainStorePath(outputs map[string]string) string {
+	if path, ok := outputs["out"]; ok {
+		return path
+	}
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return ""
+	}
+	return outputs[names[0]]
+}
+
+// packageInfoExpr evaluates packages by exact attribute path. The first
+// argument is the nixpkgs expression, the second the attrset entries binding
+// attribute paths to "info <set> <path>". Missing packages evaluate to null.
+const packageInfoExpr = `let
+  pkgs = %s;
+  lib = pkgs.lib;
+  info = set: p:
+    let path = lib.splitString "." p; in
+    if !(lib.hasAttrByPath path set) then null else
+    let
+      pkg = lib.getAttrFromPath path set;
+      meta = pkg.meta or {};
+      homepage = lib.toList (meta.homepage or []);
+      paths = lib.genAttrs (pkg.outputs or [ "out" ])
+        (o: builtins.unsafeDiscardStringContext pkg.${o}.outPath);
+      outputs = builtins.tryEval (builtins.deepSeq paths paths);
+    in {
+      name = pkg.name or "";
+      pname = pkg.pname or "";
+      version = pkg.version or "";
+      outputs = if outputs.success then outputs.value else {};
+      meta = {
+        description = meta.description or "";
+        homepage = if homepage == [] then "" else builtins.head homepage;
+        licenses = map (l: if builtins.isString l then l else l.spdxId or l.shortName or "unknown")
+          (lib.toList (meta.license or []));
+      };
+    };
+in {
+  revision = lib.trivial.revisionWithDefault "";
+  packages = {
+    %s
+  };
+}`
+
+// evalPackageInfo looks up attrs in a nixpkgs expression with eval. Packages
+// with an entry in pins are looked up in their pinned snapshot instead.
+// Attribute paths that are not valid or not found are left out of the
+// result, which also returns the revision the nixpkgs expression reports.
+func evalPackageInfo(eval func(expr string, v interface{}) error, nixpkgs string, attrs []string,
+	pins map[string]NixpkgsSource) (map[string]PackageInfo, string, error) {
+	names := append([]string(nil), attrs...)
+	sort.Strings(names)
+
+	var entries []string
+	for i, attr := range names {
+		if (i > 0 && names[i-1] == attr) || !ValidatePackage(attr) {
+			continue
+		}
+		set := "pkgs"
+		if source, ok := pins[attr]; ok {
+			set = fmt.Sprintf("(import (fetchTarball %s) {})", QuoteNixString(NixpkgsTarballURL(source.Revision)))
+		}
+		entries = append(entries, fmt.Sprintf("%s = info %s %s;", QuoteNixString(attr), set, QuoteNixString(attr)))
+	}
+
+	var result struct {
+		Revision string                  `json:"revision"`
+		Packages map[string]*PackageInfo `json:"packages"`
+	}
+	if err := eval(fmt.Sprintf(packageInfoExpr, nixpkgs, strings.Join(entries, "\n    ")), &result); err != nil {
+		return nil, "", err
+	}
+
+	packages := make(map[string]PackageInfo)
+	for attr, info := range result.Packages {
+		if info == nil {
+			continue
+		}
+		info.AttrPath = attr
+		if info.Outputs == nil {
+			info.Outputs = make(map[string]string)
+		}
+		info.StorePath = mainStorePath(info.Outputs)
+		packages[attr] = *info
+	}
+	return packages, result.Revision, nil
+}
+
+// LookupPackages evaluates attrs by exact attribute path in the nixpkgs the
+// project builds against. Packages with an entry in pins are evaluated in
+// their pinned snapshot. Packages that do not exist are left out.
+func LookupPackages(ctx context.Context, configFile string, attrs []string, pins map[string]NixpkgsSource) (map[string]PackageInfo, error) {
+	packages, _, err := lookupProjectPackages(ctx, configFile, attrs, pins)
+	return packages, err
+}
+
+// lookupProjectPackages is LookupPackages, also returning the revision of
+// the project's nixpkgs
+func lookupProjectPackages(ctx context.Context, configFile string, attrs []string, pins map[string]NixpkgsSource) (map[string]PackageInfo, string, error) {
+	nixpkgs, err := ProjectNixpkgsExpr(configFile)
+	if err != nil {
+		return nil, "", err
+	}
+	eval := func(expr string, v interface{}) error {
+		return EvalNixJSON(ctx, configFile, expr, v)
+	}
+	return evalPackageInfo(eval, nixpkgs, attrs, pins)
+}
+
+// lookupChannelPackage evaluates attr in the configured channel and returns
+// it together with the channel's nixpkgs revision. The package is nil when
+// the channel does not provide it.
+func lookupChannelPackage(ctx context.Context, attr string) (*PackageInfo, string, error) {
+	return lookupPackageIn(ctx, attr, "import <nixpkgs> {}", nixpkgsPathArgs())
+}
+
+// lookupRevisionPackage evaluates attr in a nixpkgs commit. The package is
+// nil when the commit does not provide it.
+func lookupRevisionPackage(ctx context.Context, attr, revision string) (*PackageInfo, error) {
+	nixpkgs := fmt.Sprintf("import (fetchTarball %s) {}", QuoteNixString(NixpkgsTarballURL(revision)))
+	info, _, err := lookupPackageIn(ctx, attr, nixpkgs, nil)
+	return info, err
+}
+
+// lookupPackageIn evaluates attr in a nixpkgs expression with nix-instantiate
+// and returns it together with the revision the expression reports
+func lookupPackageIn(ctx context.Context, attr, nixpkgs string, args []string) (*PackageInfo, string, error) {
+	eval := func(expr string, v interface{}) error {
+		evalArgs := append(append([]string(nil), args...), "--eval", "--strict", "--json", "-E", expr)
+		output, err := nixOutput(ctx, "nix-instantiate", evalArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate %s: %w", attr, err)
+		}
+		if err := json.Unmarshal(output, v); err != nil {
+			return fmt.Errorf("failed to parse evaluation result: %w", err)
+		}
+		return nil
+	}
+
+	packages, revision, err := evalPackageInfo(eval, nixpkgs, []string{attr}, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if info, ok := packages[attr]; ok {
+		return &info, revision, nil
+	}
+	return nil, revision, nil
+}
+
+// ProfilePackages is the parsed result of a single nix-env query of the user
+// profile, keyed by the attribute path nix-env reports, such as nixpkgs.gcc.
+// Lookups reuse it instead of querying nix-env once per package.
+type ProfilePackages map[string]PackageInfo
+
+// profileAttrPath strips the channel from an attribute path reported by
+// nix-env, so nixpkgs.python3Packages.numpy becomes python3Packages.numpy
+func profileAttrPath(key string) string {
+	if _, attr, ok := strings.Cut(key, "."); ok {
+		return attr
+	}
+	return key
+}
+
+// QueryProfilePackages queries the packages installed in the user profile
+func QueryProfilePackages(ctx context.Context) (ProfilePackages, error) {
+	output, err := nixOutput(ctx, "nix-env", "--query", "--installed", "--attr-path", "--out-path", "--meta", "--json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query package info: %w", err)
+	}
+
+	var entries map[string]nixEnvPackage
+	if err := json.Unmarshal(output, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse package info: %w", err)
+	}
+
+	packages := make(ProfilePackages, len(entries))
+	for key, entry := range entries {
+		outputs := entry.Outputs
+		if outputs == nil {
+			outputs = make(map[string]string)
+		}
+		packages[key] = PackageInfo{
+			AttrPath:  profileAttrPath(key),
+			Name:      entry.Name,
+			Pname:     entry.Pname,
+			Version:   entry.Version,
+			Outputs:   outputs,
+			StorePath: mainStorePath(outputs),
+			Meta: PackageMeta{
+				Description: entry.Meta.Description,
+				Homepage:    parseHomepage(entry.Meta.Homepage),
+				Licenses:    parseLicenses(entry.Meta.License),
+			},
+		}
+	}
+	return packages, nil
+}
+
+// List returns the installed packages sorted by attribute path
+func (p ProfilePackages) List() []PackageInfo {
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	packages := make([]PackageInfo, 0, len(keys))
+	for _, key := range keys {
+		packages = append(packages, p[key])
+	}
+	sort.SliceStable(packages, func(i, j int) bool { return packages[i].AttrPath < packages[j].AttrPath })
+	return packages
+}
+
+// Lookup returns the installed package with exactly the attribute path attr,
+// with or without its channel. When several channels provide it, the first
+// channel by name wins.
+func (p ProfilePackages) Lookup(attr string) (PackageInfo, bool) {
+	if info, ok := p[attr]; ok {
+		return info, true
+	}
+	for _, info := range p.List() {
+		if info.AttrPath == attr {
+			return info, true
+		}
+	}
+	return PackageInfo{}, false
+}
+
+// Version returns the version of an installed package
+func (p ProfilePackages) Version(attr string) (string, bool) {
+	info, ok := p.Lookup(attr)
+	return info.Version, ok
+}
+
+// parseHomepage returns the first URL of a meta.homepage value, which is a
+// string or a list of strings
+func parseHomepage(raw json.RawMessage) string {
+	var homepage string
+	if json.Unmarshal(raw, &homepage) == nil {
+		return homepage
+	}
+	var homepages []string
+	if json.Unmarshal(raw, &homepages) == nil && len(homepages) > 0 {
+		return homepages[0]
+	}
+	return ""
+}
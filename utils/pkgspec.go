@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// versionConstraint matches the version part of a package spec, such as 3.11
+// or v18.17.1
+var versionConstraint = regexp.MustCompile(`^v?[0-9][0-9A-Za-z._+-]*$`)
+
+// PackageSpec is a package argument: an attribute path with an optional
+// version constraint, written as python3@3.11
+type PackageSpec struct {
+	Attr    string
+	Version string
+}
+
+// String renders the spec as it is written on the command line
+func (s PackageSpec) String() string {
+	if s.Version == "" {
+		return s.Attr
+	}
+	return s.Attr + "@" + s.Version
+}
+
+// ParsePackageSpec parses attrpath[@version]. The "pkgs." prefix is removed
+// from the attribute path.
+func ParsePackageSpec(spec string) (PackageSpec, error) {
+	attr, version, versioned := strings.Cut(spec, "@")
+	attr = NormalizePackageName(strings.TrimSpace(attr))
+	if !ValidatePackage(attr) {
+		return PackageSpec{}, fmt.Errorf("invalid package name: %s", attr)
+	}
+	if versioned && !versionConstraint.MatchString(version) {
+		return PackageSpec{}, fmt.Errorf("invalid version %q for %s", version, attr)
+	}
+	return PackageSpec{Attr: attr, Version: strings.TrimPrefix(version, "v")}, nil
+}
+
+// ValidatePackageSpec checks that spec is an attribute path with an optional
+// @version constraint
+func ValidatePackageSpec(spec string) bool {
+	_, err := ParsePackageSpec(spec)
+	return err == nil
+}
+
+// VersionedAttrCandidates returns the attribute names nixpkgs commonly uses
+// for a specific version of a package, most specific first: python3@3.11
+// gives python311, nodejs@18 gives nodejs18 and nodejs_18.
+func VersionedAttrCandidates(spec PackageSpec) []string {
+	parts := strings.FieldsFunc(spec.Version, func(r rune) bool { return r == '.' || r == '-' || r == '_' })
+	if len(parts) == 0 {
+		return nil
+	}
+
+	// python3@3.11 is python311, not python3311
+	bases := []string{spec.Attr}
+	if trimmed := strings.TrimSuffix(spec.Attr, parts[0]); trimmed != spec.Attr && trimmed != "" {
+		bases = []string{trimmed, spec.Attr}
+	}
+
+	seen := make(map[string]bool)
+	var candidates []string
+	for n := len(parts); n >= 1; n-- {
+		for _, base := range bases {
+			for _, name := range []string{
+				base + strings.Join(parts[:n], ""),
+				base + "_" + strings.Join(parts[:n], "_"),
+			} {
+				if name != spec.Attr && !seen[name] && ValidatePackage(name) {
+					seen[name] = true
+					candidates = append(candidates, name)
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+// SpecResolution is the outcome of resolving a package spec
+type SpecResolution struct {
+	Spec PackageSpec
+	// Attr is the attribute to add to the package list
+	Attr string
+	// Version is the version Attr provides
+	Version string
+	// Source is the pinned nixpkgs snapshot providing Attr, or nil when the
+	// project's own nixpkgs provides it
+	Source *NixpkgsSource
+}
+
+// ResolvePackageSpec finds the attribute that satisfies a versioned package
+// spec. The project's nixpkgs is searched for the attribute itself and its
+// versioned variants first; otherwise the version history supplies a nixpkgs
+// revision to import the package from.
+func ResolvePackageSpec(ctx context.Context, configFile string, spec PackageSpec) (*SpecResolution, error) {
+	if spec.Version == "" {
+		return &SpecResolution{Spec: spec, Attr: spec.Attr}, nil
+	}
+
+	candidates := append([]string{spec.Attr}, VersionedAttrCandidates(spec)...)
+	versions, err := PackageVersions(ctx, configFile, candidates, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		if version := versions[candidate]; version != "" && VersionMatches(version, spec.Version) {
+			return &SpecResolution{Spec: spec, Attr: candidate, Version: version}, nil
+		}
+	}
+
+	record, err := ResolveVersion(ctx, spec.Attr, spec.Version)
+	if err != nil {
+		return nil, fmt.Errorf("no nixpkgs attribute or known revision provides %s: %w", spec, err)
+	}
+	source := record.Source()
+	return &SpecResolution{Spec: spec, Attr: spec.Attr, Version: record.Version, Source: &source}, nil
+}
+
+// PackageVersions evaluates the versions of pkgs in the project's nixpkgs.
+// Packages with an entry in pins are evaluated in their pinned snapshot.
+// Missing packages and packages without a version map to "".
+func PackageVersions(ctx context.Context, configFile string, pkgs []string, pins map[string]NixpkgsSource) (map[string]string, error) {
+	versions := make(map[string]string)
+	if len(pkgs) == 0 {
+		return versions, nil
+	}
+
+	packages, err := LookupPackages(ctx, configFile, pkgs, pins)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range pkgs {
+		versions[pkg] = packages[pkg].Version
+	}
+	return versions, nil
+}
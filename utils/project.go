@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/spf13/viper"
+)
+
+// ConfigTypes are the files that define the shell of a project. nix-shell
+// reads default.nix when there is no shell.nix.
+var ConfigTypes = []string{"shell.nix", "flake.nix", "default.nix"}
+
+// projectFile is the configuration file chosen with --file, used instead of
+// looking for one
+var projectFile string
+
+// SetProjectFile makes GetProjectConfigType use configType, which must be
+// one of ConfigTypes, rather than picking one. An empty configType restores
+// the default.
+func SetProjectFile(configType string) error {
+	if configType != "" && !slices.Contains(ConfigTypes, configType) {
+		return fmt.Errorf("%w: project file must be shell.nix, flake.nix or default.nix, not %s", ErrValidationFailed, configType)
+	}
+	projectFile = configType
+	return nil
+}
+
+// ProjectFile returns the configuration file chosen with SetProjectFile
+func ProjectFile() string {
+	return projectFile
+}
+
+// preferredConfigTypes orders ConfigTypes with the shell.format setting
+// first
+func preferredConfigTypes() []string {
+	preferred := viper.GetString("shell.format")
+	if !slices.Contains(ConfigTypes, preferred) {
+		return ConfigTypes
+	}
+	ordered := []string{preferred}
+	for _, configType := range ConfigTypes {
+		if configType != preferred {
+			ordered = append(ordered, configType)
+		}
+	}
+	return ordered
+}
+
+// ProjectConfigFiles returns the ConfigTypes present in dir. When there are
+// several, the shell.format setting picks one.
+func ProjectConfigFiles(dir string) []string {
+	var found []string
+	for _, configType := range ConfigTypes {
+		if info, err := os.Stat(filepath.Join(dir, configType)); err == nil && !info.IsDir() {
+			found = append(found, configType)
+		}
+	}
+	return found
+}
+
+// FindProjectDir returns the nearest directory, starting at start and
+// walking up its parents, that contains one of ConfigTypes. Like git,
+// the search stops at the root of a git repository and does not cross into
+// another filesystem. It returns ErrNoProjectConfig when there is no such
+// directory.
+func FindProjectDir(start string) (string, error) {
+	dir, err := filepath.Abs(start)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		if len(ProjectConfigFiles(dir)) > 0 {
+			return dir, nil
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		parentInfo, err := os.Stat(parent)
+		if err != nil || !sameFilesystem(info, parentInfo) {
+			break
+		}
+		dir, info = parent, parentInfo
+	}
+	return "", ErrNoProjectConfig
+}
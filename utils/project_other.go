@@ -0,0 +1,11 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// sameFilesystem cannot tell devices apart on this platform, so the project
+// search only stops at git roots and the top of the volume
+func sameFilesystem(a, b os.FileInfo) bool {
+	return true
+}
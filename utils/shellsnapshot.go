@@ -0,0 +1,372 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// ShellPackage is a package declared by the project shell, as evaluated
+type ShellPackage struct {
+	Attr      string `json:"attr"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	StorePath string `json:"storePath"`
+	// Outputs maps each output of the package to its store path
+	Outputs map[string]string `json:"outputs"`
+	// OutputHashes maps outputs to the NAR hash of their store path. Only
+	// outputs that are built or cached have a hash.
+	OutputHashes map[string]string `json:"outputHashes,omitempty"`
+	// Nixpkgs is the snapshot the package is evaluated from
+	Nixpkgs NixpkgsSource `json:"nixpkgs"`
+}
+
+// ShellSnapshot is the evaluated package set of a project shell
+type ShellSnapshot struct {
+	ConfigType string
+	// Shell is the named shell of a flake, empty for the default shell
+	Shell string
+	// Nixpkgs is the snapshot the shell builds against
+	Nixpkgs  NixpkgsSource
+	Packages []ShellPackage
+	// Unresolved lists declared elements that are not packages of their
+	// nixpkgs, such as python3.withPackages calls
+	Unresolved []string
+}
+
+// SnapshotShell evaluates every package that the named shell of the
+// project's shell.nix or flake.nix declares, together with the nixpkgs
+// snapshot it comes from. An empty shell is the default shell. All packages
+// are evaluated at once; only the hashes of nixpkgs revisions that are not
+// known yet are fetched separately, in parallel.
+func SnapshotShell(ctx context.Context, configFile, shell string) (*ShellSnapshot, error) {
+	content, err := ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	editor, err := NewNixEditor(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configFile, err)
+	}
+	if err := editor.SelectShell(shell); err != nil {
+		return nil, fmt.Errorf("%s: %w", configFile, err)
+	}
+
+	pins := editor.PinnedPackages()
+	declared := editor.Packages()
+	sort.Strings(declared)
+
+	packages, revision, err := lookupProjectPackages(ctx, configFile, declared, pins)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &ShellSnapshot{ConfigType: configFile, Shell: shell}
+	snapshot.Nixpkgs, err = projectNixpkgsSource(configFile, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, pkg := range declared {
+		info, ok := packages[pkg]
+		if !ok {
+			if !slices.Contains(snapshot.Unresolved, pkg) {
+				snapshot.Unresolved = append(snapshot.Unresolved, pkg)
+			}
+			continue
+		}
+		if len(snapshot.Packages) > 0 && snapshot.Packages[len(snapshot.Packages)-1].Attr == pkg {
+			continue
+		}
+
+		source := snapshot.Nixpkgs
+		if pin, ok := pins[pkg]; ok {
+			source = pin
+		}
+		shellPkg := ShellPackage{
+			Attr:      pkg,
+			Name:      info.Name,
+			Version:   info.Version,
+			StorePath: info.StorePath,
+			Outputs:   info.Outputs,
+			Nixpkgs:   source,
+		}
+		for _, path := range info.Outputs {
+			paths = append(paths, path)
+		}
+		snapshot.Packages = append(snapshot.Packages, shellPkg)
+	}
+
+	// Fill in the NAR hashes of outputs that are built or cached, and of
+	// the nixpkgs revisions
+	hashes := GetNarHashes(ctx, paths)
+	var revisions []string
+	if snapshot.Nixpkgs.NarHash == "" {
+		revisions = append(revisions, snapshot.Nixpkgs.Revision)
+	}
+	for i := range snapshot.Packages {
+		pkg := &snapshot.Packages[i]
+		for output, path := range pkg.Outputs {
+			if hash := hashes[path]; hash != "" {
+				if pkg.OutputHashes == nil {
+					pkg.OutputHashes = make(map[string]string)
+				}
+				pkg.OutputHashes[output] = hash
+			}
+		}
+		if pkg.Nixpkgs.NarHash == "" {
+			revisions = append(revisions, pkg.Nixpkgs.Revision)
+		}
+	}
+
+	narHashes := NixpkgsNarHashes(ctx, revisions)
+	if snapshot.Nixpkgs.NarHash == "" {
+		snapshot.Nixpkgs.NarHash = narHashes[snapshot.Nixpkgs.Revision]
+	}
+	for i := range snapshot.Packages {
+		pkg := &snapshot.Packages[i]
+		if pkg.Nixpkgs.NarHash == "" {
+			if pkg.Nixpkgs.Revision == snapshot.Nixpkgs.Revision {
+				pkg.Nixpkgs.NarHash = snapshot.Nixpkgs.NarHash
+			} else {
+				pkg.Nixpkgs.NarHash = narHashes[pkg.Nixpkgs.Revision]
+			}
+		}
+	}
+	return snapshot, nil
+}
+
+// projectNixpkgsSource returns the nixpkgs snapshot a project builds against.
+// Flakes take it from the nixpkgs input locked in flake.lock; shell.nix uses
+// the revision its nixpkgs evaluated to, without a hash.
+func projectNixpkgsSource(configFile, evaluatedRevision string) (NixpkgsSource, error) {
+	if isFlake(configFile) {
+		lockFile := filepath.Join(filepath.Dir(configFile), "flake.lock")
+		source, err := ReadFlakeLockInput(lockFile, "nixpkgs")
+		if err == nil {
+			return source, nil
+		}
+		if !os.IsNotExist(err) {
+			return NixpkgsSource{}, err
+		}
+		Debug("No flake.lock yet, using the evaluated nixpkgs revision")
+	}
+
+	return NixpkgsSource{Revision: evaluatedRevision}, nil
+}
+
+// NixpkgsNarHashes returns the source hash of each nixpkgs revision. Hashes
+// are taken from the version history; unknown revisions are fetched on up to
+// NixJobs workers. Revisions whose hash cannot be determined are left out.
+func NixpkgsNarHashes(ctx context.Context, revisions []string) map[string]string {
+	hashes := make(map[string]string)
+	db, err := LoadVersionDB()
+	if err != nil {
+		Debug("Could not load version database: %v", err)
+	}
+
+	var missing []string
+	for _, revision := range revisions {
+		if !IsNixpkgsCommit(revision) || slices.Contains(missing, revision) {
+			continue
+		}
+		if db != nil && db.Revisions[revision].NarHash != "" {
+			hashes[revision] = db.Revisions[revision].NarHash
+			continue
+		}
+		missing = append(missing, revision)
+	}
+
+	var mu sync.Mutex
+	err = ForEach(ctx, NixJobs, missing, func(ctx context.Context, revision string) error {
+		narHash, _, err := PrefetchNixpkgs(ctx, revision)
+		if err != nil {
+			Debug("Could not determine the hash of nixpkgs %s: %v", revision, err)
+			return nil
+		}
+		mu.Lock()
+		hashes[revision] = narHash
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		Debug("Stopped fetching nixpkgs hashes: %v", err)
+	}
+
+	// Remember the fetched hashes so later runs do not download the
+	// revisions again
+	if db != nil {
+		updated := false
+		for _, revision := range missing {
+			if hashes[revision] != "" {
+				db.SetNarHash(revision, hashes[revision])
+				updated = true
+			}
+		}
+		if updated {
+			if err := db.Save(); err != nil {
+				Debug("Could not save version database: %v", err)
+			}
+		}
+	}
+	return hashes
+}
+
+// ReadFlakeLockInput returns the locked revision and hash of a direct input
+// of the root flake in a flake.lock file
+func ReadFlakeLockInput(lockFile, input string) (NixpkgsSource, error) {
+	data, err := os.ReadFile(lockFile)
+	if err != nil {
+		return NixpkgsSource{}, err
+	}
+
+	var lock struct {
+		Root  string `json:"root"`
+		Nodes map[string]struct {
+			Inputs map[string]json.RawMessage `json:"inputs"`
+			Locked struct {
+				Rev     string `json:"rev"`
+				NarHash string `json:"narHash"`
+			} `json:"locked"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return NixpkgsSource{}, fmt.Errorf("failed to parse %s: %w", lockFile, err)
+	}
+
+	root, ok := lock.Nodes[lock.Root]
+	if !ok {
+		return NixpkgsSource{}, fmt.Errorf("%s has no root node", lockFile)
+	}
+	// Inputs refer to a node by name; follows are lists of input names
+	var node string
+	if err := json.Unmarshal(root.Inputs[input], &node); err != nil {
+		return NixpkgsSource{}, fmt.Errorf("%s does not lock a %s input", lockFile, input)
+	}
+	locked, ok := lock.Nodes[node]
+	if !ok || locked.Locked.Rev == "" {
+		return NixpkgsSource{}, fmt.Errorf("%s does not lock a revision for %s", lockFile, input)
+	}
+	return NixpkgsSource{Revision: locked.Locked.Rev, NarHash: locked.Locked.NarHash}, nil
+}
+
+// GetNarHashes returns the NAR hash of each store path that is valid in the
+// local store or the binary cache. Paths without a hash are left out.
+func GetNarHashes(ctx context.Context, paths []string) map[string]string {
+	hashes := make(map[string]string)
+	if len(paths) == 0 {
+		return hashes
+	}
+
+	for _, store := range [][]string{nil, {"--store", "https://cache.nixos.org"}} {
+		var missing []string
+		for _, path := range paths {
+			if hashes[path] == "" {
+				missing = append(missing, path)
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+
+		for path, hash := range queryNarHashes(ctx, store, missing) {
+			hashes[path] = hash
+		}
+	}
+	return hashes
+}
+
+// queryNarHashes asks a store for the NAR hashes of paths in one query. Some
+// Nix versions fail the whole query when one path is not valid, so a failed
+// query is repeated for each path on its own.
+func queryNarHashes(ctx context.Context, store, paths []string) map[string]string {
+	query := func(ctx context.Context, paths []string) (map[string]string, error) {
+		args := append(append([]string{"path-info", "--json"}, store...), paths...)
+		output, err := nixOutput(ctx, "nix", args...)
+		if err != nil {
+			return nil, err
+		}
+		return parseNarHashes(output), nil
+	}
+
+	hashes, err := query(ctx, paths)
+	if err == nil {
+		return hashes
+	}
+	Debug("Could not query store path hashes: %v", err)
+	hashes = make(map[string]string)
+	if len(paths) == 1 {
+		return hashes
+	}
+
+	var mu sync.Mutex
+	err = ForEach(ctx, NixJobs, paths, func(ctx context.Context, path string) error {
+		found, err := query(ctx, []string{path})
+		if err != nil {
+			Debug("Could not query the hash of %s: %v", path, err)
+			return nil
+		}
+		mu.Lock()
+		for path, hash := range found {
+			hashes[path] = hash
+		}
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		Debug("Stopped querying store path hashes: %v", err)
+	}
+	return hashes
+}
+
+// parseNarHashes reads the NAR hashes from "nix path-info --json", which
+// prints a list of path records in older Nix versions and an object keyed by
+// store path in newer ones
+func parseNarHashes(output []byte) map[string]string {
+	type pathInfo struct {
+		Path    string `json:"path"`
+		NarHash string `json:"narHash"`
+	}
+
+	hashes := make(map[string]string)
+	var list []pathInfo
+	if err := json.Unmarshal(output, &list); err == nil {
+		for _, info := range list {
+			if info.NarHash != "" {
+				hashes[info.Path] = info.NarHash
+			}
+		}
+		return hashes
+	}
+
+	var byPath map[string]*pathInfo
+	if err := json.Unmarshal(output, &byPath); err != nil {
+		Debug("Could not parse path information: %v", err)
+		return hashes
+	}
+	for path, info := range byPath {
+		if info != nil && info.NarHash != "" {
+			hashes[path] = info.NarHash
+		}
+	}
+	return hashes
+}
+
+// UpdateFlakeLock updates the flake.lock next to a flake.nix whose inputs
+// changed
+func UpdateFlakeLock(ctx context.Context, configFile string) error {
+	dir, err := filepath.Abs(filepath.Dir(configFile))
+	if err != nil {
+		return err
+	}
+	if _, err := nixOutput(ctx, "nix", "flake", "lock", "path:"+dir); err != nil {
+		return fmt.Errorf("failed to update flake.lock: %w", err)
+	}
+	return nil
+}
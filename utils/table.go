@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// TableStyle selects how tables are drawn
+type TableStyle string
+
+const (
+	// TablePlain aligns columns separated by |
+	TablePlain TableStyle = "plain"
+	// TableMarkdown renders a Markdown table
+	TableMarkdown TableStyle = "markdown"
+	// TableCSV renders comma-separated values with a header row
+	TableCSV TableStyle = "csv"
+	// TableBox draws the table with box-drawing characters
+	TableBox TableStyle = "box"
+)
+
+// TableStyles lists the accepted values of --table-style
+var TableStyles = []TableStyle{TablePlain, TableMarkdown, TableCSV, TableBox}
+
+var tableStyle = TablePlain
+
+// minColumnWidth is the narrowest a column gets when fitting a table to
+// the terminal
+const minColumnWidth = 3
+
+// ParseTableStyle validates the value of --table-style
+func ParseTableStyle(value string) (TableStyle, error) {
+	for _, style := range TableStyles {
+		if string(style) == strings.ToLower(value) {
+			return style, nil
+		}
+	}
+	return "", fmt.Errorf("unknown table style %q: use plain, markdown, csv or box", value)
+}
+
+// SetTableStyle selects the style of the tables printed by Table and
+// WriteTable
+func SetTableStyle(style TableStyle) {
+	tableStyle = style
+}
+
+// Table formats and prints tabular data
+func Table(headers []string, rows [][]string) {
+	WriteTable(os.Stdout, headers, rows)
+}
+
+// WriteTable formats tabular data to w in the selected table style, fitting
+// it to the width of the terminal w writes to
+func WriteTable(w io.Writer, headers []string, rows [][]string) {
+	_ = RenderTable(w, tableStyle, TerminalWidth(w), headers, rows)
+}
+
+// RenderTable writes tabular data to w in the given style. Plain and box
+// tables wider than maxWidth columns get their widest cells truncated; a
+// maxWidth of 0 means no limit.
+func RenderTable(w io.Writer, style TableStyle, maxWidth int, headers []string, rows [][]string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	switch style {
+	case TableMarkdown:
+		_, err := io.WriteString(w, MarkdownTable(headers, rows))
+		return err
+	case TableCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(headers); err != nil {
+			return err
+		}
+		if err := writer.WriteAll(rows); err != nil {
+			return err
+		}
+		return writer.Error()
+	case TableBox:
+		return writeBoxTable(w, maxWidth, headers, rows)
+	}
+	return writePlainTable(w, maxWidth, headers, rows)
+}
+
+// columnWidths measures the display width of each column. Rows are
+// normalized to one cell per header.
+func columnWidths(headers []string, rows [][]string) ([]int, [][]string) {
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = DisplayWidth(h)
+	}
+
+	normalized := make([][]string, len(rows))
+	for r, row := range rows {
+		cells := make([]string, len(headers))
+		copy(cells, row)
+		for i, cell := range cells {
+			if cellWidth := DisplayWidth(cell); cellWidth > widths[i] {
+				widths[i] = cellWidth
+			}
+		}
+		normalized[r] = cells
+	}
+	return widths, normalized
+}
+
+// fitWidths narrows the widest columns until the table, including overhead
+// columns for borders and separators, fits in maxWidth
+func fitWidths(widths []int, overhead, maxWidth int) {
+	if maxWidth <= 0 {
+		return
+	}
+	total := overhead
+	for _, width := range widths {
+		total += width
+	}
+	for total > maxWidth {
+		widest := 0
+		for i, width := range widths {
+			if width > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= minColumnWidth {
+			return
+		}
+		widths[widest]--
+		total--
+	}
+}
+
+// formatCells truncates and pads cells to the column widths
+func formatCells(cells []string, widths []int) []string {
+	formatted := make([]string, len(cells))
+	for i, cell := range cells {
+		formatted[i] = PadWidth(TruncateWidth(cell, widths[i]), widths[i])
+	}
+	return formatted
+}
+
+// writePlainTable aligns the columns, separated by |, under a dashed line
+func writePlainTable(w io.Writer, maxWidth int, headers []string, rows [][]string) error {
+	widths, rows := columnWidths(headers, rows)
+	fitWidths(widths, 3*(len(widths)-1), maxWidth)
+
+	var b strings.Builder
+	b.WriteString(strings.Join(formatCells(headers, widths), " | ") + "\n")
+	separator := make([]string, len(widths))
+	for i, width := range widths {
+		separator[i] = strings.Repeat("-", width)
+	}
+	b.WriteString(strings.Join(separator, "-+-") + "\n")
+	for _, row := range rows {
+		b.WriteString(strings.Join(formatCells(row, widths), " | ") + "\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeBoxTable draws the table with box-drawing characters
+func writeBoxTable(w io.Writer, maxWidth int, headers []string, rows [][]string) error {
+	widths, rows := columnWidths(headers, rows)
+	fitWidths(widths, 3*len(widths)+1, maxWidth)
+
+	border := func(left, middle, right string) string {
+		lines := make([]string, len(widths))
+		for i, width := range widths {
+			lines[i] = strings.Repeat("─", width+2)
+		}
+		return left + strings.Join(lines, middle) + right + "\n"
+	}
+	line := func(cells []string) string {
+		return "│ " + strings.Join(formatCells(cells, widths), " │ ") + " │\n"
+	}
+
+	var b strings.Builder
+	b.WriteString(border("┌", "┬", "┐"))
+	b.WriteString(line(headers))
+	b.WriteString(border("├", "┼", "┤"))
+	for _, row := range rows {
+		b.WriteString(line(row))
+	}
+	b.WriteString(border("└", "┴", "┘"))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// MarkdownTable renders tabular data as a Markdown table
+func MarkdownTable(headers []string, rows [][]string) string {
+	escape := func(cell string) string {
+		return strings.ReplaceAll(cell, "|", `\|`)
+	}
+	line := func(cells []string) string {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = escape(cell)
+		}
+		return "| " + strings.Join(escaped, " | ") + " |\n"
+	}
+
+	var b strings.Builder
+	b.WriteString(line(headers))
+	separator := make([]string, len(headers))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	b.WriteString(line(separator))
+	for _, row := range rows {
+		b.WriteString(line(row))
+	}
+	return b.String()
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+// ColorMode selects when messages are colored
+type ColorMode string
+
+const (
+	// ColorAuto colors output written to a terminal, unless NO_COLOR is set
+	// or TERM is dumb
+	ColorAuto ColorMode = "auto"
+	// ColorAlways colors all output, also when it is redirected
+	ColorAlways ColorMode = "always"
+	// ColorNever never colors output
+	ColorNever ColorMode = "never"
+)
+
+var colorMode = ColorAuto
+
+// ParseColorMode validates the value of --color
+func ParseColorMode(value string) (ColorMode, error) {
+	switch mode := ColorMode(strings.ToLower(value)); mode {
+	case ColorAuto, ColorAlways, ColorNever:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid color mode %q, must be auto, always or never", value)
+}
+
+// SetColorMode selects when messages are colored
+func SetColorMode(mode ColorMode) {
+	colorMode = mode
+}
+
+// colorEnabled reports whether output written to w is colored
+func colorEnabled(w io.Writer) bool {
+	switch colorMode {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return IsTerminal(w)
+}
+
+// IsTerminal reports whether w is a terminal rather than a file or pipe
+func IsTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// TerminalWidth returns the number of columns of the terminal w writes to,
+// or 0 when w is not a terminal. COLUMNS is used when the terminal cannot
+// tell its size.
+func TerminalWidth(w io.Writer) int {
+	if !IsTerminal(w) {
+		return 0
+	}
+	if columns := terminalColumns(w.(*os.File)); columns > 0 {
+		return columns
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return 0
+}
+
+// DisplayWidth returns the number of terminal columns s takes up. Wide
+// characters such as CJK and most emoji take two columns, combining marks
+// and other zero-width characters none.
+func DisplayWidth(s string) int {
+	total := 0
+	previous := 0
+	for _, r := range s {
+		w := runeWidth(r)
+		// A variation selector asking for emoji presentation widens the
+		// character before it
+		if r == '\uFE0F' && previous == 1 {
+			w = 1
+		}
+		total += w
+		previous = w
+	}
+	return total
+}
+
+// runeWidth returns the number of terminal columns of a single rune
+func runeWidth(r rune) int {
+	switch {
+	case r == 0:
+		return 0
+	case r < 0x20 || (r >= 0x7F && r < 0xA0):
+		return 0
+	case r == '\u200D' || (r >= '\uFE00' && r <= '\uFE0F'):
+		return 0
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// TruncateWidth shortens s to at most maxWidth terminal columns, marking
+// the cut with an ellipsis
+func TruncateWidth(s string, maxWidth int) string {
+	if DisplayWidth(s) <= maxWidth {
+		return s
+	}
+	if maxWidth <= 0 {
+		return ""
+	}
+
+	const ellipsis = "…"
+	var b strings.Builder
+	used := 0
+	for _, r := range s {
+		w := runeWidth(r)
+		if used+w > maxWidth-1 {
+			break
+		}
+		b.WriteRune(r)
+		used += w
+	}
+	b.WriteString(ellipsis)
+	return b.String()
+}
+
+// PadWidth pads s with spaces to width terminal columns
+func PadWidth(s string, width int) string {
+	if padding := width - DisplayWidth(s); padding > 0 {
+		return s + strings.Repeat(" ", padding)
+	}
+	return s
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// terminalColumns is not supported on this platform, so TerminalWidth
+// falls back to COLUMNS
+func terminalColumns(file *os.File) int {
+	return 0
+}
@@ -0,0 +1,376 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// NixpkgsSource identifies a nixpkgs snapshot: a commit and, when known, the
+// hash of its source tree
+type NixpkgsSource struct {
+	Revision string `json:"revision"`
+	NarHash  string `json:"narHash,omitempty"`
+}
+
+// VersionRecord states that a nixpkgs revision provides a package version
+type VersionRecord struct {
+	Attr     string `json:"attr"`
+	Version  string `json:"version"`
+	Revision string `json:"revision"`
+	NarHash  string `json:"narHash,omitempty"`
+}
+
+// Source returns the nixpkgs snapshot of the record
+func (r VersionRecord) Source() NixpkgsSource {
+	return NixpkgsSource{Revision: r.Revision, NarHash: r.NarHash}
+}
+
+// RevisionInfo describes an indexed nixpkgs revision
+type RevisionInfo struct {
+	NarHash   string    `json:"narHash,omitempty"`
+	IndexedAt time.Time `json:"indexedAt"`
+}
+
+// VersionDB is the local version history: which nixpkgs revision provides
+// each known version of a package. The first revision recorded for a version
+// is kept so existing pins stay stable.
+type VersionDB struct {
+	Revisions map[string]RevisionInfo `json:"revisions"`
+	// Packages maps attribute names to versions to the revision providing them
+	Packages map[string]map[string]string `json:"packages"`
+}
+
+// versionDBPath returns the location of the version history database
+func versionDBPath() (string, error) {
+	configDir, err := EnsureConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "versions.json"), nil
+}
+
+// LoadVersionDB reads the version history database, which starts out empty
+func LoadVersionDB() (*VersionDB, error) {
+	db := &VersionDB{
+		Revisions: make(map[string]RevisionInfo),
+		Packages:  make(map[string]map[string]string),
+	}
+
+	path, err := versionDBPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, db); err != nil {
+		return nil, fmt.Errorf("failed to parse version database: %w", err)
+	}
+	if db.Revisions == nil {
+		db.Revisions = make(map[string]RevisionInfo)
+	}
+	if db.Packages == nil {
+		db.Packages = make(map[string]map[string]string)
+	}
+	return db, nil
+}
+
+// Save writes the version history database
+func (db *VersionDB) Save() error {
+	path, err := versionDBPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(db)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data, 0600)
+}
+
+// Add records the given versions and returns how many were not known before
+func (db *VersionDB) Add(records ...VersionRecord) int {
+	added := 0
+	for _, record := range records {
+		if record.Attr == "" || record.Version == "" || !IsNixpkgsCommit(record.Revision) {
+			continue
+		}
+
+		info, ok := db.Revisions[record.Revision]
+		if !ok {
+			info.IndexedAt = time.Now().UTC()
+		}
+		if info.NarHash == "" {
+			info.NarHash = record.NarHash
+		}
+		db.Revisions[record.Revision] = info
+
+		versions := db.Packages[record.Attr]
+		if versions == nil {
+			versions = make(map[string]string)
+			db.Packages[record.Attr] = versions
+		}
+		if _, ok := versions[record.Version]; !ok {
+			versions[record.Version] = record.Revision
+			added++
+		}
+	}
+	return added
+}
+
+// SetNarHash records the source hash of a revision, which is added to the
+// known revisions when it is new
+func (db *VersionDB) SetNarHash(revision, narHash string) {
+	info, ok := db.Revisions[revision]
+	if !ok {
+		info.IndexedAt = time.Now().UTC()
+	}
+	info.NarHash = narHash
+	db.Revisions[revision] = info
+}
+
+// Versions returns the known versions of a package, newest first
+func (db *VersionDB) Versions(attr string) []VersionRecord {
+	var records []VersionRecord
+	for version, revision := range db.Packages[attr] {
+		records = append(records, VersionRecord{
+			Attr:     attr,
+			Version:  version,
+			Revision: revision,
+			NarHash:  db.Revisions[revision].NarHash,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return CompareVersions(records[i].Version, records[j].Version) > 0
+	})
+	return records
+}
+
+// Lookup finds the revision providing version of attr. An exact match wins;
+// otherwise the newest version matching the requested prefix is used.
+func (db *VersionDB) Lookup(attr, version string) (VersionRecord, bool) {
+	for _, record := range db.Versions(attr) {
+		if record.Version == strings.TrimPrefix(version, "v") {
+			return record, true
+		}
+	}
+	for _, record := range db.Versions(attr) {
+		if VersionMatches(record.Version, version) {
+			return record, true
+		}
+	}
+	return VersionRecord{}, false
+}
+
+// Records returns every record of the database sorted by attribute and version
+func (db *VersionDB) Records() []VersionRecord {
+	var attrs []string
+	for attr := range db.Packages {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+
+	var records []VersionRecord
+	for _, attr := range attrs {
+		records = append(records, db.Versions(attr)...)
+	}
+	return records
+}
+
+// Import reads a JSON dump of version records, either a list of records or
+// an object with a "records" list, and returns how many versions were new
+func (db *VersionDB) Import(r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var records []VersionRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		var dump struct {
+			Records []VersionRecord `json:"records"`
+		}
+		if err := json.Unmarshal(data, &dump); err != nil {
+			return 0, fmt.Errorf("failed to parse version dump: %w", err)
+		}
+		records = dump.Records
+	}
+
+	for _, record := range records {
+		if !IsNixpkgsCommit(record.Revision) {
+			return 0, fmt.Errorf("invalid revision %q for %s %s", record.Revision, record.Attr, record.Version)
+		}
+	}
+	return db.Add(records...), nil
+}
+
+// AddIndex records the versions of every package in a package index
+func (db *VersionDB) AddIndex(index *PackageIndex, narHash string) int {
+	records := make([]VersionRecord, 0, len(index.Packages))
+	for _, pkg := range index.Packages {
+		records = append(records, VersionRecord{
+			Attr:     pkg.Attr,
+			Version:  pkg.Version,
+			Revision: index.Revision,
+			NarHash:  narHash,
+		})
+	}
+	return db.Add(records...)
+}
+
+// recordIndexVersions feeds a freshly built package index into the version
+// history, so every channel snapshot the user evaluates becomes resolvable
+func recordIndexVersions(index *PackageIndex) {
+	if !IsNixpkgsCommit(index.Revision) {
+		return
+	}
+	db, err := LoadVersionDB()
+	if err != nil {
+		Debug("Could not load version database: %v", err)
+		return
+	}
+	if added := db.AddIndex(index, ""); added > 0 {
+		if err := db.Save(); err != nil {
+			Debug("Could not save version database: %v", err)
+		}
+	}
+}
+
+// PrefetchNixpkgs downloads a nixpkgs revision into the store and returns
+// its source hash and store path
+func PrefetchNixpkgs(ctx context.Context, revision string) (narHash, storePath string, err error) {
+	output, err := nixOutput(ctx, "nix", "flake", "prefetch", "--json", NixpkgsFlakeRef(revision))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to fetch nixpkgs %s: %w", revision, err)
+	}
+
+	var result struct {
+		Hash      string `json:"hash"`
+		StorePath string `json:"storePath"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return "", "", fmt.Errorf("failed to parse prefetch result: %w", err)
+	}
+	if result.Hash == "" {
+		return "", "", fmt.Errorf("no hash reported for nixpkgs %s", revision)
+	}
+	return result.Hash, result.StorePath, nil
+}
+
+// IndexNixpkgsRevision fetches a nixpkgs commit, records the version of every
+// package it provides and returns how many versions were new
+func IndexNixpkgsRevision(ctx context.Context, db *VersionDB, revision string) (int, error) {
+	if !IsNixpkgsCommit(revision) {
+		return 0, fmt.Errorf("invalid nixpkgs revision %q: expected a full commit hash", revision)
+	}
+
+	narHash, storePath, err := PrefetchNixpkgs(ctx, revision)
+	if err != nil {
+		return 0, err
+	}
+
+	Info("📚 Indexing package versions of nixpkgs %s...", revision[:12])
+	output, err := nixOutput(ctx, "nix-env", "-f", storePath, "-qaP", "--json")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query nixpkgs packages: %w", err)
+	}
+
+	var entries map[string]nixEnvPackage
+	if err := json.Unmarshal(output, &entries); err != nil {
+		return 0, fmt.Errorf("failed to parse nixpkgs packages: %w", err)
+	}
+
+	records := make([]VersionRecord, 0, len(entries))
+	for attr, entry := range entries {
+		records = append(records, VersionRecord{Attr: attr, Version: entry.Version, Revision: revision, NarHash: narHash})
+	}
+	added := db.Add(records...)
+	db.SetNarHash(revision, narHash)
+	return added, nil
+}
+
+// ResolveVersion finds the nixpkgs snapshot providing version of pkg. The
+// version history is consulted first, then the configured channel. The
+// source hash of the snapshot is fetched when it is not known yet.
+func ResolveVersion(ctx context.Context, pkg, version string) (VersionRecord, error) {
+	db, err := LoadVersionDB()
+	if err != nil {
+		return VersionRecord{}, err
+	}
+
+	record, ok := db.Lookup(pkg, version)
+	if !ok {
+		revision, err := ResolvePinRevision(ctx, pkg, version)
+		if err != nil {
+			return VersionRecord{}, err
+		}
+		record = VersionRecord{Attr: pkg, Version: strings.TrimPrefix(version, "v"), Revision: revision}
+		db.Add(record)
+	}
+
+	if record.NarHash == "" {
+		if narHash, _, err := PrefetchNixpkgs(ctx, record.Revision); err != nil {
+			Debug("Could not determine the hash of nixpkgs %s: %v", record.Revision, err)
+		} else {
+			record.NarHash = narHash
+			db.SetNarHash(record.Revision, narHash)
+		}
+	}
+
+	if err := db.Save(); err != nil {
+		Debug("Could not save version database: %v", err)
+	}
+	return record, nil
+}
+
+// CompareVersions orders version strings component by component, comparing
+// numeric components as numbers. It returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	}
+	pa, pb := split(a), split(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				return cmpInt(na, nb)
+			}
+		case pa[i] != pb[i]:
+			// Numeric components sort after pre-release tags such as "rc1"
+			if errA == nil {
+				return 1
+			}
+			if errB == nil {
+				return -1
+			}
+			return strings.Compare(pa[i], pb[i])
+		}
+	}
+	return cmpInt(len(pa), len(pb))
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+// NixJobs is the number of Nix evaluations NSM runs at the same time
+var NixJobs = 4
+
+// ForEach calls fn for every item on at most workers goroutines. The first
+// error cancels the context passed to the remaining calls and is returned;
+// items that have not started yet are skipped. A cancelled ctx stops the
+// pool the same way.
+func ForEach[T any](ctx context.Context, workers int, items []T, fn func(context.Context, T) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	jobs := make(chan T)
+	for i := 0; i < min(workers, len(items)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				if err := fn(ctx, item); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, item := range items {
+		select {
+		case jobs <- item:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}